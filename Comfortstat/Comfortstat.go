@@ -88,6 +88,12 @@ func (t *UnitAsset) Serving(w http.ResponseWriter, r *http.Request, servicePath
 		t.httpSetUserTemp(w, r)
 	case "Region":
 		t.httpSetRegion(w, r)
+	case "CarbonIntensity":
+		t.httpSetCarbonIntensity(w, r)
+	case "CarbonWeight":
+		t.httpSetCarbonWeight(w, r)
+	case "BlendedScore":
+		t.httpSetBlendedScore(w, r)
 	default:
 		http.Error(w, "Invalid service request [Do not modify the services subpath in the configurration file]", http.StatusBadRequest)
 	}
@@ -232,3 +238,40 @@ func (rsc *UnitAsset) httpSetRegion(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method is not supported.", http.StatusNotFound)
 	}
 }
+
+func (rsc *UnitAsset) httpSetCarbonIntensity(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		signalErr := rsc.getCarbonIntensity()
+		usecases.HTTPProcessGetRequest(w, r, &signalErr)
+	default:
+		http.Error(w, "Method is not supported.", http.StatusNotFound)
+	}
+}
+
+func (rsc *UnitAsset) httpSetCarbonWeight(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "PUT":
+		sig, err := usecases.HTTPProcessSetRequest(w, r)
+		if err != nil {
+			http.Error(w, "request incorrectly formatted", http.StatusBadRequest)
+			return
+		}
+		rsc.setCarbonWeight(sig)
+	case "GET":
+		signalErr := rsc.getCarbonWeight()
+		usecases.HTTPProcessGetRequest(w, r, &signalErr)
+	default:
+		http.Error(w, "Method is not supported.", http.StatusNotFound)
+	}
+}
+
+func (rsc *UnitAsset) httpSetBlendedScore(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		signalErr := rsc.getBlendedScore()
+		usecases.HTTPProcessGetRequest(w, r, &signalErr)
+	default:
+		http.Error(w, "Method is not supported.", http.StatusNotFound)
+	}
+}
@@ -539,3 +539,73 @@ func TestHttpSetRegion(t *testing.T) {
 		t.Errorf("expected the status to be bad but got: %v", resp.StatusCode)
 	}
 }
+
+func TestHttpSetCarbonServices(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	ua.CarbonIntensity = 110
+
+	// Good case test: GET carbon intensity
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://localhost:8670/Comfortstat/Set%20Values/CarbonIntensity", nil)
+	ua.httpSetCarbonIntensity(w, r)
+	resp := w.Result()
+	if resp.StatusCode != 200 {
+		t.Errorf("expected good status code: %v, got %v", 200, resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), `"value": 110`) {
+		t.Errorf("expected the value statement to be true!")
+	}
+	if !strings.Contains(string(body), `"unit": "gCO2eq/kWh"`) {
+		t.Errorf("expected the unit statement to be true!")
+	}
+
+	// Good case test: PUT carbon weight
+	w = httptest.NewRecorder()
+	fakebody := bytes.NewReader([]byte(`{"value": 0.25, "version": "SignalA_v1.0"}`))
+	r = httptest.NewRequest("PUT", "http://localhost:8670/Comfortstat/Set%20Values/CarbonWeight", fakebody)
+	r.Header.Set("Content-Type", "application/json")
+	ua.httpSetCarbonWeight(w, r)
+	resp = w.Result()
+	if resp.StatusCode != 200 || ua.CarbonWeight != 0.25 {
+		t.Errorf("expected carbon weight 0.25 with status 200, got %v with status %v", ua.CarbonWeight, resp.StatusCode)
+	}
+	// Bad case test: PUT carbon weight with a broken body
+	w = httptest.NewRecorder()
+	fakebody = bytes.NewReader([]byte(`{"123, "version": "SignalA_v1.0"}`))
+	r = httptest.NewRequest("PUT", "http://localhost:8670/Comfortstat/Set%20Values/CarbonWeight", fakebody)
+	r.Header.Set("Content-Type", "application/json")
+	ua.httpSetCarbonWeight(w, r)
+	resp = w.Result()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected bad status code, got %v", resp.StatusCode)
+	}
+	// Good case test: GET carbon weight
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "http://localhost:8670/Comfortstat/Set%20Values/CarbonWeight", nil)
+	ua.httpSetCarbonWeight(w, r)
+	body, _ = io.ReadAll(w.Result().Body)
+	if !strings.Contains(string(body), `"value": 0.25`) {
+		t.Errorf("expected the value statement to be true!")
+	}
+
+	// Good case test: GET blended score
+	ua.BlendedScore = 0.5
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "http://localhost:8670/Comfortstat/Set%20Values/BlendedScore", nil)
+	ua.httpSetBlendedScore(w, r)
+	body, _ = io.ReadAll(w.Result().Body)
+	if !strings.Contains(string(body), `"value": 0.5`) {
+		t.Errorf("expected the value statement to be true!")
+	}
+
+	// Bad test case: default part of code
+	for _, f := range []func(http.ResponseWriter, *http.Request){ua.httpSetCarbonIntensity, ua.httpSetCarbonWeight, ua.httpSetBlendedScore} {
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest("123", "http://localhost:8670/Comfortstat/Set%20Values/BlendedScore", nil)
+		f(w, r)
+		if w.Result().StatusCode != http.StatusNotFound {
+			t.Errorf("expected the status to be bad but got: %v", w.Result().StatusCode)
+		}
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/sdoque/mbaigo/components"
@@ -53,6 +55,25 @@ type UnitAsset struct {
 	MaxTemp        float64 `json:"MaxTemp"`
 	UserTemp       float64 `json:"UserTemp"`
 	Region         float64 `json:"Region"` // the user can choose from what region the SEKPrice is taken from
+	//
+	CarbonSource    string  `json:"CarbonSource"` // http(s) URL or local file path to a carbon intensity series, leave empty to disable
+	CarbonWeight    float64 `json:"CarbonWeight"` // 0 = only price matters, 1 = only carbon intensity matters
+	MinCarbon       float64 `json:"MinCarbon"`
+	MaxCarbon       float64 `json:"MaxCarbon"`
+	CarbonIntensity float64 `json:"CarbonIntensity"`
+	BlendedScore    float64 `json:"BlendedScore"`
+	carbonData      []CarbonData
+	carbonFetched   time.Time
+	carbonCurrent   bool // CarbonIntensity is for the current hour, otherwise only the price is used
+	carbonWarned    bool // The missing carbon intensity has been logged
+	carbonFailing   bool // The latest fetch from the carbon source failed, which has been logged
+}
+
+// CarbonData is a single entry in a carbon intensity series, in gCO2eq per kWh
+type CarbonData struct {
+	Intensity float64 `json:"carbonIntensity"`
+	TimeStart string  `json:"time_start"`
+	TimeEnd   string  `json:"time_end"`
 }
 
 // SE1: Norra Sverige/Luleå   		(value = 1)
@@ -160,6 +181,93 @@ func getAPIPriceData(apiURL string) error {
 	return nil
 }
 
+// This function fetches a carbon intensity series from the configured source, which can either be a http(s)
+// URL returning JSON or a path to a local JSON file, and saves it in the unit asset
+func (ua *UnitAsset) getCarbonData() error {
+	var body []byte
+	parsedURL, err := url.Parse(ua.CarbonSource)
+	if err != nil {
+		return errors.New("The carbon source is invalid")
+	}
+	switch parsedURL.Scheme {
+	case "http", "https":
+		if parsedURL.Host == "" {
+			return errors.New("The carbon source is invalid")
+		}
+		res, err := http.Get(parsedURL.String())
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		body, err = io.ReadAll(res.Body)
+		if err != nil {
+			return err
+		}
+		if res.StatusCode > 299 {
+			return errStatuscode
+		}
+	default:
+		body, err = os.ReadFile(filepath.Clean(ua.CarbonSource))
+		if err != nil {
+			return err
+		}
+	}
+
+	var series []CarbonData
+	err = json.Unmarshal(body, &series)
+	if err != nil {
+		return err
+	}
+	ua.carbonData = series
+	return nil
+}
+
+// currentCarbonIntensity looks up the carbon intensity for the given time in the saved series
+func (ua *UnitAsset) currentCarbonIntensity(now time.Time) (float64, bool) {
+	for _, c := range ua.carbonData {
+		start, err := time.Parse(time.RFC3339, c.TimeStart)
+		if err != nil {
+			continue
+		}
+		end, err := time.Parse(time.RFC3339, c.TimeEnd)
+		if err != nil {
+			continue
+		}
+		if !now.Before(start) && now.Before(end) {
+			return c.Intensity, true
+		}
+	}
+	return 0, false
+}
+
+// updateCarbonIntensity refreshes the carbon intensity series as often as the prices, and looks up the current
+// value. Without a current value the carbon intensity is ignored, instead of using an old (or zero) value.
+func (ua *UnitAsset) updateCarbonIntensity(now time.Time) {
+	intensity, ok := 0.0, false
+	if ua.CarbonSource != "" {
+		if time.Since(ua.carbonFetched) >= time.Duration(apiFetchPeriod)*time.Second {
+			// A failing source is also tried again after the fetch period, and only logged once until it works again
+			ua.carbonFetched = time.Now()
+			err := ua.getCarbonData()
+			if err != nil && !ua.carbonFailing {
+				log.Printf("cannot get carbon intensity data, trying again every %d seconds: %s\n", apiFetchPeriod, err)
+			}
+			ua.carbonFailing = err != nil
+		}
+		intensity, ok = ua.currentCarbonIntensity(now)
+	}
+	ua.carbonCurrent = ok
+	if ok {
+		ua.CarbonIntensity = intensity
+		ua.carbonWarned = false
+		return
+	}
+	if ua.CarbonWeight > 0 && !ua.carbonWarned {
+		log.Printf("no current carbon intensity, using only the price until there is one\n")
+		ua.carbonWarned = true
+	}
+}
+
 // GetName returns the name of the Resource.
 func (ua *UnitAsset) GetName() string {
 	return ua.Name
@@ -238,6 +346,24 @@ func initTemplate() components.UnitAsset {
 		Details:     map[string][]string{"Forms": {"SignalA_v1a"}},
 		Description: "provides the temperature the user wants regardless of prices (using a GET request)",
 	}
+	setCarbonIntensity := components.Service{
+		Definition:  "CarbonIntensity",
+		SubPath:     "CarbonIntensity",
+		Details:     map[string][]string{"Unit": {"gCO2eq/kWh"}, "Forms": {"SignalA_v1a"}},
+		Description: "provides the current carbon intensity of the grid (using a GET request)",
+	}
+	setCarbonWeight := components.Service{
+		Definition:  "CarbonWeight",
+		SubPath:     "CarbonWeight",
+		Details:     map[string][]string{"Forms": {"SignalA_v1a"}},
+		Description: "provides how much the carbon intensity matters compared to the price, between 0 and 1 (using a GET request) or changes it (using a PUT request)",
+	}
+	setBlendedScore := components.Service{
+		Definition:  "BlendedScore",
+		SubPath:     "BlendedScore",
+		Details:     map[string][]string{"Forms": {"SignalA_v1a"}},
+		Description: "provides the weighted price and carbon score used for the desired temperature, between 0 and 1 (using a GET request)",
+	}
 
 	return &UnitAsset{
 		//These fields should reflect a unique asset (ie, a single sensor with unique ID and location)
@@ -252,17 +378,25 @@ func initTemplate() components.UnitAsset {
 		Period:      15,
 		UserTemp:    0,
 		Region:      1,
+		// Carbon intensity is disabled until a source is set
+		CarbonSource: "",
+		CarbonWeight: 0,
+		MinCarbon:    20,  // Low carbon intensity in gCO2eq/kWh
+		MaxCarbon:    200, // High carbon intensity in gCO2eq/kWh
 
 		// maps the provided services from above
 		ServicesMap: components.Services{
-			setMaxTemp.SubPath:     &setMaxTemp,
-			setMinTemp.SubPath:     &setMinTemp,
-			setMaxPrice.SubPath:    &setMaxPrice,
-			setMinPrice.SubPath:    &setMinPrice,
-			setSEKPrice.SubPath:    &setSEKPrice,
-			setDesiredTemp.SubPath: &setDesiredTemp,
-			setUserTemp.SubPath:    &setUserTemp,
-			setRegion.SubPath:      &setRegion,
+			setMaxTemp.SubPath:         &setMaxTemp,
+			setMinTemp.SubPath:         &setMinTemp,
+			setMaxPrice.SubPath:        &setMaxPrice,
+			setMinPrice.SubPath:        &setMinPrice,
+			setSEKPrice.SubPath:        &setSEKPrice,
+			setDesiredTemp.SubPath:     &setDesiredTemp,
+			setUserTemp.SubPath:        &setUserTemp,
+			setRegion.SubPath:          &setRegion,
+			setCarbonIntensity.SubPath: &setCarbonIntensity,
+			setCarbonWeight.SubPath:    &setCarbonWeight,
+			setBlendedScore.SubPath:    &setBlendedScore,
 		},
 	}
 }
@@ -287,19 +421,24 @@ func newUnitAsset(uac UnitAsset, sys *components.System, servs []components.Serv
 
 	ua := &UnitAsset{
 		// Filling in public fields using the given data
-		Name:        uac.Name,
-		Owner:       sys,
-		Details:     uac.Details,
-		ServicesMap: components.CloneServices(servs),
-		SEKPrice:    uac.SEKPrice,
-		MinPrice:    uac.MinPrice,
-		MaxPrice:    uac.MaxPrice,
-		MinTemp:     uac.MinTemp,
-		MaxTemp:     uac.MaxTemp,
-		DesiredTemp: uac.DesiredTemp,
-		Period:      uac.Period,
-		UserTemp:    uac.UserTemp,
-		Region:      uac.Region,
+		Name:            uac.Name,
+		Owner:           sys,
+		Details:         uac.Details,
+		ServicesMap:     components.CloneServices(servs),
+		SEKPrice:        uac.SEKPrice,
+		MinPrice:        uac.MinPrice,
+		MaxPrice:        uac.MaxPrice,
+		MinTemp:         uac.MinTemp,
+		MaxTemp:         uac.MaxTemp,
+		DesiredTemp:     uac.DesiredTemp,
+		Period:          uac.Period,
+		UserTemp:        uac.UserTemp,
+		Region:          uac.Region,
+		CarbonSource:    uac.CarbonSource,
+		CarbonWeight:    uac.CarbonWeight,
+		MinCarbon:       uac.MinCarbon,
+		MaxCarbon:       uac.MaxCarbon,
+		CarbonIntensity: uac.CarbonIntensity,
 		CervicesMap: components.Cervices{
 			t.Name: t,
 		},
@@ -427,6 +566,36 @@ func (ua *UnitAsset) getRegion() (f forms.SignalA_v1a) {
 	return f
 }
 
+// getCarbonIntensity is used for reading the current carbon intensity
+func (ua *UnitAsset) getCarbonIntensity() (f forms.SignalA_v1a) {
+	f.NewForm()
+	f.Value = ua.CarbonIntensity
+	f.Unit = "gCO2eq/kWh"
+	f.Timestamp = time.Now()
+	return f
+}
+
+// getCarbonWeight is used for reading how much the carbon intensity matters
+func (ua *UnitAsset) getCarbonWeight() (f forms.SignalA_v1a) {
+	f.NewForm()
+	f.Value = ua.CarbonWeight
+	f.Timestamp = time.Now()
+	return f
+}
+
+// setCarbonWeight updates the carbon weight, the value is kept between 0 and 1
+func (ua *UnitAsset) setCarbonWeight(f forms.SignalA_v1a) {
+	ua.CarbonWeight = clamp(f.Value, 0, 1)
+}
+
+// getBlendedScore is used for reading the current weighted price and carbon score
+func (ua *UnitAsset) getBlendedScore() (f forms.SignalA_v1a) {
+	f.NewForm()
+	f.Value = ua.BlendedScore
+	f.Timestamp = time.Now()
+	return f
+}
+
 // feedbackLoop is THE control loop (IPR of the system)
 func (ua *UnitAsset) feedbackLoop(ctx context.Context) {
 	// Initialize a ticker for periodic execution
@@ -458,6 +627,9 @@ func (ua *UnitAsset) processFeedbackLoop() {
 
 	ua.SEKPrice = globalPrice.SEKPrice
 
+	ua.updateCarbonIntensity(time.Now())
+	ua.BlendedScore = ua.calculateBlendedScore()
+
 	//ua.DesiredTemp = ua.calculateDesiredTemp(miT, maT, miP, maP, ua.getSEKPrice().Value)
 	ua.DesiredTemp = ua.calculateDesiredTemp()
 	// Only send temperature update when we have a new value.
@@ -493,8 +665,12 @@ func (ua *UnitAsset) processFeedbackLoop() {
 }
 
 // Calculates the new most optimal temperature (desierdTemp) based on the price/temprature intervals
-// and the current electricity price
+// and the current electricity price, or the blended price and carbon score if a carbon weight is set
+// and the carbon intensity is current
 func (ua *UnitAsset) calculateDesiredTemp() float64 {
+	if ua.CarbonWeight > 0 && ua.carbonCurrent {
+		return ua.MaxTemp - ua.calculateBlendedScore()*(ua.MaxTemp-ua.MinTemp)
+	}
 
 	if ua.SEKPrice <= ua.MinPrice {
 		return ua.MaxTemp
@@ -510,6 +686,40 @@ func (ua *UnitAsset) calculateDesiredTemp() float64 {
 	return DesiredTemp
 }
 
+// Calculates a score between 0 (cheap and clean) and 1 (expensive and dirty) by scaling the price
+// and carbon intensity within their min/max intervals and weighting them with the carbon weight.
+// Only the price is used when the carbon intensity isn't current.
+func (ua *UnitAsset) calculateBlendedScore() float64 {
+	priceScore := normalise(ua.SEKPrice, ua.MinPrice, ua.MaxPrice)
+	carbonScore := normalise(ua.CarbonIntensity, ua.MinCarbon, ua.MaxCarbon)
+	w := clamp(ua.CarbonWeight, 0, 1)
+	if !ua.carbonCurrent {
+		w = 0
+	}
+	return (1-w)*priceScore + w*carbonScore
+}
+
+// normalise scales the value to 0-1 within the min/max interval
+func normalise(value, min, max float64) float64 {
+	if max <= min {
+		if value < max {
+			return 0
+		}
+		return 1
+	}
+	return clamp((value-min)/(max-min), 0, 1)
+}
+
+func clamp(value, min, max float64) float64 {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
+
 func (ua *UnitAsset) sendUserTemp() {
 	var of forms.SignalA_v1a
 	of.NewForm()
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"strings"
 	"testing"
 	"time"
@@ -499,3 +500,148 @@ func TestGetAPIPriceData(t *testing.T) {
 		t.Errorf("expected an error, got %v :", err)
 	}
 }
+
+// carbon intensity example string in a JSON-like format, covering the current hour
+var carbonExample string = fmt.Sprintf(`[{
+		"carbonIntensity": 110,
+		"time_start": "%s",
+		"time_end": "%s"
+		}]`, time.Now().Add(-time.Hour).Format(time.RFC3339), time.Now().Add(time.Hour).Format(time.RFC3339),
+)
+
+func TestGetCarbonData(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	resp := &http.Response{
+		Status:     "200 OK",
+		StatusCode: 200,
+		Body:       io.NopCloser(strings.NewReader(carbonExample)),
+	}
+	// Good case: fetching the series from a http source
+	ua.CarbonSource = "https://carbon.example.com/series.json"
+	newMockTransport(resp)
+	err := ua.getCarbonData()
+	if err != nil {
+		t.Errorf("expected no errors but got %s :", err)
+	}
+	intensity, ok := ua.currentCarbonIntensity(time.Now())
+	if !ok || intensity != 110 {
+		t.Errorf("expected carbon intensity to be 110, got %v (found: %v)", intensity, ok)
+	}
+	// Good case: fetching the series from a local file
+	path := t.TempDir() + "/carbon.json"
+	if err := os.WriteFile(path, []byte(carbonExample), 0600); err != nil {
+		t.Fatalf("failed to write test file: %s", err)
+	}
+	ua.carbonData = nil
+	ua.CarbonSource = path
+	err = ua.getCarbonData()
+	if err != nil {
+		t.Errorf("expected no errors but got %s :", err)
+	}
+	if len(ua.carbonData) != 1 {
+		t.Errorf("expected one entry in the carbon series, got %d", len(ua.carbonData))
+	}
+	// Bad case: no entry found outside of the series
+	_, ok = ua.currentCarbonIntensity(time.Now().Add(24 * time.Hour))
+	if ok {
+		t.Errorf("expected no carbon intensity to be found")
+	}
+	// Bad case: missing file
+	ua.CarbonSource = path + ".missing"
+	if err = ua.getCarbonData(); err == nil {
+		t.Errorf("expected an error but got none!")
+	}
+	// Bad case: broken body
+	ua.CarbonSource = "https://carbon.example.com/series.json"
+	resp.Body = errReader(0)
+	newMockTransport(resp)
+	if err = ua.getCarbonData(); err != errBodyRead {
+		t.Errorf("expected an error %v, got %v", errBodyRead, err)
+	}
+	// Bad case: status code > 299
+	resp.Body = io.NopCloser(strings.NewReader(carbonExample))
+	resp.StatusCode = 300
+	newMockTransport(resp)
+	if err = ua.getCarbonData(); err != errStatuscode {
+		t.Errorf("expected an bad status code but got %v", err)
+	}
+	// Bad case: unmarshal a bad body
+	resp.Body = io.NopCloser(strings.NewReader(carbonExample + "123"))
+	resp.StatusCode = 200
+	newMockTransport(resp)
+	if err = ua.getCarbonData(); err == nil {
+		t.Errorf("expected an error, got %v :", err)
+	}
+}
+
+// Test if the blended score and desired temperature takes the carbon weight into account
+func TestCalculateBlendedScore(t *testing.T) {
+	asset := initTemplate().(*UnitAsset)
+	asset.CarbonIntensity = asset.MaxCarbon
+	asset.carbonCurrent = true
+
+	// Only the price matters (1.5 SEK is halfway between min and max price)
+	if score := asset.calculateBlendedScore(); score != 0.5 {
+		t.Errorf("expected score to be 0.5, got %v", score)
+	}
+	// Half of the score comes from the (high) carbon intensity
+	asset.setCarbonWeight(forms.SignalA_v1a{Value: 0.5})
+	if score := asset.calculateBlendedScore(); score != 0.75 {
+		t.Errorf("expected score to be 0.75, got %v", score)
+	}
+	if temp := asset.calculateDesiredTemp(); temp != 21.25 {
+		t.Errorf("expected desired temperature to be 21.25, got %v", temp)
+	}
+	// Weight is capped to 1, so only the carbon intensity matters
+	asset.setCarbonWeight(forms.SignalA_v1a{Value: 3})
+	if asset.CarbonWeight != 1 {
+		t.Errorf("expected carbon weight to be capped to 1, got %v", asset.CarbonWeight)
+	}
+	if temp := asset.calculateDesiredTemp(); temp != asset.MinTemp {
+		t.Errorf("expected desired temperature to be %v, got %v", asset.MinTemp, temp)
+	}
+	// An old carbon intensity is ignored, so only the price matters
+	asset.carbonCurrent = false
+	if score := asset.calculateBlendedScore(); score != 0.5 {
+		t.Errorf("expected score to be 0.5, got %v", score)
+	}
+	if temp := asset.calculateDesiredTemp(); temp != 22.5 {
+		t.Errorf("expected desired temperature to be 22.5, got %v", temp)
+	}
+}
+
+// Test if the carbon intensity is only current when the series covers the current hour
+func TestUpdateCarbonIntensity(t *testing.T) {
+	asset := initTemplate().(*UnitAsset)
+	asset.CarbonWeight = 0.5
+	asset.updateCarbonIntensity(time.Now())
+	if asset.carbonCurrent || !asset.carbonWarned {
+		t.Errorf("expected no current carbon intensity without a source")
+	}
+
+	path := t.TempDir() + "/carbon.json"
+	if err := os.WriteFile(path, []byte(carbonExample), 0600); err != nil {
+		t.Fatalf("cannot write the carbon series: %s", err)
+	}
+	// A missing file is only tried again after the fetch period
+	asset.CarbonSource = path + ".missing"
+	asset.updateCarbonIntensity(time.Now())
+	if !asset.carbonFailing || asset.carbonFetched.IsZero() {
+		t.Errorf("expected the failed fetch to be noted, got %v (failing: %v)", asset.carbonFetched, asset.carbonFailing)
+	}
+	asset.CarbonSource = path
+	asset.updateCarbonIntensity(time.Now())
+	if asset.carbonCurrent || len(asset.carbonData) != 0 {
+		t.Errorf("expected no new fetch before the fetch period has passed")
+	}
+	asset.carbonFetched = time.Time{}
+	asset.updateCarbonIntensity(time.Now())
+	if asset.carbonFailing || !asset.carbonCurrent || asset.carbonWarned || asset.CarbonIntensity != 110 {
+		t.Errorf("expected current carbon intensity 110, got %v (current: %v)", asset.CarbonIntensity, asset.carbonCurrent)
+	}
+	// The series doesn't cover tomorrow, so the old value isn't current anymore
+	asset.updateCarbonIntensity(time.Now().Add(24 * time.Hour))
+	if asset.carbonCurrent || !asset.carbonWarned || asset.CarbonIntensity != 110 {
+		t.Errorf("expected the carbon intensity to not be current, got %v (current: %v)", asset.CarbonIntensity, asset.carbonCurrent)
+	}
+}
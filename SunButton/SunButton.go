@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
+	_ "time/tzdata" // Embeds the time zone database, the container images doesn't have one
 
 	"github.com/sdoque/mbaigo/components"
 	"github.com/sdoque/mbaigo/usecases"
@@ -22,7 +24,7 @@ func main() {
 
 	// Instantiate the Capsule
 	sys.Husk = &components.Husk{
-		Description: "Is a controller for a consumed button based on a consumed time of day. Powered by SunriseSunset.io",
+		Description: "Is a controller for a consumed button based on a consumed time of day. Sun times are calculated locally and can be cross-checked with SunriseSunset.io",
 		Certificate: "ABCD",
 		Details:     map[string][]string{"Developer": {"Arrowhead"}},
 		ProtoPort:   map[string]int{"https": 0, "http": 8770, "coap": 0},
@@ -75,6 +77,30 @@ func (t *UnitAsset) Serving(w http.ResponseWriter, r *http.Request, servicePath
 		t.httpSetLatitude(w, r)
 	case "Longitude":
 		t.httpSetLongitude(w, r)
+	case "SunRegime":
+		t.httpSetSunRegime(w, r)
+	case "Rules":
+		t.httpSetRules(w, r)
+	case "NextTransition":
+		t.httpSetNextTransition(w, r)
+	case "NextState":
+		t.httpSetNextState(w, r)
+	case "Targets":
+		t.httpSetTargets(w, r)
+	case "Override":
+		t.httpSetOverride(w, r)
+	case "LightLevel":
+		t.httpSetLightLevel(w, r)
+	case "Vacation":
+		t.httpSetVacation(w, r)
+	case "SunTimes":
+		t.httpSetSunTimes(w, r)
+	case "SunCalendar":
+		t.httpSetSunCalendar(w, r)
+	case "SunElevation":
+		t.httpSetSunElevation(w, r)
+	case "SunAzimuth":
+		t.httpSetSunAzimuth(w, r)
 	default:
 		http.Error(w, "Invalid service request [Do not modify the services subpath in the configuration file]", http.StatusBadRequest)
 	}
@@ -133,3 +159,180 @@ func (rsc *UnitAsset) httpSetLongitude(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method is not supported.", http.StatusNotFound)
 	}
 }
+
+func (rsc *UnitAsset) httpSetSunRegime(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		signalErr := rsc.getSunRegime()
+		usecases.HTTPProcessGetRequest(w, r, &signalErr)
+	default:
+		http.Error(w, "Method is not supported.", http.StatusNotFound)
+	}
+}
+
+// The rules doesn't fit in a signal form, so they are sent as plain JSON
+func (rsc *UnitAsset) httpSetRules(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "PUT":
+		var rules []Rule
+		if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+			http.Error(w, "request incorrectly formatted", http.StatusBadRequest)
+			return
+		}
+		if err := rsc.setRules(rules); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(rsc.getRules()); err != nil {
+			log.Printf("Cannot send rules: %s\n", err)
+		}
+	default:
+		http.Error(w, "Method is not supported.", http.StatusNotFound)
+	}
+}
+
+func (rsc *UnitAsset) httpSetNextTransition(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		signalErr, err := rsc.getNextTransition()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		usecases.HTTPProcessGetRequest(w, r, &signalErr)
+	default:
+		http.Error(w, "Method is not supported.", http.StatusNotFound)
+	}
+}
+
+func (rsc *UnitAsset) httpSetNextState(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		signalErr := rsc.getNextState()
+		usecases.HTTPProcessGetRequest(w, r, &signalErr)
+	default:
+		http.Error(w, "Method is not supported.", http.StatusNotFound)
+	}
+}
+
+// The target statuses are sent as plain JSON, since there's one for each target
+func (rsc *UnitAsset) httpSetTargets(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(rsc.getTargetStatus()); err != nil {
+			log.Printf("Cannot send target statuses: %s\n", err)
+		}
+	default:
+		http.Error(w, "Method is not supported.", http.StatusNotFound)
+	}
+}
+
+func (rsc *UnitAsset) httpSetOverride(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "PUT":
+		sig, err := usecases.HTTPProcessSetRequest(w, r)
+		if err != nil {
+			http.Error(w, "request incorrectly formatted", http.StatusBadRequest)
+			return
+		}
+		rsc.setOverride(sig)
+	case "GET":
+		signalErr := rsc.getOverride()
+		usecases.HTTPProcessGetRequest(w, r, &signalErr)
+	default:
+		http.Error(w, "Method is not supported.", http.StatusNotFound)
+	}
+}
+
+func (rsc *UnitAsset) httpSetLightLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		signalErr := rsc.getLightLevel()
+		usecases.HTTPProcessGetRequest(w, r, &signalErr)
+	default:
+		http.Error(w, "Method is not supported.", http.StatusNotFound)
+	}
+}
+
+func (rsc *UnitAsset) httpSetVacation(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "PUT":
+		sig, err := usecases.HTTPProcessSetRequest(w, r)
+		if err != nil {
+			http.Error(w, "request incorrectly formatted", http.StatusBadRequest)
+			return
+		}
+		rsc.setVacation(sig)
+	case "GET":
+		signalErr := rsc.getVacation()
+		usecases.HTTPProcessGetRequest(w, r, &signalErr)
+	default:
+		http.Error(w, "Method is not supported.", http.StatusNotFound)
+	}
+}
+
+// The sun times are sent as plain JSON, since they don't fit in a signal form
+func (rsc *UnitAsset) httpSetSunTimes(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		timetable, err := rsc.sunTimetable(r.URL.Query().Get("date"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(timetable); err != nil {
+			log.Printf("Cannot send sun times: %s\n", err)
+		}
+	default:
+		http.Error(w, "Method is not supported.", http.StatusNotFound)
+	}
+}
+
+func (rsc *UnitAsset) httpSetSunCalendar(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		year := time.Now().In(rsc.location()).Year()
+		if q := r.URL.Query().Get("year"); q != "" {
+			var err error
+			if year, err = strconv.Atoi(q); err != nil {
+				http.Error(w, "request incorrectly formatted", http.StatusBadRequest)
+				return
+			}
+		}
+		calendar, err := rsc.sunCalendar(year)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(calendar); err != nil {
+			log.Printf("Cannot send sun calendar: %s\n", err)
+		}
+	default:
+		http.Error(w, "Method is not supported.", http.StatusNotFound)
+	}
+}
+
+func (rsc *UnitAsset) httpSetSunElevation(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		signalErr := rsc.getSunElevation()
+		usecases.HTTPProcessGetRequest(w, r, &signalErr)
+	default:
+		http.Error(w, "Method is not supported.", http.StatusNotFound)
+	}
+}
+
+func (rsc *UnitAsset) httpSetSunAzimuth(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		signalErr := rsc.getSunAzimuth()
+		usecases.HTTPProcessGetRequest(w, r, &signalErr)
+	default:
+		http.Error(w, "Method is not supported.", http.StatusNotFound)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestHttpSetButton(t *testing.T) {
@@ -213,3 +214,315 @@ func TestHttpSetLongitude(t *testing.T) {
 		t.Errorf("expected the status to be bad but got: %v", resp.StatusCode)
 	}
 }
+
+func TestHttpSetSunRegime(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+
+	// Good case test: GET
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://172.30.106.39:8670/SunButton/Button/SunRegime", nil)
+	ua.httpSetSunRegime(w, r)
+	resp := w.Result()
+	if resp.StatusCode != 200 {
+		t.Errorf("expected good status code: %v, got %v", 200, resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), `"unit": "Regime"`) {
+		t.Errorf("expected the unit statement to be true!")
+	}
+	// Bad test case: default part of code
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("PUT", "http://172.30.106.39:8670/SunButton/Button/SunRegime", nil)
+	ua.httpSetSunRegime(w, r)
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Errorf("Expected the status to be bad but got: %v", w.Result().StatusCode)
+	}
+}
+
+func TestHttpSetRules(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+
+	// Good case test: GET
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://172.30.106.39:8670/SunButton/Button/Rules", nil)
+	ua.httpSetRules(w, r)
+	resp := w.Result()
+	if resp.StatusCode != 200 {
+		t.Errorf("expected good status code: %v, got %v", 200, resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), `"event":"sunset"`) {
+		t.Errorf("expected the rules in the body, got %s", body)
+	}
+	// Good case test: PUT
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("PUT", "http://172.30.106.39:8670/SunButton/Button/Rules", strings.NewReader(`[{"event": "dusk", "offset": -20, "state": 1}]`))
+	ua.httpSetRules(w, r)
+	if w.Result().StatusCode != 200 || len(ua.Rules) != 1 || ua.Rules[0].Event != "dusk" {
+		t.Errorf("expected the rules to be replaced, got %+v", ua.Rules)
+	}
+	// Bad case test: bad JSON and bad rules
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("PUT", "http://172.30.106.39:8670/SunButton/Button/Rules", strings.NewReader(`{"event":`))
+	ua.httpSetRules(w, r)
+	if w.Result().StatusCode != http.StatusBadRequest {
+		t.Errorf("expected bad request for bad JSON, got %v", w.Result().StatusCode)
+	}
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("PUT", "http://172.30.106.39:8670/SunButton/Button/Rules", strings.NewReader(`[{"event": "moonrise"}]`))
+	ua.httpSetRules(w, r)
+	if w.Result().StatusCode != http.StatusBadRequest || ua.Rules[0].Event != "dusk" {
+		t.Errorf("expected bad request for unknown events, got %v", w.Result().StatusCode)
+	}
+	// Bad test case: default part of code
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("POST", "http://172.30.106.39:8670/SunButton/Button/Rules", nil)
+	ua.httpSetRules(w, r)
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Errorf("Expected the status to be bad but got: %v", w.Result().StatusCode)
+	}
+}
+
+func TestHttpSetNextTransition(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://172.30.106.39:8670/SunButton/Button/NextTransition", nil)
+	ua.httpSetNextTransition(w, r)
+	body, _ := io.ReadAll(w.Result().Body)
+	if w.Result().StatusCode != 200 || !strings.Contains(string(body), `"unit": "Unix timestamp"`) {
+		t.Errorf("expected the next transition, got %v: %s", w.Result().StatusCode, body)
+	}
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "http://172.30.106.39:8670/SunButton/Button/NextState", nil)
+	ua.httpSetNextState(w, r)
+	body, _ = io.ReadAll(w.Result().Body)
+	if w.Result().StatusCode != 200 || !strings.Contains(string(body), `"unit": "bool"`) {
+		t.Errorf("expected the next state, got %v: %s", w.Result().StatusCode, body)
+	}
+	// Bad test case: default part of code
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("PUT", "http://172.30.106.39:8670/SunButton/Button/NextTransition", nil)
+	ua.httpSetNextTransition(w, r)
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Errorf("Expected the status to be bad but got: %v", w.Result().StatusCode)
+	}
+	// A button that is always on never changes
+	ua.Rules = []Rule{{Event: "time", Time: "12:00", State: 1}}
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "http://172.30.106.39:8670/SunButton/Button/NextTransition", nil)
+	ua.httpSetNextTransition(w, r)
+	body, _ = io.ReadAll(w.Result().Body)
+	if w.Result().StatusCode != http.StatusNotFound || !strings.Contains(string(body), errNoTransition.Error()) {
+		t.Errorf("expected no transition scheduled, got %v: %s", w.Result().StatusCode, body)
+	}
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("PUT", "http://172.30.106.39:8670/SunButton/Button/NextState", nil)
+	ua.httpSetNextState(w, r)
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Errorf("Expected the status to be bad but got: %v", w.Result().StatusCode)
+	}
+}
+
+func TestHttpSetTargets(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	ua.targetStatus = []TargetStatus{{Target: "Kitchen/state", Value: 1, Error: "no provider"}}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://172.30.106.39:8670/SunButton/Button/Targets", nil)
+	ua.httpSetTargets(w, r)
+	body, _ := io.ReadAll(w.Result().Body)
+	if w.Result().StatusCode != 200 || !strings.Contains(string(body), `"error":"no provider"`) {
+		t.Errorf("expected the target statuses, got %v: %s", w.Result().StatusCode, body)
+	}
+	// Bad test case: default part of code
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("PUT", "http://172.30.106.39:8670/SunButton/Button/Targets", nil)
+	ua.httpSetTargets(w, r)
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Errorf("Expected the status to be bad but got: %v", w.Result().StatusCode)
+	}
+}
+
+func TestHttpSetOverride(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	ua.overrideUntil = time.Now().Add(time.Hour)
+
+	// Good case test: GET
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://172.30.106.39:8670/SunButton/Button/Override", nil)
+	ua.httpSetOverride(w, r)
+	body, _ := io.ReadAll(w.Result().Body)
+	if w.Result().StatusCode != 200 || !strings.Contains(string(body), `"unit": "Minutes"`) {
+		t.Errorf("expected the override, got %v: %s", w.Result().StatusCode, body)
+	}
+	// Good case test: PUT cancels the override
+	w = httptest.NewRecorder()
+	fakebody := bytes.NewReader([]byte(`{"value": 0, "unit": "Minutes", "version": "SignalA_v1.0"}`))
+	r = httptest.NewRequest("PUT", "http://172.30.106.39:8670/SunButton/Button/Override", fakebody)
+	r.Header.Set("Content-Type", "application/json")
+	ua.httpSetOverride(w, r)
+	if w.Result().StatusCode != 200 || !ua.overrideUntil.IsZero() {
+		t.Errorf("expected the override to be cancelled, got %v", w.Result().StatusCode)
+	}
+	// Bad case: PUT, if the fake body is formatted incorrectly
+	w = httptest.NewRecorder()
+	fakebody = bytes.NewReader([]byte(`{"123, "unit": "Minutes", "version": "SignalA_v1.0"}`))
+	r = httptest.NewRequest("PUT", "http://172.30.106.39:8670/SunButton/Button/Override", fakebody)
+	r.Header.Set("Content-Type", "application/json")
+	ua.httpSetOverride(w, r)
+	if w.Result().StatusCode == 200 {
+		t.Errorf("expected bad status code, got %v", w.Result().StatusCode)
+	}
+	// Bad test case: default part of code
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("123", "http://172.30.106.39:8670/SunButton/Button/Override", nil)
+	ua.httpSetOverride(w, r)
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Errorf("Expected the status to be bad but got: %v", w.Result().StatusCode)
+	}
+}
+
+func TestHttpSetLightLevel(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	ua.lux = 42
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://172.30.106.39:8670/SunButton/Button/LightLevel", nil)
+	ua.httpSetLightLevel(w, r)
+	body, _ := io.ReadAll(w.Result().Body)
+	if w.Result().StatusCode != 200 || !strings.Contains(string(body), `"value": 42`) {
+		t.Errorf("expected the light level, got %v: %s", w.Result().StatusCode, body)
+	}
+	// Bad test case: default part of code
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("PUT", "http://172.30.106.39:8670/SunButton/Button/LightLevel", nil)
+	ua.httpSetLightLevel(w, r)
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Errorf("Expected the status to be bad but got: %v", w.Result().StatusCode)
+	}
+}
+
+func TestHttpSetVacation(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+
+	// Good case test: PUT
+	w := httptest.NewRecorder()
+	fakebody := bytes.NewReader([]byte(`{"value": 1, "unit": "bool", "version": "SignalA_v1.0"}`))
+	r := httptest.NewRequest("PUT", "http://172.30.106.39:8670/SunButton/Button/Vacation", fakebody)
+	r.Header.Set("Content-Type", "application/json")
+	ua.httpSetVacation(w, r)
+	if w.Result().StatusCode != 200 || !ua.Vacation {
+		t.Errorf("expected the vacation mode to be turned on, got %v", w.Result().StatusCode)
+	}
+	// Good case test: GET
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "http://172.30.106.39:8670/SunButton/Button/Vacation", nil)
+	ua.httpSetVacation(w, r)
+	body, _ := io.ReadAll(w.Result().Body)
+	if w.Result().StatusCode != 200 || !strings.Contains(string(body), `"value": 1`) {
+		t.Errorf("expected the vacation mode, got %v: %s", w.Result().StatusCode, body)
+	}
+	// Bad case: PUT, if the fake body is formatted incorrectly
+	w = httptest.NewRecorder()
+	fakebody = bytes.NewReader([]byte(`{"123, "unit": "bool", "version": "SignalA_v1.0"}`))
+	r = httptest.NewRequest("PUT", "http://172.30.106.39:8670/SunButton/Button/Vacation", fakebody)
+	r.Header.Set("Content-Type", "application/json")
+	ua.httpSetVacation(w, r)
+	if w.Result().StatusCode == 200 {
+		t.Errorf("expected bad status code, got %v", w.Result().StatusCode)
+	}
+	// Bad test case: default part of code
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("123", "http://172.30.106.39:8670/SunButton/Button/Vacation", nil)
+	ua.httpSetVacation(w, r)
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Errorf("Expected the status to be bad but got: %v", w.Result().StatusCode)
+	}
+}
+
+func TestHttpSetSunTimes(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+
+	// Good case test: GET with a date
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://172.30.106.39:8670/SunButton/Button/SunTimes?date=2024-06-21", nil)
+	ua.httpSetSunTimes(w, r)
+	body, _ := io.ReadAll(w.Result().Body)
+	if w.Result().StatusCode != 200 || !strings.Contains(string(body), `"date":"2024-06-21"`) {
+		t.Errorf("expected the sun times, got %v: %s", w.Result().StatusCode, body)
+	}
+	// Bad case test: bad date
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "http://172.30.106.39:8670/SunButton/Button/SunTimes?date=tomorrow", nil)
+	ua.httpSetSunTimes(w, r)
+	if w.Result().StatusCode != http.StatusBadRequest {
+		t.Errorf("expected bad request, got %v", w.Result().StatusCode)
+	}
+	// Bad test case: default part of code
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("PUT", "http://172.30.106.39:8670/SunButton/Button/SunTimes", nil)
+	ua.httpSetSunTimes(w, r)
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Errorf("Expected the status to be bad but got: %v", w.Result().StatusCode)
+	}
+}
+
+func TestHttpSetSunCalendar(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+
+	// Good case test: GET with a year
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://172.30.106.39:8670/SunButton/Button/SunCalendar?year=2023", nil)
+	ua.httpSetSunCalendar(w, r)
+	body, _ := io.ReadAll(w.Result().Body)
+	if w.Result().StatusCode != 200 || strings.Count(string(body), `"date"`) != 365 {
+		t.Errorf("expected 365 days of sun times, got %v", w.Result().StatusCode)
+	}
+	// Bad case test: bad years
+	for _, year := range []string{"next", "-1"} {
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest("GET", "http://172.30.106.39:8670/SunButton/Button/SunCalendar?year="+year, nil)
+		ua.httpSetSunCalendar(w, r)
+		if w.Result().StatusCode != http.StatusBadRequest {
+			t.Errorf("expected bad request for year %s, got %v", year, w.Result().StatusCode)
+		}
+	}
+	// Bad test case: default part of code
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("PUT", "http://172.30.106.39:8670/SunButton/Button/SunCalendar", nil)
+	ua.httpSetSunCalendar(w, r)
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Errorf("Expected the status to be bad but got: %v", w.Result().StatusCode)
+	}
+}
+
+func TestHttpSetSunPosition(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://172.30.106.39:8670/SunButton/Button/SunElevation", nil)
+	ua.httpSetSunElevation(w, r)
+	if w.Result().StatusCode != 200 {
+		t.Errorf("expected good status code, got %v", w.Result().StatusCode)
+	}
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "http://172.30.106.39:8670/SunButton/Button/SunAzimuth", nil)
+	ua.httpSetSunAzimuth(w, r)
+	if w.Result().StatusCode != 200 {
+		t.Errorf("expected good status code, got %v", w.Result().StatusCode)
+	}
+	// Bad test case: default part of code
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("PUT", "http://172.30.106.39:8670/SunButton/Button/SunElevation", nil)
+	ua.httpSetSunElevation(w, r)
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Errorf("Expected the status to be bad but got: %v", w.Result().StatusCode)
+	}
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("PUT", "http://172.30.106.39:8670/SunButton/Button/SunAzimuth", nil)
+	ua.httpSetSunAzimuth(w, r)
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Errorf("Expected the status to be bad but got: %v", w.Result().StatusCode)
+	}
+}
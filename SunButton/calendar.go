@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sdoque/mbaigo/forms"
+)
+
+// SunTimetable is the published form of SunTimes. The times uses RFC 3339 and events that doesn't
+// happen during the day (for example the sunset during the midnight sun) are left empty.
+type SunTimetable struct {
+	Date         string  `json:"date"`
+	FirstLight   string  `json:"first_light"`
+	NauticalDawn string  `json:"nautical_dawn"`
+	Dawn         string  `json:"dawn"`
+	Sunrise      string  `json:"sunrise"`
+	SolarNoon    string  `json:"solar_noon"`
+	GoldenHour   string  `json:"golden_hour"`
+	Sunset       string  `json:"sunset"`
+	Dusk         string  `json:"dusk"`
+	NauticalDusk string  `json:"nautical_dusk"`
+	LastLight    string  `json:"last_light"`
+	DayLength    float64 `json:"day_length"` // Minutes
+	Regime       string  `json:"regime"`
+}
+
+// newSunTimetable converts the sun times to a timetable
+func newSunTimetable(st SunTimes) SunTimetable {
+	format := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+	return SunTimetable{
+		Date:         st.Date.Format("2006-01-02"),
+		FirstLight:   format(st.FirstLight),
+		NauticalDawn: format(st.NauticalDawn),
+		Dawn:         format(st.Dawn),
+		Sunrise:      format(st.Sunrise),
+		SolarNoon:    format(st.SolarNoon),
+		GoldenHour:   format(st.GoldenHour),
+		Sunset:       format(st.Sunset),
+		Dusk:         format(st.Dusk),
+		NauticalDusk: format(st.NauticalDusk),
+		LastLight:    format(st.LastLight),
+		DayLength:    st.DayLength.Minutes(),
+		Regime:       st.Regime.String(),
+	}
+}
+
+var errBadDate error = fmt.Errorf("bad date")
+
+// sunTimetable returns the timetable for a date in the "2006-01-02" format, or for today if it's empty
+func (ua *UnitAsset) sunTimetable(date string) (SunTimetable, error) {
+	ua.mutex.Lock()
+	defer ua.mutex.Unlock()
+	day := time.Now().In(ua.location())
+	if date != "" {
+		var err error
+		day, err = time.ParseInLocation("2006-01-02", date, ua.location())
+		if err != nil {
+			return SunTimetable{}, fmt.Errorf("%w: %s", errBadDate, err)
+		}
+	}
+	return newSunTimetable(calculateSunTimes(day, ua.Latitude, ua.Longitude)), nil
+}
+
+// sunCalendar returns the timetables for every day of a year
+func (ua *UnitAsset) sunCalendar(year int) ([]SunTimetable, error) {
+	ua.mutex.Lock()
+	defer ua.mutex.Unlock()
+	if year < 1 || year > 9999 {
+		return nil, fmt.Errorf("%w: year %d is out of range", errBadDate, year)
+	}
+	var calendar []SunTimetable
+	for day := time.Date(year, 1, 1, 0, 0, 0, 0, ua.location()); day.Year() == year; day = day.AddDate(0, 0, 1) {
+		calendar = append(calendar, newSunTimetable(calculateSunTimes(day, ua.Latitude, ua.Longitude)))
+	}
+	return calendar, nil
+}
+
+// getSunElevation is used for reading the current elevation of the sun above the horizon
+func (ua *UnitAsset) getSunElevation() (f forms.SignalA_v1a) {
+	ua.mutex.Lock()
+	defer ua.mutex.Unlock()
+	f.NewForm()
+	f.Value, _ = sunPosition(time.Now(), ua.Latitude, ua.Longitude)
+	f.Unit = "Degrees"
+	f.Timestamp = time.Now()
+	return f
+}
+
+// getSunAzimuth is used for reading the current direction of the sun, clockwise from north
+func (ua *UnitAsset) getSunAzimuth() (f forms.SignalA_v1a) {
+	ua.mutex.Lock()
+	defer ua.mutex.Unlock()
+	f.NewForm()
+	_, f.Value = sunPosition(time.Now(), ua.Latitude, ua.Longitude)
+	f.Unit = "Degrees"
+	f.Timestamp = time.Now()
+	return f
+}
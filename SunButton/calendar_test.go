@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSunPosition(t *testing.T) {
+	lat, lng := 59.3293, 18.0686 // Stockholm
+	st := calculateSunTimes(time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC), lat, lng)
+
+	// At solar noon the sun is in the south, 90 - latitude + declination (23.44) degrees up
+	elevation, azimuth := sunPosition(st.SolarNoon, lat, lng)
+	if math.Abs(elevation-54.1) > 0.2 || math.Abs(azimuth-180) > 0.5 {
+		t.Errorf("expected the sun at 54.1 degrees in the south at noon, got %.2f at %.2f", elevation, azimuth)
+	}
+	// At sunrise the sun is just below the horizon in the north east
+	elevation, azimuth = sunPosition(st.Sunrise, lat, lng)
+	if elevation < -1 || elevation > 0 || azimuth < 20 || azimuth > 60 {
+		t.Errorf("expected the sun at the horizon in the north east at sunrise, got %.2f at %.2f", elevation, azimuth)
+	}
+	// And in the north west at sunset
+	_, azimuth = sunPosition(st.Sunset, lat, lng)
+	if azimuth < 300 || azimuth > 340 {
+		t.Errorf("expected the sun in the north west at sunset, got %.2f", azimuth)
+	}
+	// In the southern hemisphere the sun is in the north at noon
+	sydney := calculateSunTimes(time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC), -33.8688, 151.2093)
+	_, azimuth = sunPosition(sydney.SolarNoon, -33.8688, 151.2093)
+	if azimuth > 0.5 && azimuth < 359.5 {
+		t.Errorf("expected the sun in the north at noon in Sydney, got %.2f", azimuth)
+	}
+}
+
+func TestSunTimetable(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	ua.Latitude, ua.Longitude = 69.6492, 18.9553 // Tromsø
+	ua.setTimezone("Europe/Oslo")
+
+	tt, err := ua.sunTimetable("2024-06-21")
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if tt.Date != "2024-06-21" || tt.Regime != "midnight_sun" || tt.Sunset != "" || tt.DayLength != 24*60 {
+		t.Errorf("expected the midnight sun in Tromsø, got %+v", tt)
+	}
+	if noon, err := time.Parse(time.RFC3339, tt.SolarNoon); err != nil || noon.Hour() != 12 {
+		t.Errorf("expected solar noon around 12 (local time), got %s", tt.SolarNoon)
+	}
+	// Today is used without a date
+	if tt, _ = ua.sunTimetable(""); tt.Date != time.Now().In(ua.location()).Format("2006-01-02") {
+		t.Errorf("expected today's date, got %s", tt.Date)
+	}
+	if _, err = ua.sunTimetable("21/06/2024"); !errors.Is(err, errBadDate) {
+		t.Errorf("expected errBadDate, got %v", err)
+	}
+}
+
+func TestSunCalendar(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	calendar, err := ua.sunCalendar(2024)
+	if err != nil || len(calendar) != 366 {
+		t.Fatalf("expected 366 days in 2024, got %d (%v)", len(calendar), err)
+	}
+	if calendar[0].Date != "2024-01-01" || calendar[365].Date != "2024-12-31" {
+		t.Errorf("expected the calendar to cover the whole year, got %s - %s", calendar[0].Date, calendar[365].Date)
+	}
+	if _, err = ua.sunCalendar(0); !errors.Is(err, errBadDate) {
+		t.Errorf("expected errBadDate, got %v", err)
+	}
+}
+
+func TestSunPositionServices(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	elevation := ua.getSunElevation()
+	if elevation.Unit != "Degrees" || elevation.Value < -90 || elevation.Value > 90 {
+		t.Errorf("expected an elevation between -90 and 90 degrees, got %v", elevation.Value)
+	}
+	azimuth := ua.getSunAzimuth()
+	if azimuth.Unit != "Degrees" || azimuth.Value < 0 || azimuth.Value >= 360 {
+		t.Errorf("expected an azimuth between 0 and 360 degrees, got %v", azimuth.Value)
+	}
+}
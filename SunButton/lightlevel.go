@@ -0,0 +1,134 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/sdoque/mbaigo/components"
+	"github.com/sdoque/mbaigo/forms"
+	"github.com/sdoque/mbaigo/usecases"
+)
+
+// The optional light level feedback lets a light sensor (for example a ZHALightLevel sensor from the ZigBee
+// system) decide the button status during the day, so the lights can turn on early on dark winter afternoons.
+// At night, or when the sensor hasn't reported anything for a while, the normal sun schedule is used.
+
+// How old a light level reading can be if LuxMaxAge isn't set, before the sensor is considered stale
+const defaultLuxMaxAge time.Duration = 10 * time.Minute
+
+// luxEnabled checks if the unit asset should use the light level feedback
+func (ua *UnitAsset) luxEnabled() bool {
+	return ua.LuxOn > 0
+}
+
+// newLightLevelCervice creates the cervice used for reading the light level from a sensor with the same details
+func (ua *UnitAsset) newLightLevelCervice(protos []string) *components.Cervice {
+	return &components.Cervice{
+		Name:    "lightlevel",
+		Protos:  protos,
+		Url:     make([]string, 0),
+		Details: components.MergeDetails(ua.Details, map[string][]string{"Unit": {"Lux"}}),
+	}
+}
+
+// readLightLevel gets the latest light level from the sensor. The mutex is only locked while saving the
+// reading, not while waiting for the sensor.
+func (ua *UnitAsset) readLightLevel() {
+	tf, err := usecases.GetState(ua.CervicesMap["lightlevel"], ua.Owner)
+	if err != nil {
+		log.Printf("Unable to obtain a light level reading: %s\n", err)
+		return
+	}
+	// Perform a type assertion to convert the returned Form to SignalA_v1a
+	tup, ok := tf.(*forms.SignalA_v1a)
+	if !ok {
+		log.Println("problem unpacking the light level signal form")
+		return
+	}
+	ua.mutex.Lock()
+	defer ua.mutex.Unlock()
+	ua.lux = tup.Value
+	ua.luxTime = tup.Timestamp
+	if ua.luxTime.IsZero() {
+		ua.luxTime = time.Now()
+	}
+	ua.updateLuxState()
+}
+
+// updateLuxState switches the light level status when the light level crosses the thresholds. Between the
+// thresholds the previous status is kept, so the button doesn't flicker when the level is close to one of them.
+func (ua *UnitAsset) updateLuxState() {
+	luxOff := ua.LuxOff
+	if luxOff < ua.LuxOn {
+		luxOff = ua.LuxOn // No hysteresis
+	}
+	switch {
+	case ua.lux < ua.LuxOn:
+		ua.luxState, ua.luxKnown = 1, true
+	case ua.lux > luxOff:
+		ua.luxState, ua.luxKnown = 0, true
+	}
+}
+
+// luxFresh checks if the latest light level reading is new enough to be used
+func (ua *UnitAsset) luxFresh(now time.Time) bool {
+	maxAge := defaultLuxMaxAge
+	if ua.LuxMaxAge > 0 {
+		maxAge = time.Duration(ua.LuxMaxAge * float64(time.Second))
+	}
+	return !ua.luxTime.IsZero() && now.Sub(ua.luxTime) <= maxAge
+}
+
+// daytime checks if the sun is up at the given time
+func (ua *UnitAsset) daytime(now time.Time) bool {
+	st := calculateSunTimes(now, ua.Latitude, ua.Longitude)
+	switch st.Regime {
+	case MidnightSun:
+		return true
+	case PolarNight:
+		return false
+	}
+	return !now.Before(st.Sunrise) && now.Before(st.Sunset)
+}
+
+// lightLevelState returns the button status decided by the light level, the bool is false if the sun
+// schedule should be used instead (ie. at night, or if the sensor is stale or disabled)
+func (ua *UnitAsset) lightLevelState(now time.Time) (float64, bool) {
+	if !ua.luxEnabled() || !ua.luxKnown || !ua.luxFresh(now) || !ua.daytime(now) {
+		return 0, false
+	}
+	return ua.luxState, true
+}
+
+// luxSleep limits how long the control loop may sleep, so the light level is checked regularly during the day
+func (ua *UnitAsset) luxSleep(now time.Time, d time.Duration) time.Duration {
+	if !ua.luxEnabled() {
+		return d
+	}
+	if ua.daytime(now) {
+		period := ua.Period * time.Second
+		if period <= 0 {
+			period = time.Minute
+		}
+		if period < d {
+			return period
+		}
+		return d
+	}
+	st := calculateSunTimes(now, ua.Latitude, ua.Longitude)
+	if now.Before(st.Sunrise) && st.Sunrise.Sub(now) < d {
+		return st.Sunrise.Sub(now)
+	}
+	return d
+}
+
+// getLightLevel is used for reading the latest light level from the sensor
+func (ua *UnitAsset) getLightLevel() (f forms.SignalA_v1a) {
+	ua.mutex.Lock()
+	defer ua.mutex.Unlock()
+	f.NewForm()
+	f.Value = ua.lux
+	f.Unit = "Lux"
+	f.Timestamp = ua.luxTime
+	return f
+}
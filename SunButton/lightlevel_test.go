@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sdoque/mbaigo/components"
+)
+
+// luxTransport answers all requests with a light level reading
+type luxTransport struct {
+	body string
+}
+
+func (t luxTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(t.body)),
+		Request:    req,
+	}, nil
+}
+
+func TestUpdateLuxState(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	ua.LuxOn, ua.LuxOff = 50, 150
+
+	steps := []struct {
+		lux   float64
+		state float64
+		known bool
+	}{
+		{100, 0, false}, // Between the thresholds, nothing is known yet
+		{40, 1, true},   // Dark
+		{100, 1, true},  // Stays on until it's bright enough
+		{160, 0, true},  // Bright
+		{60, 0, true},   // Stays off until it's dark enough
+		{49, 1, true},
+	}
+	for i, s := range steps {
+		ua.lux = s.lux
+		ua.updateLuxState()
+		if ua.luxState != s.state || ua.luxKnown != s.known {
+			t.Errorf("step %d: expected state %v (known: %v) at %v lux, got %v (known: %v)",
+				i, s.state, s.known, s.lux, ua.luxState, ua.luxKnown)
+		}
+	}
+}
+
+func TestLightLevelState(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	ua.Latitude, ua.Longitude = 59.3293, 18.0686
+	ua.LuxOn, ua.LuxOff, ua.LuxMaxAge = 50, 150, 600
+	st := calculateSunTimes(time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC), ua.Latitude, ua.Longitude)
+	noon := st.SolarNoon
+
+	ua.lux, ua.luxTime = 20, noon.Add(-time.Minute)
+	ua.updateLuxState()
+	if state, ok := ua.lightLevelState(noon); !ok || state != 1 {
+		t.Errorf("expected a dark afternoon to turn the button on, got %v (%v)", state, ok)
+	}
+	// Stale readings falls back to the sun schedule
+	if _, ok := ua.lightLevelState(noon.Add(11 * time.Minute)); ok {
+		t.Errorf("expected a stale reading to be ignored")
+	}
+	// The sensor isn't used at night
+	ua.luxTime = st.Sunset.Add(time.Hour)
+	if _, ok := ua.lightLevelState(st.Sunset.Add(time.Hour)); ok {
+		t.Errorf("expected the light level to be ignored at night")
+	}
+	// Nor when it's disabled
+	ua.LuxOn = 0
+	if _, ok := ua.lightLevelState(noon); ok {
+		t.Errorf("expected the light level to be ignored when disabled")
+	}
+}
+
+func TestReadLightLevel(t *testing.T) {
+	http.DefaultClient.Transport = luxTransport{`{"value": 35, "unit": "Lux", "timestamp": "2024-01-10T12:00:00Z", "version": "SignalA_v1.0"}`}
+	ua := initTemplate().(*UnitAsset)
+	ua.LuxOn, ua.LuxOff = 50, 150
+	ua.CervicesMap = components.Cervices{"lightlevel": ua.newLightLevelCervice([]string{"http"})}
+	ua.CervicesMap["lightlevel"].Url = []string{"http://sensor/ZigBee/LightSensor/lightlevel"}
+
+	ua.readLightLevel()
+	if ua.lux != 35 || ua.luxState != 1 || !ua.luxKnown {
+		t.Errorf("expected 35 lux to turn the button on, got %v lux and state %v", ua.lux, ua.luxState)
+	}
+	if !ua.luxTime.Equal(time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the timestamp of the reading, got %s", ua.luxTime)
+	}
+	if f := ua.getLightLevel(); f.Value != 35 || f.Unit != "Lux" {
+		t.Errorf("expected the light level service to return 35 Lux, got %v %s", f.Value, f.Unit)
+	}
+	// A bad reading keeps the old value
+	http.DefaultClient.Transport = luxTransport{`{"value": `}
+	ua.readLightLevel()
+	if ua.lux != 35 {
+		t.Errorf("expected the old light level to be kept, got %v", ua.lux)
+	}
+}
+
+func TestLuxSleep(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	ua.Latitude, ua.Longitude = 59.3293, 18.0686
+	st := calculateSunTimes(time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC), ua.Latitude, ua.Longitude)
+
+	if d := ua.luxSleep(st.SolarNoon, maxSleep); d != maxSleep {
+		t.Errorf("expected no limit when disabled, got %s", d)
+	}
+	ua.LuxOn = 50
+	if d := ua.luxSleep(st.SolarNoon, maxSleep); d != ua.Period*time.Second {
+		t.Errorf("expected the sensor to be read every %s during the day, got %s", ua.Period*time.Second, d)
+	}
+	if d := ua.luxSleep(st.Sunrise.Add(-10*time.Minute), maxSleep); d != 10*time.Minute {
+		t.Errorf("expected to wake up at sunrise, got %s", d)
+	}
+}
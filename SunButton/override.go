@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/sdoque/mbaigo/forms"
+)
+
+// A manual override happens when the button status is set by a PUT request, and it holds the button status
+// for OverrideDuration minutes (or until the next planned transition) instead of following the rules.
+
+// How long an override holds when there's no upcoming transition that can end it
+const overrideFallback time.Duration = 24 * time.Hour
+
+// startOverride holds the current button status, starting at the given time
+func (ua *UnitAsset) startOverride(now time.Time) {
+	if ua.OverrideDuration > 0 {
+		ua.overrideUntil = now.Add(time.Duration(ua.OverrideDuration * float64(time.Minute)))
+		return
+	}
+	at, _, ok := ua.nextTransition(now.In(ua.location()))
+	if !ok {
+		at = now.Add(overrideFallback)
+	}
+	ua.overrideUntil = at
+}
+
+// overrideActive checks if a manual override is holding the button status at the given time
+func (ua *UnitAsset) overrideActive(now time.Time) bool {
+	return now.Before(ua.overrideUntil)
+}
+
+// overrideRemaining returns how long the manual override holds after the given time
+func (ua *UnitAsset) overrideRemaining(now time.Time) time.Duration {
+	if !ua.overrideActive(now) {
+		return 0
+	}
+	return ua.overrideUntil.Sub(now)
+}
+
+// cancelOverride ends the manual override, letting the rules decide the button status again
+func (ua *UnitAsset) cancelOverride() {
+	if !ua.overrideUntil.IsZero() {
+		log.Printf("The manual override of %s has been cancelled\n", ua.Name)
+	}
+	ua.overrideUntil = time.Time{}
+	ua.wakeUp()
+}
+
+// getOverride is used for reading the remaining time of the manual override, 0 if there's none
+func (ua *UnitAsset) getOverride() (f forms.SignalA_v1a) {
+	ua.mutex.Lock()
+	defer ua.mutex.Unlock()
+	f.NewForm()
+	f.Value = ua.overrideRemaining(time.Now()).Minutes()
+	f.Unit = "Minutes"
+	f.Timestamp = time.Now()
+	return f
+}
+
+// setOverride is used for cancelling the manual override (with a value of 0), or changing its remaining minutes
+func (ua *UnitAsset) setOverride(f forms.SignalA_v1a) {
+	ua.mutex.Lock()
+	defer ua.mutex.Unlock()
+	if f.Value <= 0 {
+		ua.cancelOverride()
+		return
+	}
+	ua.overrideUntil = time.Now().Add(time.Duration(f.Value * float64(time.Minute)))
+	ua.wakeUp()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sdoque/mbaigo/forms"
+)
+
+func TestStartOverride(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	ua.Latitude, ua.Longitude = 59.3293, 18.0686
+	ua.setTimezone("UTC")
+	ua.Rules = exampleRules
+	st := calculateSunTimes(time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC), ua.Latitude, ua.Longitude)
+
+	// Holds until the next transition by default
+	ua.startOverride(st.SolarNoon)
+	if !ua.overrideUntil.Equal(st.Dusk.Add(-20 * time.Minute)) {
+		t.Errorf("expected the override to hold until %s, got %s", st.Dusk.Add(-20*time.Minute), ua.overrideUntil)
+	}
+	if !ua.overrideActive(st.SolarNoon) || ua.overrideActive(st.Dusk) {
+		t.Errorf("expected the override to be active until the transition")
+	}
+	// Or for a fixed duration
+	ua.OverrideDuration = 90
+	ua.startOverride(st.SolarNoon)
+	if d := ua.overrideRemaining(st.SolarNoon); d != 90*time.Minute {
+		t.Errorf("expected 90 minutes left of the override, got %s", d)
+	}
+	if d := ua.sleepDuration(st.SolarNoon); d != maxSleep {
+		t.Errorf("expected to sleep for %s, got %s", maxSleep, d)
+	}
+	if d := ua.sleepDuration(st.SolarNoon.Add(time.Hour)); d != 30*time.Minute {
+		t.Errorf("expected to sleep until the end of the override, got %s", d)
+	}
+	if d := ua.overrideRemaining(st.SolarNoon.Add(2 * time.Hour)); d != 0 {
+		t.Errorf("expected the override to have ended, got %s", d)
+	}
+}
+
+func TestOverrideServices(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	if f := ua.getOverride(); f.Value != 0 || f.Unit != "Minutes" {
+		t.Errorf("expected no override, got %v %s", f.Value, f.Unit)
+	}
+	ua.setOverride(forms.SignalA_v1a{Value: 30})
+	if f := ua.getOverride(); f.Value <= 29 || f.Value > 30 {
+		t.Errorf("expected 30 minutes left of the override, got %v", f.Value)
+	}
+	ua.setOverride(forms.SignalA_v1a{Value: 0})
+	if ua.overrideActive(time.Now()) {
+		t.Errorf("expected the override to be cancelled")
+	}
+}
+
+func TestOverrideFeedbackLoop(t *testing.T) {
+	trans := &hostTransport{bad: map[string]bool{}, hits: map[string]int{}}
+	http.DefaultClient.Transport = trans
+	ua := initTemplate().(*UnitAsset)
+	ua.Targets = []Target{{Name: "plug1"}}
+	ua.CervicesMap = ua.newTargetCervices([]string{"http"}, *ua.ServicesMap["ButtonStatus"])
+	ua.CervicesMap[targetKey(0)].Url = []string{"http://plug1/ZigBee/plug1/state"}
+	ua.Rules = []Rule{{Event: "time", Time: "00:00", State: 0}} // The rules keeps the button off all day
+	ua.OverrideDuration = 60
+
+	// A manual change is sent at once, and holds against the rules
+	ua.setButtonStatus(forms.SignalA_v1a{Value: 1})
+	ua.processFeedbackLoop()
+	ua.processFeedbackLoop()
+	if ua.ButtonStatus != 1 || trans.hits["plug1"] != 1 {
+		t.Errorf("expected the button to stay on (and be sent once), got %v (%d)", ua.ButtonStatus, trans.hits["plug1"])
+	}
+	// When the override ends, the rules takes over again
+	ua.overrideUntil = time.Now().Add(-time.Second)
+	ua.processFeedbackLoop()
+	if ua.ButtonStatus != 0 || trans.hits["plug1"] != 2 || !ua.overrideUntil.IsZero() {
+		t.Errorf("expected the button to be turned off after the override, got %v", ua.ButtonStatus)
+	}
+}
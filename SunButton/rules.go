@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A Rule sets the button to a new state at a sun event (with an optional offset) or at a fixed clock time.
+// Example: {"event": "dusk", "offset": -20, "state": 1} turns the button on 20 minutes before dusk.
+type Rule struct {
+	Event    string   `json:"event"`    // A sun event (see sunEvents below) or "time" for a fixed clock time
+	Offset   float64  `json:"offset"`   // Minutes added to the sun event, negative values are before the event
+	Time     string   `json:"time"`     // Clock time in the "15:04" format, only used by the "time" event
+	State    float64  `json:"state"`    // The new button status, 0 = off and 1 = on
+	Weekdays []string `json:"weekdays"` // Only trigger on these days (e.g. "Mon", "Sat"), leave empty for every day
+}
+
+// The sun events that can be used by the rules, mapped to their times for a given day
+var sunEvents = map[string]func(SunTimes) time.Time{
+	"first_light":   func(st SunTimes) time.Time { return st.FirstLight },
+	"nautical_dawn": func(st SunTimes) time.Time { return st.NauticalDawn },
+	"dawn":          func(st SunTimes) time.Time { return st.Dawn },
+	"sunrise":       func(st SunTimes) time.Time { return st.Sunrise },
+	"solar_noon":    func(st SunTimes) time.Time { return st.SolarNoon },
+	"golden_hour":   func(st SunTimes) time.Time { return st.GoldenHour },
+	"sunset":        func(st SunTimes) time.Time { return st.Sunset },
+	"dusk":          func(st SunTimes) time.Time { return st.Dusk },
+	"nautical_dusk": func(st SunTimes) time.Time { return st.NauticalDusk },
+	"last_light":    func(st SunTimes) time.Time { return st.LastLight },
+}
+
+// defaultRules are used when a unit asset has no rules of its own, turning the button on between sunset and sunrise
+var defaultRules = []Rule{
+	{Event: "sunset", State: 1},
+	{Event: "sunrise", State: 0},
+}
+
+// A trigger is a rule that happens at a specific time
+type trigger struct {
+	At    time.Time
+	State float64
+}
+
+// How many days to look back or forward when searching for triggers, needed for rules that only runs on some weekdays
+const ruleSearchDays int = 8
+
+// activeDay checks if the rule should trigger on the given day
+func (r Rule) activeDay(day time.Time) bool {
+	if len(r.Weekdays) < 1 {
+		return true
+	}
+	weekday := day.Weekday().String()
+	for _, d := range r.Weekdays {
+		if len(d) >= 3 && strings.EqualFold(weekday[:3], d[:3]) {
+			return true
+		}
+	}
+	return false
+}
+
+// triggerTime returns the time the rule triggers on the given day, the bool is false if it doesn't
+// trigger that day (ie. wrong weekday, a sun event that doesn't happen or a bad rule)
+func (r Rule) triggerTime(day time.Time, st SunTimes) (time.Time, bool) {
+	if !r.activeDay(day) {
+		return time.Time{}, false
+	}
+	if r.Event == "time" {
+		clock, err := time.Parse("15:04", r.Time)
+		if err != nil {
+			log.Printf("Bad time in rule %+v: %s\n", r, err)
+			return time.Time{}, false
+		}
+		y, m, d := day.Date()
+		return time.Date(y, m, d, clock.Hour(), clock.Minute(), 0, 0, day.Location()), true
+	}
+	event, found := sunEvents[strings.ToLower(r.Event)]
+	if !found {
+		log.Printf("Unknown event in rule %+v\n", r)
+		return time.Time{}, false
+	}
+	at := event(st)
+	if at.IsZero() {
+		return at, false
+	}
+	return at.Add(time.Duration(r.Offset * float64(time.Minute))), true
+}
+
+// rules returns the rules for the unit asset, or the default rules if none has been set
+func (ua *UnitAsset) rules() []Rule {
+	if len(ua.Rules) < 1 {
+		return defaultRules
+	}
+	return ua.Rules
+}
+
+// triggersBetween returns all triggers from the rules between the two days (inclusive), sorted by time
+func (ua *UnitAsset) triggersBetween(from, to time.Time, rules []Rule) (triggers []trigger) {
+	y, m, d := from.Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, from.Location())
+	for !day.After(to) {
+		st := calculateSunTimes(day, ua.Latitude, ua.Longitude)
+		for _, r := range rules {
+			if at, ok := r.triggerTime(day, st); ok {
+				triggers = append(triggers, trigger{At: at, State: r.State})
+			}
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	sort.SliceStable(triggers, func(i, j int) bool {
+		return triggers[i].At.Before(triggers[j].At)
+	})
+	return
+}
+
+// ruleState returns the button status set by the latest triggered rule at (or before) the given time,
+// the bool is false if no rule has triggered during the last days
+func (ua *UnitAsset) ruleState(now time.Time, rules []Rule) (float64, bool) {
+	triggers := ua.triggersBetween(now.AddDate(0, 0, -ruleSearchDays), now, rules)
+	for i := len(triggers) - 1; i >= 0; i-- {
+		if !triggers[i].At.After(now) {
+			return triggers[i].State, true
+		}
+	}
+	return 0, false
+}
+
+// The fallback policies for days without any sunrise or sunset
+const (
+	policyOn       string = "on"       // Keep the button on all day
+	policyOff      string = "off"      // Keep the button off all day
+	policySchedule string = "schedule" // Use the rules in PolarSchedule instead, ie. fixed clock times
+)
+
+// desiredState returns the button status for the given time, using the rules during normal days
+// and the fallback policies during midnight sun or polar night
+func (ua *UnitAsset) desiredState(now time.Time) (float64, bool) {
+	var policy string
+	switch calculateSunTimes(now, ua.Latitude, ua.Longitude).Regime {
+	case MidnightSun:
+		policy = ua.MidnightSunPolicy
+	case PolarNight:
+		policy = ua.PolarNightPolicy
+	default:
+		return ua.ruleState(now, ua.rules())
+	}
+	switch policy {
+	case policyOn:
+		return 1, true
+	case policyOff:
+		return 0, true
+	case policySchedule:
+		return ua.ruleState(now, ua.PolarSchedule)
+	default:
+		// Falls back to the normal rules, which will only trigger on the events that still happens (ie. twilight)
+		return ua.ruleState(now, ua.rules())
+	}
+}
+
+// plannedState returns the button status from the vacation pattern when the vacation mode is on, otherwise
+// from the rules and polar policies
+func (ua *UnitAsset) plannedState(now time.Time) (float64, bool) {
+	if ua.Vacation {
+		return ua.vacationState(now), true
+	}
+	return ua.desiredState(now)
+}
+
+// nextTransition returns when the button status changes the next time after the given time, and to what
+// status. The bool is false if nothing changes during the next days.
+func (ua *UnitAsset) nextTransition(now time.Time) (time.Time, float64, bool) {
+	current, hasCurrent := ua.plannedState(now)
+	from := now.AddDate(0, 0, -1) // Rules with large offsets might move an event into the next day
+	end := now.AddDate(0, 0, ruleSearchDays)
+
+	// The status can only change when a rule (or the vacation pattern) is triggered, or at midnight when the sun regime might change
+	var candidates []time.Time
+	for _, t := range ua.triggersBetween(from, end, ua.rules()) {
+		candidates = append(candidates, t.At)
+	}
+	for _, t := range ua.triggersBetween(from, end, ua.PolarSchedule) {
+		candidates = append(candidates, t.At)
+	}
+	if ua.Vacation {
+		for _, t := range ua.vacationTriggersBetween(from, end) {
+			candidates = append(candidates, t.At)
+		}
+	}
+	y, m, d := now.Date()
+	for day := time.Date(y, m, d+1, 0, 0, 0, 0, now.Location()); !day.After(end); day = day.AddDate(0, 0, 1) {
+		candidates = append(candidates, day)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Before(candidates[j])
+	})
+
+	for _, c := range candidates {
+		if !c.After(now) {
+			continue
+		}
+		state, ok := ua.plannedState(c)
+		if ok && (!hasCurrent || state != current) {
+			return c, state, true
+		}
+	}
+	return time.Time{}, 0, false
+}
+
+var errBadRule error = fmt.Errorf("bad rule")
+
+// validateRule makes sure the rule uses a known event, a proper clock time, known weekdays and sets the
+// button either off (0) or on (1)
+func validateRule(r Rule) error {
+	if r.Event == "time" {
+		if _, err := time.Parse("15:04", r.Time); err != nil {
+			return fmt.Errorf("%w: %s", errBadRule, err)
+		}
+	} else if _, found := sunEvents[strings.ToLower(r.Event)]; !found {
+		return fmt.Errorf("%w: unknown event %q", errBadRule, r.Event)
+	}
+	if r.State != 0 && r.State != 1 {
+		return fmt.Errorf("%w: state %v is neither 0 (off) nor 1 (on)", errBadRule, r.State)
+	}
+	for _, d := range r.Weekdays {
+		if !knownWeekday(d) {
+			return fmt.Errorf("%w: unknown weekday %q", errBadRule, d)
+		}
+	}
+	return nil
+}
+
+// knownWeekday checks if the name is a full or short (three letters) weekday name, ignoring the case
+func knownWeekday(name string) bool {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(name, d.String()) || strings.EqualFold(name, d.String()[:3]) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateRules makes sure all rules are valid
+func validateRules(rules []Rule) error {
+	for _, r := range rules {
+		if err := validateRule(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropBadRules returns the valid rules, logging the ones that are dropped
+func dropBadRules(rules []Rule) (valid []Rule) {
+	for _, r := range rules {
+		if err := validateRule(r); err != nil {
+			log.Printf("Dropping rule %+v: %s\n", r, err)
+			continue
+		}
+		valid = append(valid, r)
+	}
+	return valid
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Rules from the example "on at dusk - 20 min, off at 23:30, on at 06:00 on weekdays, off at sunrise + 15 min"
+var exampleRules = []Rule{
+	{Event: "dusk", Offset: -20, State: 1},
+	{Event: "time", Time: "23:30", State: 0},
+	{Event: "time", Time: "06:00", State: 1, Weekdays: []string{"Mon", "Tue", "Wed", "Thu", "Fri"}},
+	{Event: "sunrise", Offset: 15, State: 0},
+}
+
+func TestRuleTriggerTime(t *testing.T) {
+	// Wednesday in Stockholm, in the winter
+	day := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	st := calculateSunTimes(day, 59.3293, 18.0686)
+
+	at, ok := exampleRules[0].triggerTime(day, st)
+	if !ok || !at.Equal(st.Dusk.Add(-20*time.Minute)) {
+		t.Errorf("expected trigger 20 minutes before dusk (%s), got %s", st.Dusk, at)
+	}
+	at, ok = exampleRules[1].triggerTime(day, st)
+	if !ok || at.Hour() != 23 || at.Minute() != 30 {
+		t.Errorf("expected trigger at 23:30, got %s", at)
+	}
+	// Weekday filter
+	if _, ok = exampleRules[2].triggerTime(day, st); !ok {
+		t.Errorf("expected the weekday rule to trigger on a wednesday")
+	}
+	saturday := day.AddDate(0, 0, 3)
+	if _, ok = exampleRules[2].triggerTime(saturday, calculateSunTimes(saturday, 59.3293, 18.0686)); ok {
+		t.Errorf("expected the weekday rule to not trigger on a saturday")
+	}
+	// Bad rules are ignored
+	if _, ok = (Rule{Event: "moonrise"}).triggerTime(day, st); ok {
+		t.Errorf("expected unknown events to be ignored")
+	}
+	if _, ok = (Rule{Event: "time", Time: "25:61"}).triggerTime(day, st); ok {
+		t.Errorf("expected bad clock times to be ignored")
+	}
+}
+
+func TestRuleState(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	ua.Latitude, ua.Longitude = 59.3293, 18.0686
+	ua.Rules = exampleRules
+	st := calculateSunTimes(time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC), ua.Latitude, ua.Longitude)
+
+	cases := []struct {
+		name string
+		at   time.Time
+		want float64
+	}{
+		{"after dusk", st.Dusk, 1},
+		{"before midnight", time.Date(2024, 1, 10, 23, 45, 0, 0, time.UTC), 0},
+		{"early morning", time.Date(2024, 1, 10, 6, 30, 0, 0, time.UTC), 1},
+		{"after sunrise", st.Sunrise.Add(20 * time.Minute), 0},
+		{"saturday morning", time.Date(2024, 1, 13, 6, 30, 0, 0, time.UTC), 0},
+	}
+	for _, c := range cases {
+		got, ok := ua.ruleState(c.at, ua.rules())
+		if !ok || got != c.want {
+			t.Errorf("%s: expected state %v, got %v (found: %v)", c.name, c.want, got, ok)
+		}
+	}
+}
+
+func TestDefaultRules(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	ua.Rules = nil
+	st := calculateSunTimes(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), ua.Latitude, ua.Longitude)
+	if state, _ := ua.ruleState(st.SolarNoon, ua.rules()); state != 0 {
+		t.Errorf("expected the button to be off during the day, got %v", state)
+	}
+	if state, _ := ua.ruleState(st.Sunset.Add(time.Minute), ua.rules()); state != 1 {
+		t.Errorf("expected the button to be on after sunset, got %v", state)
+	}
+}
+
+func TestDesiredStatePolar(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	ua.Latitude, ua.Longitude = 69.6492, 18.9553 // Tromsø
+	polarNight := time.Date(2024, 12, 21, 12, 0, 0, 0, time.UTC)
+	midnightSun := time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC)
+
+	// The template turns the button off during the midnight sun, and follows a schedule during polar night
+	if state, ok := ua.desiredState(midnightSun); !ok || state != 0 {
+		t.Errorf("expected the button to be off during midnight sun, got %v", state)
+	}
+	if state, ok := ua.desiredState(polarNight); !ok || state != 1 {
+		t.Errorf("expected the button to be on at noon during polar night, got %v", state)
+	}
+	if state, ok := ua.desiredState(polarNight.Add(11*time.Hour + 30*time.Minute)); !ok || state != 0 {
+		t.Errorf("expected the button to be off at 23:30 during polar night, got %v", state)
+	}
+	// Other policies
+	ua.MidnightSunPolicy = "on"
+	ua.PolarNightPolicy = "off"
+	if state, _ := ua.desiredState(midnightSun); state != 1 {
+		t.Errorf("expected the button to be on during midnight sun, got %v", state)
+	}
+	if state, _ := ua.desiredState(polarNight); state != 0 {
+		t.Errorf("expected the button to be off during polar night, got %v", state)
+	}
+	// Without a policy, the normal rules has nothing to trigger on
+	ua.MidnightSunPolicy = ""
+	if _, ok := ua.desiredState(midnightSun); ok {
+		t.Errorf("expected no state during midnight sun without a policy")
+	}
+}
+
+func TestNextTransition(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	ua.Latitude, ua.Longitude = 59.3293, 18.0686
+	ua.Rules = exampleRules
+	st := calculateSunTimes(time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC), ua.Latitude, ua.Longitude)
+
+	// At noon on a wednesday the button is off, and turns on 20 minutes before dusk
+	at, state, ok := ua.nextTransition(st.SolarNoon)
+	if !ok || state != 1 || !at.Equal(st.Dusk.Add(-20*time.Minute)) {
+		t.Errorf("expected the button to turn on at %s, got %v at %s", st.Dusk.Add(-20*time.Minute), state, at)
+	}
+	// After that it turns off at 23:30
+	at, state, ok = ua.nextTransition(st.Dusk)
+	if !ok || state != 0 || !at.Equal(time.Date(2024, 1, 10, 23, 30, 0, 0, time.UTC)) {
+		t.Errorf("expected the button to turn off at 23:30, got %v at %s", state, at)
+	}
+	// The weekday rule is skipped during the weekend, so friday night is followed by saturday evening
+	friday := time.Date(2024, 1, 12, 23, 45, 0, 0, time.UTC)
+	at, state, ok = ua.nextTransition(friday)
+	saturday := calculateSunTimes(friday.AddDate(0, 0, 1), ua.Latitude, ua.Longitude)
+	if !ok || state != 1 || !at.Equal(saturday.Dusk.Add(-20*time.Minute)) {
+		t.Errorf("expected the button to turn on at saturday dusk, got %v at %s", state, at)
+	}
+	// The midnight sun keeps the button off all day, so it never changes
+	ua.Latitude, ua.Longitude = 69.6492, 18.9553
+	if _, _, ok = ua.nextTransition(time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC)); ok {
+		t.Errorf("expected no transition during the midnight sun")
+	}
+	// But the regime changes at midnight, which turns on the polar night schedule
+	ua.MidnightSunPolicy, ua.PolarNightPolicy = "on", "off"
+	ua.Latitude = 78.2232 // Longyearbyen, the midnight sun ends at the end of august
+	at, state, ok = ua.nextTransition(time.Date(2024, 8, 20, 12, 0, 0, 0, time.UTC))
+	if !ok || state != 0 || at.Hour() != 0 || at.Minute() != 0 {
+		t.Errorf("expected the button to turn off at midnight, got %v at %s", state, at)
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	if err := validateRules(exampleRules); err != nil {
+		t.Errorf("expected the example rules to be valid, got %s", err)
+	}
+	if err := validateRules([]Rule{{Event: "moonrise"}}); err == nil {
+		t.Errorf("expected an error for unknown events")
+	}
+	if err := validateRules([]Rule{{Event: "time", Time: "25:61"}}); err == nil {
+		t.Errorf("expected an error for bad clock times")
+	}
+}
+
+func TestDropBadRules(t *testing.T) {
+	rules := dropBadRules([]Rule{{Event: "moonrise"}, {Event: "dusk", State: 1}, {Event: "time", Time: "noon"}})
+	if len(rules) != 1 || rules[0].Event != "dusk" {
+		t.Errorf("expected only the dusk rule to be kept, got %+v", rules)
+	}
+	rules = dropBadRules([]Rule{{Event: "dusk", State: 0.5}, {Event: "dusk", State: 1, Weekdays: []string{"Monday", "tue", "Funday"}}})
+	if len(rules) != 0 {
+		t.Errorf("expected bad states and unknown weekdays to be dropped, got %+v", rules)
+	}
+	rules = dropBadRules([]Rule{{Event: "dusk", State: 1, Weekdays: []string{"Monday", "tue", "SAT"}}})
+	if len(rules) != 1 {
+		t.Errorf("expected full and short weekday names to be valid, got %+v", rules)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// The sun times below are calculated locally using the NOAA solar position algorithm, see:
+// https://gml.noaa.gov/grad/solcalc/calcdetails.html
+// The results are within a minute or so of the NOAA tables for latitudes between +/- 72 degrees.
+
+// Zenith angles (in degrees) for the different sun events. The sunrise/sunset angle includes
+// the atmospheric refraction and the radius of the sun.
+const (
+	zenithSunrise      float64 = 90.833
+	zenithCivil        float64 = 96
+	zenithNautical     float64 = 102
+	zenithAstronomical float64 = 108
+	zenithGoldenHour   float64 = 84 // The sun is 6 degrees above the horizon
+)
+
+// SunRegime tells if the sun rises and sets during a day, or if it's polar day or night
+type SunRegime int
+
+const (
+	NormalDay   SunRegime = iota // The sun rises and sets
+	MidnightSun                  // The sun never sets
+	PolarNight                   // The sun never rises
+)
+
+// String returns the name of the regime, as used by the services
+func (r SunRegime) String() string {
+	switch r {
+	case MidnightSun:
+		return "midnight_sun"
+	case PolarNight:
+		return "polar_night"
+	}
+	return "normal"
+}
+
+// SunTimes holds all sun events for a single day. An event that doesn't occur that day
+// (for example a sunset during the midnight sun) is left as a zero time.
+type SunTimes struct {
+	Date         time.Time     // Midnight of the day that the events belongs to
+	FirstLight   time.Time     // Start of astronomical twilight
+	NauticalDawn time.Time     // Start of nautical twilight
+	Dawn         time.Time     // Start of civil twilight
+	Sunrise      time.Time     // Upper edge of the sun appears over the horizon
+	SolarNoon    time.Time     // The sun is at its highest
+	GoldenHour   time.Time     // Start of the evening golden hour
+	Sunset       time.Time     // Upper edge of the sun disappears under the horizon
+	Dusk         time.Time     // End of civil twilight
+	NauticalDusk time.Time     // End of nautical twilight
+	LastLight    time.Time     // End of astronomical twilight
+	DayLength    time.Duration // Time between sunrise and sunset
+	Regime       SunRegime     // Midnight sun or polar night if the sun never rises or sets
+}
+
+// calculateSunTimes calculates the sun events for the day of the given date, at the given position
+// (in degrees, north and east are positive). The returned times uses the same location as the date.
+func calculateSunTimes(date time.Time, lat, lng float64) (st SunTimes) {
+	loc := date.Location()
+	y, m, d := date.Date()
+	st.Date = time.Date(y, m, d, 0, 0, 0, 0, loc)
+	// The events are calculated in minutes from midnight UTC, for the same calendar day
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	jd := julianDay(midnight)
+
+	event := func(minutes float64, ok bool) time.Time {
+		if !ok {
+			return time.Time{}
+		}
+		return midnight.Add(time.Duration(minutes * float64(time.Minute))).In(loc)
+	}
+
+	st.SolarNoon = event(solarNoonUTC(jd, lng), true)
+	st.FirstLight = event(sunEventUTC(jd, lat, lng, zenithAstronomical, true))
+	st.NauticalDawn = event(sunEventUTC(jd, lat, lng, zenithNautical, true))
+	st.Dawn = event(sunEventUTC(jd, lat, lng, zenithCivil, true))
+	st.Sunrise = event(sunEventUTC(jd, lat, lng, zenithSunrise, true))
+	st.GoldenHour = event(sunEventUTC(jd, lat, lng, zenithGoldenHour, false))
+	st.Sunset = event(sunEventUTC(jd, lat, lng, zenithSunrise, false))
+	st.Dusk = event(sunEventUTC(jd, lat, lng, zenithCivil, false))
+	st.NauticalDusk = event(sunEventUTC(jd, lat, lng, zenithNautical, false))
+	st.LastLight = event(sunEventUTC(jd, lat, lng, zenithAstronomical, false))
+	if !st.Sunrise.IsZero() && !st.Sunset.IsZero() {
+		st.DayLength = st.Sunset.Sub(st.Sunrise)
+	}
+	if st.Sunrise.IsZero() && st.Sunset.IsZero() {
+		st.Regime = polarRegime(jd, lat, lng)
+		if st.Regime == MidnightSun {
+			st.DayLength = 24 * time.Hour
+		}
+	}
+	return
+}
+
+// polarRegime checks if the sun stays above (midnight sun) or below (polar night) the horizon at solar noon
+func polarRegime(jd, lat, lng float64) SunRegime {
+	t := julianCentury(jd + solarNoonUTC(jd, lng)/1440)
+	if cosHourAngle(lat, sunDeclination(t), zenithSunrise) < -1 {
+		return MidnightSun
+	}
+	return PolarNight
+}
+
+// sunPosition calculates the position of the sun at the given time and position, returning the elevation
+// above the horizon (corrected for the atmospheric refraction) and the azimuth clockwise from north, in degrees.
+func sunPosition(t time.Time, lat, lng float64) (elevation, azimuth float64) {
+	jc := julianCentury(julianDay(t))
+	decl := sunDeclination(jc)
+	u := t.UTC()
+	minutes := float64(u.Hour()*60+u.Minute()) + float64(u.Second())/60
+	trueSolarTime := math.Mod(minutes+equationOfTime(jc)+4*lng, 1440)
+	if trueSolarTime < 0 {
+		trueSolarTime += 1440
+	}
+	ha := trueSolarTime/4 - 180
+
+	latR, declR := rad(lat), rad(decl)
+	cosZenith := math.Sin(latR)*math.Sin(declR) + math.Cos(latR)*math.Cos(declR)*math.Cos(rad(ha))
+	zenith := math.Acos(math.Max(-1, math.Min(1, cosZenith)))
+	elevation = 90 - deg(zenith) + refraction(90-deg(zenith))
+
+	denom := math.Cos(latR) * math.Sin(zenith)
+	if math.Abs(denom) < 0.001 {
+		// The sun is straight above (or a pole), the azimuth is undefined
+		return elevation, 180
+	}
+	cosAz := (math.Sin(latR)*math.Cos(zenith) - math.Sin(declR)) / denom
+	azimuth = 180 - deg(math.Acos(math.Max(-1, math.Min(1, cosAz))))
+	if ha > 0 {
+		azimuth = -azimuth
+	}
+	if azimuth < 0 {
+		azimuth += 360
+	}
+	return elevation, azimuth
+}
+
+// refraction returns the approximate atmospheric refraction (in degrees) for an elevation without refraction
+func refraction(elevation float64) float64 {
+	if elevation > 85 {
+		return 0
+	}
+	te := math.Tan(rad(elevation))
+	var arcsec float64
+	switch {
+	case elevation > 5:
+		arcsec = 58.1/te - 0.07/(te*te*te) + 0.000086/math.Pow(te, 5)
+	case elevation > -0.575:
+		arcsec = 1735 + elevation*(-518.2+elevation*(103.4+elevation*(-12.79+elevation*0.711)))
+	default:
+		arcsec = -20.772 / te
+	}
+	return arcsec / 3600
+}
+
+// solarNoonUTC returns the solar noon, in minutes from midnight UTC
+func solarNoonUTC(jd, lng float64) float64 {
+	// First guess using the approximate noon, then refine it using the new time
+	t := julianCentury(jd - lng/360)
+	noon := 720 - 4*lng - equationOfTime(t)
+	t = julianCentury(jd + noon/1440)
+	return 720 - 4*lng - equationOfTime(t)
+}
+
+// sunEventUTC returns the time (in minutes from midnight UTC) when the sun passes the zenith angle,
+// either in the morning (rising) or the evening. The bool is false if the sun never passes the angle.
+func sunEventUTC(jd, lat, lng, zenith float64, rising bool) (float64, bool) {
+	minutes := solarNoonUTC(jd, lng)
+	// Two passes are enough, the second pass uses the sun's position at the first result
+	for i := 0; i < 2; i++ {
+		t := julianCentury(jd + minutes/1440)
+		ha, ok := hourAngle(lat, sunDeclination(t), zenith)
+		if !ok {
+			return 0, false
+		}
+		if rising {
+			ha = -ha
+		}
+		minutes = 720 - 4*(lng-ha) - equationOfTime(t)
+	}
+	return minutes, true
+}
+
+// hourAngle returns the hour angle (in degrees) when the sun passes the zenith angle, the bool is
+// false if the sun stays above or below the angle all day long
+func hourAngle(lat, decl, zenith float64) (float64, bool) {
+	cosHA := cosHourAngle(lat, decl, zenith)
+	if cosHA < -1 || cosHA > 1 {
+		return 0, false
+	}
+	return deg(math.Acos(cosHA)), true
+}
+
+// cosHourAngle returns the cosine of the hour angle, which is below -1 if the sun stays above the
+// zenith angle all day or above 1 if it stays below it
+func cosHourAngle(lat, decl, zenith float64) float64 {
+	latR, declR := rad(lat), rad(decl)
+	return math.Cos(rad(zenith))/(math.Cos(latR)*math.Cos(declR)) - math.Tan(latR)*math.Tan(declR)
+}
+
+// julianDay converts a time to the julian day number (with fractions)
+func julianDay(t time.Time) float64 {
+	return float64(t.UTC().Unix())/86400 + 2440587.5
+}
+
+// julianCentury converts a julian day to julian centuries since J2000.0
+func julianCentury(jd float64) float64 {
+	return (jd - 2451545) / 36525
+}
+
+func geomMeanLongSun(t float64) float64 {
+	l0 := math.Mod(280.46646+t*(36000.76983+t*0.0003032), 360)
+	if l0 < 0 {
+		l0 += 360
+	}
+	return l0
+}
+
+func geomMeanAnomalySun(t float64) float64 {
+	return 357.52911 + t*(35999.05029-0.0001537*t)
+}
+
+func eccentricityEarthOrbit(t float64) float64 {
+	return 0.016708634 - t*(0.000042037+0.0000001267*t)
+}
+
+func sunEqOfCenter(t float64) float64 {
+	m := rad(geomMeanAnomalySun(t))
+	return math.Sin(m)*(1.914602-t*(0.004817+0.000014*t)) +
+		math.Sin(2*m)*(0.019993-0.000101*t) +
+		math.Sin(3*m)*0.000289
+}
+
+func sunApparentLong(t float64) float64 {
+	trueLong := geomMeanLongSun(t) + sunEqOfCenter(t)
+	omega := 125.04 - 1934.136*t
+	return trueLong - 0.00569 - 0.00478*math.Sin(rad(omega))
+}
+
+func obliquityCorrection(t float64) float64 {
+	seconds := 21.448 - t*(46.8150+t*(0.00059-t*0.001813))
+	e0 := 23 + (26+seconds/60)/60
+	omega := 125.04 - 1934.136*t
+	return e0 + 0.00256*math.Cos(rad(omega))
+}
+
+// sunDeclination returns the declination of the sun in degrees
+func sunDeclination(t float64) float64 {
+	return deg(math.Asin(math.Sin(rad(obliquityCorrection(t))) * math.Sin(rad(sunApparentLong(t)))))
+}
+
+// equationOfTime returns the difference between true solar time and mean solar time in minutes
+func equationOfTime(t float64) float64 {
+	epsilon := obliquityCorrection(t)
+	l0 := rad(geomMeanLongSun(t))
+	e := eccentricityEarthOrbit(t)
+	m := rad(geomMeanAnomalySun(t))
+	y := math.Pow(math.Tan(rad(epsilon)/2), 2)
+
+	eqTime := y*math.Sin(2*l0) - 2*e*math.Sin(m) + 4*e*y*math.Sin(m)*math.Cos(2*l0) -
+		0.5*y*y*math.Sin(4*l0) - 1.25*e*e*math.Sin(2*m)
+	return deg(eqTime) * 4
+}
+
+func rad(d float64) float64 {
+	return d * math.Pi / 180
+}
+
+func deg(r float64) float64 {
+	return r * 180 / math.Pi
+}
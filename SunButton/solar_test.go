@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Reference times are taken from the NOAA solar calculator (https://gml.noaa.gov/grad/solcalc/),
+// rounded to whole minutes
+type sunTable struct {
+	name     string
+	date     time.Time
+	lat, lng float64
+	sunrise  string
+	noon     string
+	sunset   string
+}
+
+var sunTables = []sunTable{
+	{"London summer solstice", time.Date(2024, 6, 20, 0, 0, 0, 0, time.FixedZone("BST", 3600)), 51.5074, -0.1278, "04:43", "13:02", "21:21"},
+	{"New York winter solstice", time.Date(2024, 12, 21, 0, 0, 0, 0, time.FixedZone("EST", -5*3600)), 40.7128, -74.0060, "07:17", "11:54", "16:32"},
+	{"Sydney new year", time.Date(2024, 1, 1, 0, 0, 0, 0, time.FixedZone("AEDT", 11*3600)), -33.8688, 151.2093, "05:47", "12:58", "20:09"},
+	{"Equator spring equinox", time.Date(2024, 3, 20, 0, 0, 0, 0, time.UTC), 0, 0, "06:04", "12:07", "18:11"},
+}
+
+// The calculated times are allowed to differ from the tables by rounding and a small error
+const sunTableLimit time.Duration = 2 * time.Minute
+
+func TestCalculateSunTimes(t *testing.T) {
+	for _, table := range sunTables {
+		st := calculateSunTimes(table.date, table.lat, table.lng)
+		check := func(event string, got time.Time, want string) {
+			w, err := time.ParseInLocation("15:04", want, table.date.Location())
+			if err != nil {
+				t.Fatalf("bad table time %q: %s", want, err)
+			}
+			w = time.Date(table.date.Year(), table.date.Month(), table.date.Day(), w.Hour(), w.Minute(), 0, 0, table.date.Location())
+			diff := got.Sub(w)
+			if diff < -sunTableLimit || diff > sunTableLimit {
+				t.Errorf("%s: expected %s at %s, got %s", table.name, event, w.Format("15:04"), got.Format("15:04:05"))
+			}
+		}
+		check("sunrise", st.Sunrise, table.sunrise)
+		check("solar noon", st.SolarNoon, table.noon)
+		check("sunset", st.Sunset, table.sunset)
+	}
+}
+
+func TestSunTimesOrder(t *testing.T) {
+	st := calculateSunTimes(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), 59.3293, 18.0686)
+	events := []time.Time{st.FirstLight, st.NauticalDawn, st.Dawn, st.Sunrise, st.SolarNoon, st.GoldenHour, st.Sunset, st.Dusk, st.NauticalDusk, st.LastLight}
+	for i := 1; i < len(events); i++ {
+		if events[i].IsZero() || !events[i].After(events[i-1]) {
+			t.Errorf("expected event %d (%s) to happen after event %d (%s)", i, events[i], i-1, events[i-1])
+		}
+	}
+	if st.DayLength != st.Sunset.Sub(st.Sunrise) {
+		t.Errorf("expected day length to be %s, got %s", st.Sunset.Sub(st.Sunrise), st.DayLength)
+	}
+}
+
+func TestSunTimesPolar(t *testing.T) {
+	// Tromsø has no sunrise or sunset during the polar night, but still has some twilight
+	st := calculateSunTimes(time.Date(2024, 12, 21, 0, 0, 0, 0, time.UTC), 69.6492, 18.9553)
+	if !st.Sunrise.IsZero() || !st.Sunset.IsZero() {
+		t.Errorf("expected no sunrise or sunset during polar night, got %s and %s", st.Sunrise, st.Sunset)
+	}
+	if st.Dawn.IsZero() || st.Dusk.IsZero() {
+		t.Errorf("expected civil twilight during polar night")
+	}
+	if st.DayLength != 0 {
+		t.Errorf("expected no day length, got %s", st.DayLength)
+	}
+	if st.Regime != PolarNight {
+		t.Errorf("expected polar night, got regime %v", st.Regime)
+	}
+	// And the sun never sets during the midnight sun
+	st = calculateSunTimes(time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC), 69.6492, 18.9553)
+	if !st.Sunrise.IsZero() || !st.Sunset.IsZero() {
+		t.Errorf("expected no sunrise or sunset during midnight sun, got %s and %s", st.Sunrise, st.Sunset)
+	}
+	if st.Regime != MidnightSun || st.DayLength != 24*time.Hour {
+		t.Errorf("expected midnight sun for 24h, got regime %v for %s", st.Regime, st.DayLength)
+	}
+	// While Luleå (just south of the arctic circle) still has a short night
+	st = calculateSunTimes(time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC), 65.584816, 22.156704)
+	if st.Regime != NormalDay {
+		t.Errorf("expected a normal day, got regime %v", st.Regime)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sdoque/mbaigo/components"
+	"github.com/sdoque/mbaigo/forms"
+	"github.com/sdoque/mbaigo/usecases"
+)
+
+// A Target is a unit asset (in another system) that is controlled by the button.
+// Example: {"location": ["Kitchen"], "service": "setpoint", "unit": "Celsius", "on": 22, "off": 18}
+// sets all thermostats in the kitchen to 22 degrees when the button turns on.
+type Target struct {
+	Name     string   `json:"name"`     // Name of the unit asset to control, leave empty to match by location instead
+	Location []string `json:"location"` // Matches the unit assets by their Location details
+	Service  string   `json:"service"`  // The service to set, defaults to "state"
+	Unit     string   `json:"unit"`     // Unit of the sent values, defaults to the unit of the button status
+	On       float64  `json:"on"`       // Value sent when the button turns on
+	Off      float64  `json:"off"`      // Value sent when the button turns off, if both are 0 the button status is sent as is
+}
+
+// TargetStatus is the result of the latest attempt to send a value to a target
+type TargetStatus struct {
+	Target string    `json:"target"`
+	Value  float64   `json:"value"`
+	Sent   time.Time `json:"sent"`
+	Error  string    `json:"error"`
+}
+
+// defaultTarget keeps the old behaviour, when the unit asset has no targets of its own, and controls
+// the "state" service of the unit assets that has the same details as the button
+var defaultTarget = Target{Service: "state"}
+
+// targets returns the targets of the unit asset, or the default target if none has been set
+func (ua *UnitAsset) targets() []Target {
+	if len(ua.Targets) < 1 {
+		return []Target{defaultTarget}
+	}
+	return ua.Targets
+}
+
+// service returns the name of the service to set on the target
+func (t Target) service() string {
+	if t.Service == "" {
+		return "state"
+	}
+	return t.Service
+}
+
+// label returns a readable name for the target, used when reporting its status. It isn't unique, since
+// two targets might control the same unit assets.
+func (t Target) label() string {
+	who := t.Name
+	if who == "" {
+		who = strings.Join(t.Location, ",")
+	}
+	if who == "" {
+		return t.service()
+	}
+	return who + "/" + t.service()
+}
+
+// targetKey returns the key of the cervice for the target with the index
+func targetKey(i int) string {
+	return fmt.Sprintf("target%d", i)
+}
+
+// value maps a button status to the value that should be sent to the target
+func (t Target) value(state float64) float64 {
+	if t.On == 0 && t.Off == 0 {
+		return state
+	}
+	if state == 0 {
+		return t.Off
+	}
+	return t.On
+}
+
+// newTargetCervices creates a cervice for each target. The details are used by the orchestrator to find
+// the matching unit assets, either by name, by location or by the same details as the button.
+func (ua *UnitAsset) newTargetCervices(protos []string, ref components.Service) components.Cervices {
+	cervices := components.Cervices{}
+	for i, t := range ua.targets() {
+		details := map[string][]string{}
+		switch {
+		case t.Name != "":
+			details["Name"] = []string{t.Name}
+		case len(t.Location) > 0:
+			details["Location"] = t.Location
+		default:
+			details = components.MergeDetails(details, ua.Details)
+		}
+		unit := t.Unit
+		if unit == "" && len(ref.Details["Unit"]) > 0 {
+			unit = ref.Details["Unit"][0]
+		}
+		if unit != "" {
+			details["Unit"] = []string{unit}
+		}
+		details["Forms"] = ref.Details["Forms"]
+		cervices[targetKey(i)] = &components.Cervice{
+			Name:    t.service(),
+			Protos:  protos,
+			Url:     make([]string, 0),
+			Details: details,
+		}
+	}
+	return cervices
+}
+
+var errTargetsFailed error = fmt.Errorf("failed to update targets")
+var errMissingTarget error = fmt.Errorf("missing cervice for target")
+
+// sendStatus sends the button status to all targets in parallel. When retrying, only the targets
+// that failed during the last attempt are sent to again. The mutex is only locked while reading the
+// button status and saving the results, not while waiting for the targets.
+func (ua *UnitAsset) sendStatus(retry bool) error {
+	ua.mutex.Lock()
+	targets := ua.targets()
+	state := ua.ButtonStatus
+	results := make([]TargetStatus, len(targets))
+	send := make([]bool, len(targets))
+	for i := range targets {
+		send[i] = !retry || i >= len(ua.targetStatus) || ua.targetStatus[i].Error != ""
+		if !send[i] {
+			results[i] = ua.targetStatus[i]
+		}
+	}
+	ua.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		if !send[i] {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, t Target) {
+			defer wg.Done()
+			results[i] = ua.sendTarget(i, t, state)
+		}(i, t)
+	}
+	wg.Wait()
+
+	ua.mutex.Lock()
+	ua.targetStatus = results
+	ua.mutex.Unlock()
+
+	failed := 0
+	for _, r := range results {
+		if r.Error != "" {
+			log.Printf("Cannot update target %s: %s\n", r.Target, r.Error)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d", errTargetsFailed, failed, len(results))
+	}
+	return nil
+}
+
+// getTargetStatus returns a copy of the result of the latest update of each target
+func (ua *UnitAsset) getTargetStatus() []TargetStatus {
+	ua.mutex.Lock()
+	defer ua.mutex.Unlock()
+	return slices.Clone(ua.targetStatus)
+}
+
+// sendTarget sends the mapped button status to the target with the index
+func (ua *UnitAsset) sendTarget(i int, t Target, state float64) (status TargetStatus) {
+	status.Target = t.label()
+	status.Value = t.value(state)
+	status.Sent = time.Now()
+	cer, found := ua.CervicesMap[targetKey(i)]
+	if !found {
+		status.Error = errMissingTarget.Error()
+		return
+	}
+	// Prepare the form to send
+	var of forms.SignalA_v1a
+	of.NewForm()
+	of.Value = status.Value
+	if len(cer.Details["Unit"]) > 0 {
+		of.Unit = cer.Details["Unit"][0]
+	}
+	of.Timestamp = status.Sent
+	// Pack() converting the data in "of" into JSON format
+	op, err := usecases.Pack(&of, "application/json")
+	if err != nil {
+		status.Error = err.Error()
+		return
+	}
+	// Send the new request
+	if err = usecases.SetState(cer, ua.Owner, op); err != nil {
+		status.Error = err.Error()
+	}
+	return
+}
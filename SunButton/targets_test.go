@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sdoque/mbaigo/components"
+)
+
+func TestTargetValue(t *testing.T) {
+	plain := Target{}
+	if plain.value(1) != 1 || plain.value(0) != 0 {
+		t.Errorf("expected the button status to be sent as is")
+	}
+	setpoint := Target{Service: "setpoint", On: 22, Off: 18}
+	if setpoint.value(1) != 22 || setpoint.value(0) != 18 {
+		t.Errorf("expected the setpoint to be 22 when on and 18 when off")
+	}
+	inverted := Target{On: 0, Off: 1}
+	if inverted.value(1) != 0 || inverted.value(0) != 1 {
+		t.Errorf("expected the inverted target to be off when the button is on")
+	}
+}
+
+func TestTargetLabel(t *testing.T) {
+	cases := []struct {
+		target Target
+		want   string
+	}{
+		{defaultTarget, "state"},
+		{Target{Name: "SmartPlug1"}, "SmartPlug1/state"},
+		{Target{Location: []string{"Kitchen", "Hallway"}, Service: "setpoint"}, "Kitchen,Hallway/setpoint"},
+	}
+	for _, c := range cases {
+		if got := c.target.label(); got != c.want {
+			t.Errorf("expected label %q, got %q", c.want, got)
+		}
+	}
+}
+
+func TestNewTargetCervices(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	ref := *ua.ServicesMap["ButtonStatus"]
+
+	// Without any targets the button controls the unit assets with the same details
+	ua.Targets = nil
+	cervices := ua.newTargetCervices([]string{"http"}, ref)
+	if cer, found := cervices[targetKey(0)]; !found || cer.Name != "state" || cer.Details["Location"][0] != "Kitchen" {
+		t.Errorf("expected a default state cervice for the kitchen, got %+v", cervices)
+	}
+
+	// Targets with the same label gets their own cervices
+	ua.Targets = []Target{
+		{Name: "SmartPlug1"},
+		{Location: []string{"Hallway"}, Service: "setpoint", Unit: "Celsius", On: 22, Off: 18},
+		{Location: []string{"Hallway"}, Service: "setpoint", Unit: "Celsius", On: 21, Off: 17},
+	}
+	cervices = ua.newTargetCervices([]string{"http"}, ref)
+	if len(cervices) != 3 {
+		t.Fatalf("expected 3 cervices, got %d", len(cervices))
+	}
+	plug := cervices[targetKey(0)]
+	if plug == nil || plug.Details["Name"][0] != "SmartPlug1" || plug.Details["Unit"][0] != "bool" {
+		t.Errorf("expected the plug to be matched by name, got %+v", plug)
+	}
+	thermostat := cervices[targetKey(1)]
+	if thermostat == nil || thermostat.Name != "setpoint" || thermostat.Details["Unit"][0] != "Celsius" {
+		t.Errorf("expected a setpoint cervice in the hallway, got %+v", thermostat)
+	}
+}
+
+// hostTransport fails all requests to the bad hosts, and counts the requests to each host
+type hostTransport struct {
+	mu   sync.Mutex
+	bad  map[string]bool
+	hits map[string]int
+}
+
+var errHostDown error = errors.New("host is down")
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.hits[req.URL.Hostname()]++
+	if t.bad[req.URL.Hostname()] {
+		return nil, errHostDown
+	}
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
+
+func TestSendStatusTargets(t *testing.T) {
+	trans := &hostTransport{bad: map[string]bool{"plug2": true}, hits: map[string]int{}}
+	http.DefaultClient.Transport = trans
+
+	ua := initTemplate().(*UnitAsset)
+	ua.Targets = []Target{{Name: "plug1"}, {Name: "plug2"}, {Name: "plug3", On: 0, Off: 1}}
+	ua.CervicesMap = ua.newTargetCervices([]string{"http"}, *ua.ServicesMap["ButtonStatus"])
+	for i, tgt := range ua.Targets {
+		ua.CervicesMap[targetKey(i)].Url = []string{"http://" + tgt.Name + "/ZigBee/" + tgt.Name + "/state"}
+	}
+	ua.ButtonStatus = 1
+
+	// One failed target is reported on its own, while the others are updated
+	err := ua.sendStatus(false)
+	if !errors.Is(err, errTargetsFailed) {
+		t.Errorf("expected errTargetsFailed, got %v", err)
+	}
+	if len(ua.targetStatus) != 3 || ua.targetStatus[1].Error == "" || ua.targetStatus[0].Error != "" {
+		t.Errorf("expected only the second target to fail, got %+v", ua.targetStatus)
+	}
+	if ua.targetStatus[2].Value != 0 {
+		t.Errorf("expected the inverted target to get 0, got %v", ua.targetStatus[2].Value)
+	}
+
+	// Retrying only sends to the failed target
+	trans.bad["plug2"] = false
+	if err = ua.sendStatus(true); err != nil {
+		t.Errorf("expected no error after the retry, got %v", err)
+	}
+	if trans.hits["plug1"] != 1 || trans.hits["plug2"] != 2 || trans.hits["plug3"] != 1 {
+		t.Errorf("expected only plug2 to be retried, got %v", trans.hits)
+	}
+
+	// Missing cervices are reported as errors
+	ua.CervicesMap = components.Cervices{}
+	if err = ua.sendStatus(false); !errors.Is(err, errTargetsFailed) {
+		t.Errorf("expected errTargetsFailed for missing cervices, got %v", err)
+	}
+	if ua.targetStatus[0].Error != errMissingTarget.Error() {
+		t.Errorf("expected errMissingTarget, got %q", ua.targetStatus[0].Error)
+	}
+}
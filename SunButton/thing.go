@@ -9,11 +9,12 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/sdoque/mbaigo/components"
 	"github.com/sdoque/mbaigo/forms"
-	"github.com/sdoque/mbaigo/usecases"
 )
 
 type SunData struct {
@@ -52,8 +53,38 @@ type UnitAsset struct {
 	oldLatitude  float64
 	Longitude    float64 `json:"Longitude"`
 	oldLongitude float64
+	Timezone     string `json:"Timezone"` // IANA time zone name, used for the clock times and the day changes
+	loc          *time.Location
+	CrossCheck   bool   `json:"CrossCheck"` // Compare the locally calculated sun times with the sun API once a day
+	Rules        []Rule `json:"Rules"`      // When to turn the button on or off, defaults to on between sunset and sunrise
+	// What to do during days when the sun never sets or rises, either "on", "off" or "schedule" (which uses PolarSchedule)
+	MidnightSunPolicy string `json:"MidnightSunPolicy"`
+	PolarNightPolicy  string `json:"PolarNightPolicy"`
+	PolarSchedule     []Rule `json:"PolarSchedule"`
+	// The unit assets controlled by the button, defaults to the "state" of the ones with the same details
+	Targets      []Target `json:"Targets"`
+	targetStatus []TargetStatus
+	// Minutes that a manual change of the button status holds, 0 holds it until the next planned transition
+	OverrideDuration float64 `json:"OverrideDuration"`
+	overrideUntil    time.Time
+	unsent           bool // The button status has been changed manually, but not sent to the targets yet
+	// Optional light sensor feedback during the day: below LuxOn the button turns on and above LuxOff it turns off
+	LuxOn     float64 `json:"LuxOn"`     // Leave at 0 to only use the sun schedule
+	LuxOff    float64 `json:"LuxOff"`    // Should be higher than LuxOn, to avoid flickering
+	LuxMaxAge float64 `json:"LuxMaxAge"` // Seconds before a light level reading is considered stale
+	lux       float64
+	luxTime   time.Time
+	luxState  float64
+	luxKnown  bool
+	// Vacation mode turns the targets on and off randomly between dusk and bedtime, so it looks like someone is home
+	Vacation     bool   `json:"Vacation"`
+	Bedtime      string `json:"Bedtime"`      // Clock time in the "15:04" format
+	VacationSeed int64  `json:"VacationSeed"` // Seed for the random pattern
+	sunTimes     SunTimes
 	data         Data
 	connError    float64
+	replan       chan struct{} // Wakes up the control loop when the settings has changed
+	mutex        *sync.Mutex   // Guards the fields shared by the control loop and the http handlers
 }
 
 // GetName returns the name of the Resource.
@@ -103,24 +134,135 @@ func initTemplate() components.UnitAsset {
 		Details:     map[string][]string{"Unit": {"bool"}, "Forms": {"SignalA_v1a"}},
 		Description: "provides the status of a button (using a GET request)",
 	}
+	setRules := components.Service{
+		Definition:  "Rules",
+		SubPath:     "Rules",
+		Details:     map[string][]string{"Forms": {"JSON"}},
+		Description: "provides the list of rules for when the button turns on or off (using a GET request) or replaces them (using a PUT request)",
+	}
+	setNextTransition := components.Service{
+		Definition:  "NextTransition",
+		SubPath:     "NextTransition",
+		Details:     map[string][]string{"Unit": {"Unix timestamp"}, "Forms": {"SignalA_v1a"}},
+		Description: "provides the time of the next planned change of the button status (using a GET request)",
+	}
+	setNextState := components.Service{
+		Definition:  "NextState",
+		SubPath:     "NextState",
+		Details:     map[string][]string{"Unit": {"bool"}, "Forms": {"SignalA_v1a"}},
+		Description: "provides the button status after the next planned change (using a GET request)",
+	}
+	setTargets := components.Service{
+		Definition:  "Targets",
+		SubPath:     "Targets",
+		Details:     map[string][]string{"Forms": {"JSON"}},
+		Description: "provides the result of the latest update of each target (using a GET request)",
+	}
+	setOverride := components.Service{
+		Definition:  "Override",
+		SubPath:     "Override",
+		Details:     map[string][]string{"Unit": {"Minutes"}, "Forms": {"SignalA_v1a"}},
+		Description: "provides the remaining time of the manual override (using a GET request) or cancels it with a value of 0 (using a PUT request)",
+	}
+	setLightLevel := components.Service{
+		Definition:  "LightLevel",
+		SubPath:     "LightLevel",
+		Details:     map[string][]string{"Unit": {"Lux"}, "Forms": {"SignalA_v1a"}},
+		Description: "provides the latest light level read from the light sensor (using a GET request)",
+	}
+	setVacation := components.Service{
+		Definition:  "Vacation",
+		SubPath:     "Vacation",
+		Details:     map[string][]string{"Unit": {"bool"}, "Forms": {"SignalA_v1a"}},
+		Description: "provides if the vacation mode is on (using a GET request) or turns it on or off (using a PUT request)",
+	}
+	setSunTimes := components.Service{
+		Definition:  "SunTimes",
+		SubPath:     "SunTimes",
+		Details:     map[string][]string{"Forms": {"JSON"}},
+		Description: "provides all sun times for today, or for the day in the date=2006-01-02 query (using a GET request)",
+	}
+	setSunCalendar := components.Service{
+		Definition:  "SunCalendar",
+		SubPath:     "SunCalendar",
+		Details:     map[string][]string{"Forms": {"JSON"}},
+		Description: "provides the sun times for every day of this year, or the year in the year=2006 query (using a GET request)",
+	}
+	setSunElevation := components.Service{
+		Definition:  "SunElevation",
+		SubPath:     "SunElevation",
+		Details:     map[string][]string{"Unit": {"Degrees"}, "Forms": {"SignalA_v1a"}},
+		Description: "provides the current elevation of the sun above the horizon (using a GET request)",
+	}
+	setSunAzimuth := components.Service{
+		Definition:  "SunAzimuth",
+		SubPath:     "SunAzimuth",
+		Details:     map[string][]string{"Unit": {"Degrees"}, "Forms": {"SignalA_v1a"}},
+		Description: "provides the current direction of the sun, clockwise from north (using a GET request)",
+	}
+	setSunRegime := components.Service{
+		Definition:  "SunRegime",
+		SubPath:     "SunRegime",
+		Details:     map[string][]string{"Unit": {"Regime"}, "Forms": {"SignalA_v1a"}},
+		Description: "provides the current sun regime, 0 = normal day, 1 = midnight sun, 2 = polar night (using a GET request)",
+	}
 
-	return &UnitAsset{
+	ua := &UnitAsset{
 		// These fields should reflect a unique asset (ie, a single sensor with unique ID and location)
 		Name:         "Button",
 		Details:      map[string][]string{"Location": {"Kitchen"}},
 		Latitude:     65.584816, // Latitude for the button
 		Longitude:    22.156704, // Longitude for the button
 		ButtonStatus: 0.5,       // Status for the button (on/off) NOTE: This status is neither on or off as default, this is up for the system to decide.
-		Period:       15,
-		data:         Data{SunData{}, ""},
+		Period:       15,        // Seconds to wait before trying again, if the button status couldn't be sent
+		Timezone:     "Europe/Stockholm",
+		CrossCheck:   false, // The sun times are calculated locally, the sun API is only used for optionally checking them
+		// Example rules, turning the button on at sunset and off at sunrise
+		Rules: []Rule{
+			{Event: "sunset", Offset: 0, State: 1},
+			{Event: "sunrise", Offset: 0, State: 0},
+		},
+		// During the midnight sun the button stays off, and during the polar night it follows a fixed schedule
+		MidnightSunPolicy: "off",
+		PolarNightPolicy:  "schedule",
+		PolarSchedule: []Rule{
+			{Event: "time", Time: "06:00", State: 1},
+			{Event: "time", Time: "23:00", State: 0},
+		},
+		Targets: []Target{
+			{Location: []string{"Kitchen"}, Service: "state", On: 1, Off: 0},
+		},
+		OverrideDuration: 0, // Minutes a manual change holds, 0 holds it until the next planned transition
+		LuxOn:            0, // Set to e.g. 50 (and LuxOff to 150) to use a light sensor in the same location
+		LuxOff:           0,
+		LuxMaxAge:        600,
+		Vacation:         false,
+		Bedtime:          "23:00",
+		VacationSeed:     1,
+		data:             Data{SunData{}, ""},
+		mutex:            &sync.Mutex{},
 
 		// Maps the provided services from above
 		ServicesMap: components.Services{
-			setLatitude.SubPath:     &setLatitude,
-			setLongitude.SubPath:    &setLongitude,
-			setButtonStatus.SubPath: &setButtonStatus,
+			setLatitude.SubPath:       &setLatitude,
+			setLongitude.SubPath:      &setLongitude,
+			setButtonStatus.SubPath:   &setButtonStatus,
+			setSunRegime.SubPath:      &setSunRegime,
+			setRules.SubPath:          &setRules,
+			setNextTransition.SubPath: &setNextTransition,
+			setNextState.SubPath:      &setNextState,
+			setTargets.SubPath:        &setTargets,
+			setOverride.SubPath:       &setOverride,
+			setLightLevel.SubPath:     &setLightLevel,
+			setVacation.SubPath:       &setVacation,
+			setSunTimes.SubPath:       &setSunTimes,
+			setSunCalendar.SubPath:    &setSunCalendar,
+			setSunElevation.SubPath:   &setSunElevation,
+			setSunAzimuth.SubPath:     &setSunAzimuth,
 		},
 	}
+	ua.setTimezone(ua.Timezone) // Uses the local time zone if the time zone database is missing
+	return ua
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -132,27 +274,36 @@ func initTemplate() components.UnitAsset {
 func newUnitAsset(uac UnitAsset, sys *components.System, servs []components.Service) (components.UnitAsset, func()) {
 	sProtocol := components.SProtocols(sys.Husk.ProtoPort)
 
-	// the Cervice that is to be consumed by the ZigBee, therefore the name with the C
-	t := &components.Cervice{
-		Name:   "state",
-		Protos: sProtocol,
-		Url:    make([]string, 0),
-	}
-
 	ua := &UnitAsset{
 		// Filling in public fields using the given data
-		Name:         uac.Name,
-		Owner:        sys,
-		Details:      uac.Details,
-		ServicesMap:  components.CloneServices(servs),
-		Latitude:     uac.Latitude,
-		Longitude:    uac.Longitude,
-		ButtonStatus: uac.ButtonStatus,
-		Period:       uac.Period,
-		data:         uac.data,
-		CervicesMap: components.Cervices{
-			t.Name: t,
-		},
+		Name:              uac.Name,
+		Owner:             sys,
+		Details:           uac.Details,
+		ServicesMap:       components.CloneServices(servs),
+		Latitude:          uac.Latitude,
+		Longitude:         uac.Longitude,
+		ButtonStatus:      uac.ButtonStatus,
+		Period:            uac.Period,
+		CrossCheck:        uac.CrossCheck,
+		Rules:             dropBadRules(uac.Rules), // The bad rules would otherwise be logged every time they're checked
+		MidnightSunPolicy: uac.MidnightSunPolicy,
+		PolarNightPolicy:  uac.PolarNightPolicy,
+		PolarSchedule:     dropBadRules(uac.PolarSchedule),
+		Targets:           uac.Targets,
+		OverrideDuration:  uac.OverrideDuration,
+		LuxOn:             uac.LuxOn,
+		LuxOff:            uac.LuxOff,
+		LuxMaxAge:         uac.LuxMaxAge,
+		Vacation:          uac.Vacation,
+		Bedtime:           uac.Bedtime,
+		VacationSeed:      uac.VacationSeed,
+		data:              uac.data,
+		replan:            make(chan struct{}, 1),
+		mutex:             &sync.Mutex{},
+	}
+
+	if err := ua.setTimezone(uac.Timezone); err != nil {
+		log.Printf("Invalid time zone %q, using the local time zone instead: %s\n", uac.Timezone, err)
 	}
 
 	var ref components.Service
@@ -162,7 +313,11 @@ func newUnitAsset(uac UnitAsset, sys *components.System, servs []components.Serv
 		}
 	}
 
-	ua.CervicesMap["state"].Details = components.MergeDetails(ua.Details, ref.Details)
+	// the Cervices that are to be consumed by the targets (the ZigBee systems), therefore the name with the C
+	ua.CervicesMap = ua.newTargetCervices(sProtocol, ref)
+	if ua.luxEnabled() {
+		ua.CervicesMap["lightlevel"] = ua.newLightLevelCervice(sProtocol)
+	}
 
 	// Returns the loaded unit asset and a function to handle
 	return ua, func() {
@@ -171,8 +326,32 @@ func newUnitAsset(uac UnitAsset, sys *components.System, servs []components.Serv
 	}
 }
 
+// location returns the time zone of the unit asset, or the local time zone of the system if it's missing
+func (ua *UnitAsset) location() *time.Location {
+	if ua.loc == nil {
+		return time.Local
+	}
+	return ua.loc
+}
+
+// setTimezone changes the time zone of the unit asset, an empty name uses the local time zone of the system.
+// The time zone is loaded once here, instead of every time it's used. Invalid names are rejected.
+func (ua *UnitAsset) setTimezone(name string) error {
+	loc := time.Local
+	if name != "" {
+		var err error
+		if loc, err = time.LoadLocation(name); err != nil {
+			return err
+		}
+	}
+	ua.Timezone, ua.loc = name, loc
+	return nil
+}
+
 // getLatitude is used for reading the current latitude
 func (ua *UnitAsset) getLatitude() (f forms.SignalA_v1a) {
+	ua.mutex.Lock()
+	defer ua.mutex.Unlock()
 	f.NewForm()
 	f.Value = ua.Latitude
 	f.Unit = "Degrees"
@@ -182,12 +361,17 @@ func (ua *UnitAsset) getLatitude() (f forms.SignalA_v1a) {
 
 // setLatitude is used for updating the current latitude
 func (ua *UnitAsset) setLatitude(f forms.SignalA_v1a) {
+	ua.mutex.Lock()
+	defer ua.mutex.Unlock()
 	ua.oldLatitude = ua.Latitude
 	ua.Latitude = f.Value
+	ua.wakeUp()
 }
 
 // getLongitude is used for reading the current longitude
 func (ua *UnitAsset) getLongitude() (f forms.SignalA_v1a) {
+	ua.mutex.Lock()
+	defer ua.mutex.Unlock()
 	f.NewForm()
 	f.Value = ua.Longitude
 	f.Unit = "Degrees"
@@ -197,12 +381,69 @@ func (ua *UnitAsset) getLongitude() (f forms.SignalA_v1a) {
 
 // setLongitude is used for updating the current longitude
 func (ua *UnitAsset) setLongitude(f forms.SignalA_v1a) {
+	ua.mutex.Lock()
+	defer ua.mutex.Unlock()
 	ua.oldLongitude = ua.Longitude
 	ua.Longitude = f.Value
+	ua.wakeUp()
+}
+
+// setRules replaces the current rules, if they are valid
+func (ua *UnitAsset) setRules(rules []Rule) error {
+	ua.mutex.Lock()
+	defer ua.mutex.Unlock()
+	if err := validateRules(rules); err != nil {
+		return err
+	}
+	ua.Rules = rules
+	ua.wakeUp()
+	return nil
+}
+
+// getRules returns a copy of the current rules, so they can be sent while the control loop runs
+func (ua *UnitAsset) getRules() []Rule {
+	ua.mutex.Lock()
+	defer ua.mutex.Unlock()
+	return slices.Clone(ua.rules())
+}
+
+var errNoTransition error = fmt.Errorf("no transition scheduled")
+
+// getNextTransition is used for reading when the button status changes the next time, it returns
+// errNoTransition if the button status stays the same during the next days
+func (ua *UnitAsset) getNextTransition() (f forms.SignalA_v1a, err error) {
+	ua.mutex.Lock()
+	defer ua.mutex.Unlock()
+	at, _, ok := ua.nextTransition(time.Now().In(ua.location()))
+	if !ok {
+		return f, errNoTransition
+	}
+	f.NewForm()
+	f.Value = float64(at.Unix())
+	f.Unit = "Unix timestamp"
+	f.Timestamp = time.Now()
+	return f, nil
+}
+
+// getNextState is used for reading the button status after the next change
+func (ua *UnitAsset) getNextState() (f forms.SignalA_v1a) {
+	ua.mutex.Lock()
+	defer ua.mutex.Unlock()
+	f.NewForm()
+	_, state, ok := ua.nextTransition(time.Now().In(ua.location()))
+	if !ok {
+		state = ua.ButtonStatus
+	}
+	f.Value = state
+	f.Unit = "bool"
+	f.Timestamp = time.Now()
+	return f
 }
 
 // getButtonStatus is used for reading the current button status
 func (ua *UnitAsset) getButtonStatus() (f forms.SignalA_v1a) {
+	ua.mutex.Lock()
+	defer ua.mutex.Unlock()
 	f.NewForm()
 	f.Value = ua.ButtonStatus
 	f.Unit = "bool"
@@ -210,101 +451,213 @@ func (ua *UnitAsset) getButtonStatus() (f forms.SignalA_v1a) {
 	return f
 }
 
-// setButtonStatus is used for updating the current button status
+// setButtonStatus is used for updating the current button status, which starts a manual override
 func (ua *UnitAsset) setButtonStatus(f forms.SignalA_v1a) {
+	ua.mutex.Lock()
+	defer ua.mutex.Unlock()
 	ua.ButtonStatus = f.Value
+	ua.unsent = true
+	ua.startOverride(time.Now())
+	ua.wakeUp()
+}
+
+// getSunRegime is used for reading if it's currently a normal day, midnight sun or polar night
+func (ua *UnitAsset) getSunRegime() (f forms.SignalA_v1a) {
+	ua.mutex.Lock()
+	defer ua.mutex.Unlock()
+	f.NewForm()
+	f.Value = float64(calculateSunTimes(time.Now().In(ua.location()), ua.Latitude, ua.Longitude).Regime)
+	f.Unit = "Regime"
+	f.Timestamp = time.Now()
+	return f
 }
 
 // feedbackLoop is THE control loop (IPR of the system)
 func (ua *UnitAsset) feedbackLoop(ctx context.Context) {
-	// Initialize a ticker for periodic execution
-	ticker := time.NewTicker(ua.Period * time.Second)
-	defer ticker.Stop()
+	// Instead of polling, the loop sleeps until the next planned transition (or until the settings changes)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
 
 	// Start the control loop
 	for {
 		select {
-		case <-ticker.C:
-			ua.processFeedbackLoop()
+		case <-timer.C:
+		case <-ua.replan:
 		case <-ctx.Done():
 			return
 		}
+		ua.processFeedbackLoop()
+		ua.mutex.Lock()
+		d := ua.sleepDuration(time.Now())
+		ua.mutex.Unlock()
+		timer.Reset(d)
 	}
 }
 
-// This function sends a new button status to the ZigBee system if needed
-func (ua *UnitAsset) processFeedbackLoop() {
-	date := time.Now().Format("2006-01-02") // Gets the current date in the defined format.
-	apiURL := fmt.Sprintf(`http://api.sunrisesunset.io/json?lat=%06f&lng=%06f&timezone=CET&date=%d-%02d-%02d&time_format=24`, ua.Latitude, ua.Longitude, time.Now().Local().Year(), int(time.Now().Local().Month()), time.Now().Local().Day())
+// The longest time the control loop sleeps, so it can't miss any adjustments of the system clock
+const maxSleep time.Duration = time.Hour
 
-	if !((ua.data.Results.Date == date) && ((ua.oldLatitude == ua.Latitude) && (ua.oldLongitude == ua.Longitude))) { // If there is a new day or latitude or longitude is changed new data is downloaded.
-		log.Printf("Sun API has not been called today for this region, downloading sun data...")
-		err := ua.getAPIData(apiURL)
-		if err != nil {
-			log.Printf("Cannot get sun API data: %s\n", err)
-			return
+// sleepDuration returns how long the control loop should sleep, until the next planned transition
+func (ua *UnitAsset) sleepDuration(now time.Time) time.Duration {
+	if ua.connError != 0 {
+		// Try sending the button status again soon
+		if ua.Period > 0 {
+			return ua.Period * time.Second
 		}
+		return time.Minute
+	}
+	if ua.overrideActive(now) {
+		// The transitions are ignored until the manual override ends
+		if d := ua.overrideUntil.Sub(now); d < maxSleep {
+			return d
+		}
+		return maxSleep
+	}
+	d := maxSleep
+	if at, _, ok := ua.nextTransition(now.In(ua.location())); ok && at.Sub(now) < maxSleep {
+		d = at.Sub(now)
+	}
+	if d < 0 {
+		d = 0
+	}
+	return ua.luxSleep(now.In(ua.location()), d)
+}
+
+// wakeUp makes the control loop plan the next transition again, after a setting has changed
+func (ua *UnitAsset) wakeUp() {
+	select {
+	case ua.replan <- struct{}{}:
+	default: // The loop has already been woken up (or isn't running)
+	}
+}
+
+// This function sends a new button status to the ZigBee system if needed. The mutex is unlocked while waiting
+// for the sun API, the light sensor and the targets, so a slow system can't block the http handlers.
+func (ua *UnitAsset) processFeedbackLoop() {
+	ua.mutex.Lock()
+	now := time.Now().In(ua.location()) // All sun times and rules uses the time zone of the unit asset.
+	date := now.Format("2006-01-02")    // Gets the current date in the defined format.
+	crossCheck := false
+	if !((ua.sunTimes.Date.Format("2006-01-02") == date) && ((ua.oldLatitude == ua.Latitude) && (ua.oldLongitude == ua.Longitude))) { // If there is a new day or latitude or longitude is changed the sun times are calculated again.
+		ua.sunTimes = calculateSunTimes(now, ua.Latitude, ua.Longitude)
+		crossCheck = ua.CrossCheck
 	}
 	ua.oldLongitude = ua.Longitude
 	ua.oldLatitude = ua.Latitude
-	layout := "15:04:05"
-	sunrise, _ := time.Parse(layout, ua.data.Results.Sunrise)                   // Saves the sunrise in the layout format.
-	sunset, _ := time.Parse(layout, ua.data.Results.Sunset)                     // Saves the sunset in the layout format.
-	currentTime, _ := time.Parse(layout, time.Now().Local().Format("15:04:05")) // Saves the current time in the layout format.
-	if currentTime.After(sunrise) && !(currentTime.After(sunset)) {             // This checks if the time is between sunrise or sunset, if it is the switch is supposed to turn off.
-		if ua.ButtonStatus == 0 && ua.connError == 0 { // If the button is already off there is no need to send a state again.
-			log.Printf("The button is already off")
-			return
-		}
-		ua.ButtonStatus = 0
-		err := ua.sendStatus()
-		if err != nil {
-			ua.connError = 1
-			return
-		} else {
-			ua.connError = 0
-		}
+	sunTimes, latitude, longitude := ua.sunTimes, ua.Latitude, ua.Longitude
+	readLux := ua.luxEnabled() && !ua.Vacation
+	ua.mutex.Unlock()
 
-	} else { // If the time is not between sunrise and sunset the button is supposed to be on.
-		if ua.ButtonStatus == 1 && ua.connError == 0 { // If the button is already on there is no need to send a state again.
-			log.Printf("The button is already on")
-			return
+	if crossCheck {
+		if err := ua.crossCheckSunTimes(sunTimes, latitude, longitude); err != nil {
+			log.Printf("Cross-check of the sun times failed: %s\n", err)
 		}
-		ua.ButtonStatus = 1
-		err := ua.sendStatus()
-		if err != nil {
-			ua.connError = 1
-			return
-		} else {
-			ua.connError = 0
+	}
+	if readLux {
+		ua.readLightLevel()
+	}
+	send, retry := ua.nextButtonStatus(now, readLux)
+	if !send {
+		return
+	}
+	err := ua.sendStatus(retry)
+
+	ua.mutex.Lock()
+	defer ua.mutex.Unlock()
+	if err != nil {
+		log.Printf("Cannot update all targets: %s\n", err)
+		ua.connError = 1
+		return
+	}
+	ua.connError = 0
+}
+
+// nextButtonStatus sets the button status decided by the rules, the light level or a manual override. It returns
+// if the status should be sent to the targets, and if it's only a retry of the targets that failed last time.
+func (ua *UnitAsset) nextButtonStatus(now time.Time, useLux bool) (send, retry bool) {
+	ua.mutex.Lock()
+	defer ua.mutex.Unlock()
+	state, ok := ua.plannedState(now) // Finds the button status set by the latest triggered rule, the polar policy or the vacation mode.
+	if useLux {
+		if luxState, fresh := ua.lightLevelState(now); fresh {
+			state, ok = luxState, true // The light sensor decides during the day
 		}
 	}
+	if ua.overrideActive(now) {
+		state, ok = ua.ButtonStatus, true // A manual override holds the button status
+	} else if !ua.overrideUntil.IsZero() {
+		log.Printf("The manual override of %s has ended\n", ua.Name)
+		ua.overrideUntil = time.Time{}
+	}
+	if !ok {
+		log.Printf("No rule has been triggered yet")
+		return false, false
+	}
+	if ua.ButtonStatus == state && ua.connError == 0 && !ua.unsent { // If the button already has this status there is no need to send a state again.
+		log.Printf("The button is already %s", buttonStatusName(state))
+		return false, false
+	}
+	retry = ua.ButtonStatus == state && !ua.unsent // Only the failed targets needs the status again
+	ua.ButtonStatus = state
+	ua.unsent = false
+	return true, retry
 }
 
-func (ua *UnitAsset) sendStatus() error {
-	// Prepare the form to send
-	var of forms.SignalA_v1a
-	of.NewForm()
-	of.Value = ua.ButtonStatus
-	of.Unit = ua.CervicesMap["state"].Details["Unit"][0]
-	of.Timestamp = time.Now()
-	// Pack the new state form
-	// Pack() converting the data in "of" into JSON format
-	op, err := usecases.Pack(&of, "application/json")
-	if err != nil {
-		return err
+// buttonStatusName returns a readable name for a button status, used for logging
+func buttonStatusName(state float64) string {
+	if state == 0 {
+		return "off"
 	}
-	// Send the new request
-	err = usecases.SetState(ua.CervicesMap["state"], ua.Owner, op)
+	return "on"
+}
+
+var errStatuscode error = fmt.Errorf("bad status code")
+var errSunMismatch error = fmt.Errorf("sun times differ from the sun API")
+
+// The largest difference allowed between the calculated sun times and the ones from the sun API
+const crossCheckLimit time.Duration = 5 * time.Minute
+
+// How long to wait for the sun API, so a cross-check can't hold up the control loop
+const sunAPITimeout time.Duration = 10 * time.Second
+
+// crossCheckSunTimes downloads the sun times for the same day from the sun API and compares them with the
+// locally calculated sunrise and sunset, returning errSunMismatch if any of them differs too much
+func (ua *UnitAsset) crossCheckSunTimes(st SunTimes, latitude, longitude float64) error {
+	d := st.Date
+	loc := d.Location()
+	if loc == time.Local {
+		loc = time.UTC // The sun API needs a named time zone
+	}
+	apiURL := fmt.Sprintf(`http://api.sunrisesunset.io/json?lat=%06f&lng=%06f&timezone=%s&date=%d-%02d-%02d&time_format=24`, latitude, longitude, url.QueryEscape(loc.String()), d.Year(), int(d.Month()), d.Day())
+	err := ua.getAPIData(apiURL)
 	if err != nil {
-		log.Printf("Cannot update ZigBee state: %s\n", err)
 		return err
 	}
+	mismatch := false
+	compare := func(event string, calculated time.Time, fetched string) {
+		t, err := time.Parse("15:04:05", fetched)
+		if err != nil {
+			log.Printf("Cannot parse %s from the sun API: %s\n", event, err)
+			return
+		}
+		t = time.Date(d.Year(), d.Month(), d.Day(), t.Hour(), t.Minute(), t.Second(), 0, loc)
+		diff := t.Sub(calculated)
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff > crossCheckLimit {
+			log.Printf("The calculated %s (%s) differs from the sun API (%s) by %s\n", event, calculated.Format("15:04:05"), fetched, diff)
+			mismatch = true
+		}
+	}
+	compare("sunrise", st.Sunrise, ua.data.Results.Sunrise)
+	compare("sunset", st.Sunset, ua.data.Results.Sunset)
+	if mismatch {
+		return errSunMismatch
+	}
 	return nil
 }
 
-var errStatuscode error = fmt.Errorf("bad status code")
-
 func (ua *UnitAsset) getAPIData(apiURL string) error {
 	//apiURL := fmt.Sprintf(`http://api.sunrisesunset.io/json?lat=%06f&lng=%06f&timezone=CET&date=%d-%02d-%02d&time_format=24`, ua.Latitude, ua.Longitude, time.Now().Local().Year(), int(time.Now().Local().Month()), time.Now().Local().Day())
 	parsedURL, err := url.Parse(apiURL)
@@ -312,7 +665,8 @@ func (ua *UnitAsset) getAPIData(apiURL string) error {
 		return errors.New("the url is invalid")
 	}
 	// End of validating the URL //
-	res, err := http.Get(parsedURL.String())
+	client := http.Client{Transport: http.DefaultClient.Transport, Timeout: sunAPITimeout}
+	res, err := client.Get(parsedURL.String())
 	if err != nil {
 		return err
 	}
@@ -256,6 +256,12 @@ func TestNewUnitAsset(t *testing.T) {
 	if name != "Button" {
 		t.Errorf("expected name to be Button, but got: %v", name)
 	}
+	// A bad time zone falls back to the local time zone
+	uac.Timezone = "Mars/Olympus_Mons"
+	ua, _ = newUnitAsset(uac, &sys, nil)
+	if loc := ua.(*UnitAsset).location(); loc != time.Local {
+		t.Errorf("expected the local time zone for a bad time zone, got %s", loc)
+	}
 }
 
 // Functions that help creating bad body
@@ -338,3 +344,160 @@ func TestGetAPIPriceDataSun(t *testing.T) {
 		t.Errorf("expected an bad status code but got %v", err)
 	}
 }
+
+func TestCrossCheckSunTimes(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	st := calculateSunTimes(time.Date(2024, 6, 20, 0, 0, 0, 0, time.UTC), 51.5074, -0.1278)
+	body := func(sunrise, sunset string) io.ReadCloser {
+		return io.NopCloser(strings.NewReader(fmt.Sprintf(`{"results": {"date": "2024-06-20", "sunrise": "%s", "sunset": "%s"}, "status": "OK"}`, sunrise, sunset)))
+	}
+	resp := &http.Response{
+		Status:     "200 OK",
+		StatusCode: 200,
+		Body:       body("03:43:00", "20:21:00"),
+	}
+	// Good case: the sun API agrees with the calculated times
+	newMockTransport(resp)
+	if err := ua.crossCheckSunTimes(st, 51.5074, -0.1278); err != nil {
+		t.Errorf("expected no errors but got %s", err)
+	}
+	// Bad case: the sun API differs too much
+	resp.Body = body("03:43:00", "21:21:00")
+	newMockTransport(resp)
+	if err := ua.crossCheckSunTimes(st, 51.5074, -0.1278); err != errSunMismatch {
+		t.Errorf("expected error %v, got %v", errSunMismatch, err)
+	}
+	// Bad case: the sun API returns an error
+	resp.Body = body("03:43:00", "20:21:00")
+	resp.StatusCode = 300
+	newMockTransport(resp)
+	if err := ua.crossCheckSunTimes(st, 51.5074, -0.1278); err != errStatuscode {
+		t.Errorf("expected error %v, got %v", errStatuscode, err)
+	}
+}
+
+func TestLocation(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	if loc := ua.location(); loc.String() != "Europe/Stockholm" {
+		t.Errorf("expected time zone Europe/Stockholm, got %s", loc)
+	}
+	// A bad time zone is rejected, keeping the old one
+	if err := ua.setTimezone("Mars/Olympus_Mons"); err == nil {
+		t.Errorf("expected an error for a bad time zone")
+	}
+	if loc := ua.location(); loc.String() != "Europe/Stockholm" || ua.Timezone != "Europe/Stockholm" {
+		t.Errorf("expected the time zone to stay Europe/Stockholm, got %s", loc)
+	}
+	if err := ua.setTimezone(""); err != nil || ua.location() != time.Local {
+		t.Errorf("expected the local time zone for a missing time zone, got %s (%v)", ua.location(), err)
+	}
+}
+
+func TestTimezoneDST(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	ua.Latitude, ua.Longitude = 59.3293, 18.0686
+	ua.Rules = []Rule{
+		{Event: "time", Time: "06:00", State: 1},
+		{Event: "sunrise", State: 0},
+		{Event: "time", Time: "23:30", State: 0},
+	}
+	loc := ua.location()
+	// The clocks in Sweden are moved forward an hour at 02:00 on 2024-03-31
+	before := time.Date(2024, 3, 30, 0, 0, 0, 0, loc)
+	after := time.Date(2024, 3, 31, 0, 0, 0, 0, loc)
+	for _, day := range []time.Time{before, after} {
+		at, _ := ua.Rules[0].triggerTime(day, calculateSunTimes(day, ua.Latitude, ua.Longitude))
+		if at.Hour() != 6 || at.Minute() != 0 {
+			t.Errorf("expected trigger at 06:00 local time on %s, got %s", day.Format("2006-01-02"), at)
+		}
+	}
+	// The sunrise moves an hour on the clock, but only a couple of minutes in absolute time
+	sr1 := calculateSunTimes(before, ua.Latitude, ua.Longitude).Sunrise
+	sr2 := calculateSunTimes(after, ua.Latitude, ua.Longitude).Sunrise
+	if diff := sr2.Sub(sr1); diff < 23*time.Hour || diff > 24*time.Hour {
+		t.Errorf("expected the sunrises to be less than a day apart, got %s", diff)
+	}
+	if sr1.Hour() != 5 || sr2.Hour() != 6 {
+		t.Errorf("expected sunrise at 05:xx CET and 06:xx CEST, got %s and %s", sr1, sr2)
+	}
+	// Right after midnight the latest trigger was yesterday's 23:30 rule
+	if state, _ := ua.ruleState(after.Add(time.Minute), ua.rules()); state != 0 {
+		t.Errorf("expected the button to be off after midnight, got %v", state)
+	}
+	if state, _ := ua.ruleState(time.Date(2024, 3, 31, 6, 1, 0, 0, loc), ua.rules()); state != 1 {
+		t.Errorf("expected the button to be on after 06:00, got %v", state)
+	}
+}
+
+func TestTimezoneOutsideCET(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	ua.Latitude, ua.Longitude = 40.7128, -74.0060
+	ua.setTimezone("America/New_York")
+	st := calculateSunTimes(time.Date(2024, 12, 21, 12, 0, 0, 0, ua.location()), ua.Latitude, ua.Longitude)
+	if st.Sunrise.Hour() != 7 || st.Sunset.Hour() != 16 {
+		t.Errorf("expected sunrise at 07:xx and sunset at 16:xx local time, got %s and %s", st.Sunrise, st.Sunset)
+	}
+}
+
+func TestSleepDuration(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	ua.Latitude, ua.Longitude = 59.3293, 18.0686
+	ua.setTimezone("UTC")
+	ua.Rules = exampleRules
+	st := calculateSunTimes(time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC), ua.Latitude, ua.Longitude)
+
+	// Sleeps until the next transition, but never longer than maxSleep
+	if d := ua.sleepDuration(st.SolarNoon); d != maxSleep {
+		t.Errorf("expected to sleep for %s, got %s", maxSleep, d)
+	}
+	on := st.Dusk.Add(-20 * time.Minute)
+	if d := ua.sleepDuration(on.Add(-10 * time.Minute)); d != 10*time.Minute {
+		t.Errorf("expected to sleep for 10 minutes, got %s", d)
+	}
+	// Retries soon if the status couldn't be sent
+	ua.connError = 1
+	if d := ua.sleepDuration(st.SolarNoon); d != ua.Period*time.Second {
+		t.Errorf("expected to retry after %s, got %s", ua.Period*time.Second, d)
+	}
+}
+
+func TestWakeUp(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	// Without a running loop, nothing blocks
+	ua.wakeUp()
+
+	ua.replan = make(chan struct{}, 1)
+	ua.setLatitude(forms.SignalA_v1a{Value: 60})
+	ua.setLongitude(forms.SignalA_v1a{Value: 20})
+	select {
+	case <-ua.replan:
+	default:
+		t.Errorf("expected the control loop to be woken up")
+	}
+	if err := ua.setRules([]Rule{{Event: "moonrise"}}); err == nil {
+		t.Errorf("expected an error for bad rules")
+	}
+	select {
+	case <-ua.replan:
+		t.Errorf("expected no wake up after bad rules")
+	default:
+	}
+	if err := ua.setRules(exampleRules); err != nil || len(ua.Rules) != len(exampleRules) {
+		t.Errorf("expected the rules to be replaced, got %s", err)
+	}
+	if len(ua.replan) != 1 {
+		t.Errorf("expected the control loop to be woken up by new rules")
+	}
+}
+
+func TestNextTransitionServices(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	f, err := ua.getNextTransition()
+	if err != nil || f.Unit != "Unix timestamp" || f.Value < float64(time.Now().Unix()) {
+		t.Errorf("expected a transition in the future, got %v (%v)", f.Value, err)
+	}
+	f = ua.getNextState()
+	if f.Unit != "bool" || (f.Value != 0 && f.Value != 1) {
+		t.Errorf("expected the next state to be on or off, got %v", f.Value)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"log"
+	"math/rand/v2"
+	"time"
+
+	"github.com/sdoque/mbaigo/forms"
+)
+
+// The vacation mode simulates that someone is home, by turning the targets on and off between dusk and
+// bedtime. The pattern is random but the same for a given seed and day, so it can be reproduced.
+
+// The default bedtime, used if Bedtime is missing or invalid
+const defaultBedtime string = "23:00"
+
+// Limits (in minutes) for the random pattern
+const (
+	vacationStartDelay = 30  // The lights turns on up to this long after dusk
+	vacationMinOn      = 30  // Shortest time the lights are on in a row
+	vacationMaxOn      = 120 // Longest time the lights are on in a row
+	vacationMinOff     = 5   // Shortest break (ie. someone left the room)
+	vacationMaxOff     = 30  // Longest break
+	vacationBedJitter  = 20  // The lights turns off up to this long before or after bedtime
+)
+
+// bedtime returns the bedtime for the evening of the given day, which can be after midnight
+func (ua *UnitAsset) bedtime(day time.Time, dusk time.Time) time.Time {
+	clock, err := time.Parse("15:04", ua.Bedtime)
+	if err != nil {
+		if ua.Bedtime != "" {
+			log.Printf("Bad bedtime %q, using %s instead: %s\n", ua.Bedtime, defaultBedtime, err)
+		}
+		clock, _ = time.Parse("15:04", defaultBedtime)
+	}
+	y, m, d := day.Date()
+	bed := time.Date(y, m, d, clock.Hour(), clock.Minute(), 0, 0, day.Location())
+	if !bed.After(dusk) {
+		bed = bed.AddDate(0, 0, 1) // Going to bed after midnight
+	}
+	return bed
+}
+
+// vacationTriggers returns the random on/off pattern for the evening of the given day
+func (ua *UnitAsset) vacationTriggers(day time.Time) (triggers []trigger) {
+	dusk := calculateSunTimes(day, ua.Latitude, ua.Longitude).Dusk
+	if dusk.IsZero() {
+		return // No dusk during the midnight sun
+	}
+	bed := ua.bedtime(day, dusk)
+	y, m, d := day.Date()
+	// A new pattern for each day, that is the same every time for the same seed
+	rng := rand.New(rand.NewPCG(uint64(ua.VacationSeed), uint64(y*10000+int(m)*100+d))) // #nosec G404 -- no need for secure numbers here
+	minutes := func(low, high int) time.Duration {
+		return time.Duration(low+rng.IntN(high-low+1)) * time.Minute
+	}
+
+	end := bed.Add(minutes(-vacationBedJitter, vacationBedJitter))
+	at := dusk.Add(minutes(0, vacationStartDelay))
+	for at.Before(end) {
+		triggers = append(triggers, trigger{At: at, State: 1})
+		at = at.Add(minutes(vacationMinOn, vacationMaxOn))
+		if !at.Before(end) {
+			break
+		}
+		triggers = append(triggers, trigger{At: at, State: 0})
+		at = at.Add(minutes(vacationMinOff, vacationMaxOff))
+	}
+	if len(triggers) > 0 && triggers[len(triggers)-1].State != 0 {
+		triggers = append(triggers, trigger{At: end, State: 0})
+	}
+	return
+}
+
+// vacationTriggersBetween returns the vacation triggers for all evenings between the two days (inclusive)
+func (ua *UnitAsset) vacationTriggersBetween(from, to time.Time) (triggers []trigger) {
+	y, m, d := from.Date()
+	for day := time.Date(y, m, d, 0, 0, 0, 0, from.Location()); !day.After(to); day = day.AddDate(0, 0, 1) {
+		triggers = append(triggers, ua.vacationTriggers(day)...)
+	}
+	return
+}
+
+// vacationState returns the button status from the vacation pattern at the given time, the lights are
+// off outside of the evenings
+func (ua *UnitAsset) vacationState(now time.Time) float64 {
+	state := 0.0
+	for _, t := range ua.vacationTriggersBetween(now.AddDate(0, 0, -1), now) {
+		if t.At.After(now) {
+			break
+		}
+		state = t.State
+	}
+	return state
+}
+
+// getVacation is used for reading if the vacation mode is on
+func (ua *UnitAsset) getVacation() (f forms.SignalA_v1a) {
+	ua.mutex.Lock()
+	defer ua.mutex.Unlock()
+	f.NewForm()
+	if ua.Vacation {
+		f.Value = 1
+	}
+	f.Unit = "bool"
+	f.Timestamp = time.Now()
+	return f
+}
+
+// setVacation is used for turning the vacation mode on (1) or off (0)
+func (ua *UnitAsset) setVacation(f forms.SignalA_v1a) {
+	ua.mutex.Lock()
+	defer ua.mutex.Unlock()
+	ua.Vacation = f.Value != 0
+	ua.wakeUp()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sdoque/mbaigo/forms"
+)
+
+func TestVacationTriggers(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	ua.Latitude, ua.Longitude = 59.3293, 18.0686
+	ua.Bedtime = "23:00"
+	ua.VacationSeed = 42
+	day := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	dusk := calculateSunTimes(day, ua.Latitude, ua.Longitude).Dusk
+	bed := time.Date(2024, 1, 10, 23, 0, 0, 0, time.UTC)
+
+	triggers := ua.vacationTriggers(day)
+	if len(triggers) < 2 {
+		t.Fatalf("expected a pattern with several triggers, got %v", triggers)
+	}
+	for i, tr := range triggers {
+		if tr.At.Before(dusk) || tr.At.After(bed.Add(vacationBedJitter*time.Minute)) {
+			t.Errorf("expected trigger %d to be between dusk and bedtime, got %s", i, tr.At)
+		}
+		if i > 0 && (!tr.At.After(triggers[i-1].At) || tr.State == triggers[i-1].State) {
+			t.Errorf("expected trigger %d to toggle the state after the previous one", i)
+		}
+	}
+	if triggers[0].State != 1 || triggers[len(triggers)-1].State != 0 {
+		t.Errorf("expected the pattern to start on and end off, got %v", triggers)
+	}
+	// Same seed and day gives the same pattern, while other seeds or days don't
+	if !reflect.DeepEqual(triggers, ua.vacationTriggers(day)) {
+		t.Errorf("expected the same pattern for the same seed")
+	}
+	ua.VacationSeed = 43
+	if reflect.DeepEqual(triggers, ua.vacationTriggers(day)) {
+		t.Errorf("expected another pattern for another seed")
+	}
+}
+
+func TestVacationBedtime(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	day := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	dusk := time.Date(2024, 1, 10, 15, 30, 0, 0, time.UTC)
+
+	ua.Bedtime = "00:30"
+	if bed := ua.bedtime(day, dusk); !bed.Equal(time.Date(2024, 1, 11, 0, 30, 0, 0, time.UTC)) {
+		t.Errorf("expected the bedtime to be after midnight, got %s", bed)
+	}
+	ua.Bedtime = "bad"
+	if bed := ua.bedtime(day, dusk); bed.Hour() != 23 {
+		t.Errorf("expected the default bedtime, got %s", bed)
+	}
+}
+
+func TestVacationState(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	ua.Latitude, ua.Longitude = 59.3293, 18.0686
+	ua.Vacation = true
+	day := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	triggers := ua.vacationTriggers(day)
+
+	for _, tr := range triggers {
+		if state, _ := ua.plannedState(tr.At); state != tr.State {
+			t.Errorf("expected state %v at %s, got %v", tr.State, tr.At, state)
+		}
+	}
+	if state, _ := ua.plannedState(day.Add(12 * time.Hour)); state != 0 {
+		t.Errorf("expected the lights to be off during the day, got %v", state)
+	}
+	// The next transition follows the pattern
+	at, state, ok := ua.nextTransition(day.Add(12 * time.Hour))
+	if !ok || !at.Equal(triggers[0].At) || state != 1 {
+		t.Errorf("expected the lights to turn on at %s, got %v at %s", triggers[0].At, state, at)
+	}
+}
+
+func TestVacationServices(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	if f := ua.getVacation(); f.Value != 0 {
+		t.Errorf("expected the vacation mode to be off, got %v", f.Value)
+	}
+	ua.setVacation(forms.SignalA_v1a{Value: 1})
+	if f := ua.getVacation(); !ua.Vacation || f.Value != 1 {
+		t.Errorf("expected the vacation mode to be on, got %v", f.Value)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/sdoque/mbaigo/components"
+	"github.com/sdoque/mbaigo/forms"
 	"github.com/sdoque/mbaigo/usecases"
 )
 
@@ -37,33 +38,63 @@ func main() {
 	assetName := assetTemplate.GetName()
 	sys.UAssets[assetName] = &assetTemplate
 
-	// Find zigbee gateway and store it in a global variable for reuse
-	err := findGateway()
-	if err != nil {
-		log.Fatal("Error getting gateway, shutting down: ", err)
-	}
-
 	// Configure the system
 	rawResources, servsTemp, err := usecases.Configure(&sys)
 	if err != nil {
 		log.Fatalf("Configuration error: %v\n", err)
 	}
 	sys.UAssets = make(map[string]*components.UnitAsset) // clear the unit asset map (from the template)
+	var uacs []UnitAsset
 	for _, raw := range rawResources {
 		var uac UnitAsset
 		if err := json.Unmarshal(raw, &uac); err != nil {
 			log.Fatalf("Resource configuration error: %+v\n", err)
 		}
+		uacs = append(uacs, uac)
+	}
+	if len(uacs) > 0 {
+		uacs[0].systemAsset = true // Serves the services for the whole system
+	}
+
+	// Find zigbee gateway and store it in a global variable for reuse
+	if !waitForGateway(&sys, uacs) {
+		fmt.Println("\nshuting down system", sys.Name)
+		return
+	}
+
+	var startups []func() error
+	for _, uac := range uacs {
 		ua, startup := newResource(uac, &sys, servsTemp)
-		if err := startup(); err != nil {
-			log.Fatalf("Error during startup: %s\n", err)
-		}
+		startups = append(startups, startup)
 		sys.UAssets[ua.GetName()] = &ua
 	}
 
 	// Generate PKI keys and CSR to obtain a authentication certificate from the CA
 	usecases.RequestCertificate(&sys)
 
+	// Get an API key from the gateway, which might need to be unlocked in Phoscon first
+	if !waitForAPIKey(&sys, uacs) {
+		fmt.Println("\nshuting down system", sys.Name)
+		return
+	}
+
+	for _, startup := range startups {
+		if err := startup(); err != nil {
+			log.Fatalf("Error during startup: %s\n", err)
+		}
+	}
+
+	// Create unit assets for the devices that aren't in the configuration, before the server is started
+	if inventoryEnabled(uacs) {
+		inv := newInventory(&sys, servsTemp, uacs)
+		if err := inv.scan(); err != nil {
+			log.Printf("Error getting the device inventory: %s\n", err)
+		}
+	}
+
+	// Keep the state of the devices in memory, so reads doesn't have to ask the gateway each time
+	deviceCache.start(ctx)
+
 	// Register the (system) and its services
 	usecases.RegisterServices(&sys)
 
@@ -92,20 +123,52 @@ func (t *UnitAsset) Serving(w http.ResponseWriter, r *http.Request, servicePath
 		t.voltage(w, r)
 	case "state":
 		t.state(w, r)
+	case "gateway":
+		t.status(w, r)
+	case "health":
+		t.health(w, r)
+	case "reachable", "lastseen", "stale":
+		t.healthSignal(w, r, servicePath)
+	case "summary":
+		t.healthSummary(w, r)
+	case "temperature", "humidity", "pressure", "lightlevel", "presence", "open", "battery",
+		"heatsetpoint", "valve", "windowopen":
+		t.sensorSignal(w, r, servicePath)
+	case "thermostat":
+		t.thermostat(w, r)
+	case "controller":
+		t.controllerState(w, r)
+	case "scene":
+		t.scene(w, r)
+	case "scenes":
+		t.scenes(w, r)
+	case "brightness":
+		t.lightSignal(w, r, t.supports(servicePath), t.getBrightness, t.setBrightness)
+	case "colortemp":
+		t.lightSignal(w, r, t.supports(servicePath), t.getColorTemp, t.setColorTemp)
+	case "hue":
+		t.lightSignal(w, r, t.supports(servicePath), t.getHue, t.setHue)
+	case "saturation":
+		t.lightSignal(w, r, t.supports(servicePath), t.getSaturation, t.setSaturation)
+	case "xy":
+		t.xy(w, r)
+	case "transition":
+		t.lightSignal(w, r, t.supports(servicePath), func() (forms.SignalA_v1a, error) {
+			return t.getTransition(), nil
+		}, t.setTransition)
 	default:
 		http.Error(w, "Invalid service request [Do not modify the services subpath in the configuration file]", http.StatusBadRequest)
 	}
 }
 
 // TODO: Add webhandler for power plug controller (sun up/down) and/or schedule later on.
-// STRETCH GOAL: Instead of looking for specific models types, add a list of supported devices that we can check against
 
 // Function used by webhandler to either get or set the setpoint of a specific device
 func (rsc *UnitAsset) setpt(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
 		// Make sure only devices with setpoints actually support the http get method
-		if rsc.Model == "ZHAThermostat" || rsc.Model == "Smart plug" {
+		if rsc.supports("setpoint") {
 			setPointForm := rsc.getSetPoint()
 			usecases.HTTPProcessGetRequest(w, r, &setPointForm)
 			return
@@ -114,13 +177,21 @@ func (rsc *UnitAsset) setpt(w http.ResponseWriter, r *http.Request) {
 		return
 	case "PUT":
 		// Make sure only devices with setpoints actually support the http put method
-		if rsc.Model == "ZHAThermostat" || rsc.Model == "Smart plug" {
+		if rsc.supports("setpoint") {
 			sig, err := usecases.HTTPProcessSetRequest(w, r)
 			if err != nil {
 				http.Error(w, "Request incorrectly formatted", http.StatusBadRequest)
 				return
 			}
-			rsc.setSetPoint(sig)
+			if !rsc.supports("thermostat") {
+				rsc.setSetPoint(sig) // Used by the feedback loop
+				return
+			}
+			// The thermostat gets the new setpoint right away, or later if it can't be reached now
+			if err = rsc.applySetPoint(sig); err != nil {
+				log.Printf("Error sending the setpoint to %s: %s\n", rsc.Name, err)
+				http.Error(w, "Something went wrong when sending the setpoint", http.StatusInternalServerError)
+			}
 			return
 		}
 		http.Error(w, "This device doesn't support that method.", http.StatusInternalServerError)
@@ -135,7 +206,7 @@ func (rsc *UnitAsset) consumption(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
 		// Make sure only devices with consumption sensors actually support the http get method
-		if rsc.Model != "Smart plug" {
+		if !rsc.supports("consumption") {
 			http.Error(w, "That device doesn't support that method.", http.StatusInternalServerError)
 			return
 		}
@@ -155,7 +226,7 @@ func (rsc *UnitAsset) power(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
 		// Make sure only devices with power sensors actually support the http get method
-		if rsc.Model != "Smart plug" {
+		if !rsc.supports("power") {
 			http.Error(w, "That device doesn't support that method.", http.StatusInternalServerError)
 			return
 		}
@@ -175,7 +246,7 @@ func (rsc *UnitAsset) current(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
 		// Make sure only devices with current sensors actually support the http get method
-		if rsc.Model != "Smart plug" {
+		if !rsc.supports("current") {
 			http.Error(w, "That device doesn't support that method.", http.StatusInternalServerError)
 			return
 		}
@@ -195,7 +266,7 @@ func (rsc *UnitAsset) voltage(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
 		// Make sure only devices with voltage sensors actually support the http get method
-		if rsc.Model != "Smart plug" {
+		if !rsc.supports("voltage") {
 			http.Error(w, "That device doesn't support that method.", http.StatusInternalServerError)
 			return
 		}
@@ -213,7 +284,7 @@ func (rsc *UnitAsset) voltage(w http.ResponseWriter, r *http.Request) {
 func (rsc *UnitAsset) state(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
-		if rsc.Model != "Smart plug" {
+		if !rsc.supports("state") {
 			http.Error(w, "That device doesn't support that method.", http.StatusInternalServerError)
 			return
 		}
@@ -224,7 +295,7 @@ func (rsc *UnitAsset) state(w http.ResponseWriter, r *http.Request) {
 		}
 		usecases.HTTPProcessGetRequest(w, r, &stateForm)
 	case "PUT":
-		if rsc.Model != "Smart plug" {
+		if !rsc.supports("state") {
 			http.Error(w, "That device doesn't support that method.", http.StatusInternalServerError)
 			return
 		}
@@ -242,3 +313,52 @@ func (rsc *UnitAsset) state(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method is not supported", http.StatusNotFound)
 	}
 }
+
+// Function used by the webhandler to get the status of the connection to the gateway
+func (rsc *UnitAsset) status(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(getGatewayStatus()); err != nil {
+			log.Printf("Error encoding the gateway status: %s\n", err)
+		}
+	default:
+		http.Error(w, "Method is not supported", http.StatusNotFound)
+	}
+}
+
+// Function used by the webhandler to get or set the color of a light in the CIE xy color space
+func (rsc *UnitAsset) xy(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		if !rsc.supports("xy") {
+			http.Error(w, "That device doesn't support that method.", http.StatusInternalServerError)
+			return
+		}
+		c, err := rsc.getXY()
+		if err != nil {
+			http.Error(w, "Failed getting data, or data not present", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(c); err != nil {
+			log.Printf("Error encoding the color: %s\n", err)
+		}
+	case "PUT":
+		if !rsc.supports("xy") {
+			http.Error(w, "That device doesn't support that method.", http.StatusInternalServerError)
+			return
+		}
+		var c ColorXY
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			http.Error(w, "Request incorrectly formatted", http.StatusBadRequest)
+			return
+		}
+		if err := rsc.setXY(c); err != nil {
+			http.Error(w, "Something went wrong when setting the light", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method is not supported", http.StatusNotFound)
+	}
+}
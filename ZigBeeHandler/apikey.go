@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sdoque/mbaigo/components"
+)
+
+// The API key is shared by all unit assets. If none is configured (or the gateway rejects it), the system asks
+// the gateway for a new one and keeps asking until someone clicks "Authenticate app" in Phoscon, which unlocks
+// the gateway for 60 seconds. The new key is saved so the gateway only has to be unlocked once.
+
+// The API key used for all calls to the gateway
+var apikey string
+
+// The file where a new API key is saved, next to systemconfig.json
+var apikeyFile = "apikey.txt"
+
+// How often the gateway is asked for a new API key, it has to be less than the 60 seconds the gateway is unlocked
+const apikeyRetry time.Duration = 5 * time.Second
+
+// The devicetype sent to the gateway can't be longer than this
+const maxDeviceType int = 40
+
+// The statuses shown by the gateway service
+const (
+	statusChecking string = "checking api key"
+	statusWaiting  string = "waiting for gateway unlock"
+	statusReady    string = "authorized"
+)
+
+var errUnauthorized error = fmt.Errorf("api key rejected by the gateway")
+var errGatewayLocked error = fmt.Errorf("gateway is locked")
+
+// GatewayStatus shows if the system has a working API key, or is still waiting for one
+type GatewayStatus struct {
+	Gateway string    `json:"gateway"`
+	Status  string    `json:"status"`
+	Message string    `json:"message"`
+	Error   string    `json:"error"`
+	Since   time.Time `json:"since"`
+	Events  bool      `json:"events"` // If the websocket with events from the gateway is connected
+}
+
+// The current status, it's written while waiting for an API key and read by the http handlers at the same time
+var gatewayStatus GatewayStatus
+var gatewayStatusMutex sync.Mutex
+
+// setGatewayStatus updates the current status, the time is only changed when the status changes
+func setGatewayStatus(status, message string, err error) {
+	gatewayStatusMutex.Lock()
+	defer gatewayStatusMutex.Unlock()
+	if gatewayStatus.Status != status {
+		gatewayStatus.Since = time.Now()
+	}
+	gatewayStatus.Gateway = gateway
+	gatewayStatus.Status = status
+	gatewayStatus.Message = message
+	gatewayStatus.Error = ""
+	if err != nil {
+		gatewayStatus.Error = err.Error()
+	}
+}
+
+// getGatewayStatus returns a copy of the current status
+func getGatewayStatus() GatewayStatus {
+	gatewayStatusMutex.Lock()
+	defer gatewayStatusMutex.Unlock()
+	status := gatewayStatus
+	status.Events = events.isConnected()
+	return status
+}
+
+// knownAPIKeys returns the API key set by the first unit asset that has one, followed by the saved key
+func knownAPIKeys(uacs []UnitAsset) (keys []string) {
+	for _, uac := range uacs {
+		if uac.Apikey != "" {
+			keys = append(keys, uac.Apikey)
+			break
+		}
+	}
+	if saved := loadAPIKey(); saved != "" && (len(keys) < 1 || keys[0] != saved) {
+		keys = append(keys, saved)
+	}
+	return
+}
+
+// loadAPIKey returns the saved API key, or an empty string if there's none
+func loadAPIKey() string {
+	data, err := os.ReadFile(apikeyFile) // #nosec G304 -- the path is set by the system, not the users
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Error reading the saved API key: %s\n", err)
+		}
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// saveAPIKey saves a new API key, so it can be used again after a restart
+func saveAPIKey(key string) error {
+	return os.WriteFile(apikeyFile, []byte(key+"\n"), 0600)
+}
+
+// deviceType returns the name the system uses when asking for an API key, which is shown in Phoscon
+func deviceType(name string) string {
+	dt := name
+	if host, err := os.Hostname(); err == nil && host != "" {
+		dt += "#" + host
+	}
+	if len(dt) > maxDeviceType {
+		dt = dt[:maxDeviceType]
+	}
+	return dt
+}
+
+// checkAPIKey makes sure the gateway accepts the API key
+func checkAPIKey(key string) error {
+	req, err := createGetRequest("http://" + gateway + "/api/" + key + "/lights")
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err = io.ReadAll(resp.Body); err != nil {
+		return err
+	}
+	switch {
+	case resp.StatusCode == http.StatusForbidden:
+		return errUnauthorized
+	case resp.StatusCode > 299:
+		return errStatusCode
+	}
+	return nil
+}
+
+// The gateway answers a registration with a list of either successes or errors
+type registerJSON []struct {
+	Success struct {
+		Username string `json:"username"`
+	} `json:"success"`
+	Error struct {
+		Type        int    `json:"type"`
+		Description string `json:"description"`
+	} `json:"error"`
+}
+
+// The error type used by the gateway when it hasn't been unlocked ("link button not pressed")
+const errTypeLinkButton int = 101
+
+// registerAPIKey asks the gateway for a new API key, which only works while the gateway is unlocked
+func registerAPIKey(devicetype string) (string, error) {
+	payload, err := json.Marshal(map[string]string{"devicetype": devicetype})
+	if err != nil {
+		return "", err
+	}
+	req, err := createPostRequest(string(payload), "http://"+gateway+"/api")
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	// The gateway answers with a list even when it's locked (and a 403 status code)
+	var answers registerJSON
+	if err = json.Unmarshal(body, &answers); err != nil {
+		if resp.StatusCode > 299 {
+			return "", errStatusCode
+		}
+		return "", err
+	}
+	for _, a := range answers {
+		switch {
+		case a.Success.Username != "":
+			return a.Success.Username, nil
+		case a.Error.Type == errTypeLinkButton:
+			return "", errGatewayLocked
+		case a.Error.Description != "":
+			return "", fmt.Errorf("register api key: %s", a.Error.Description)
+		}
+	}
+	return "", errStatusCode
+}
+
+// nextAPIKey tries the first of the known keys, or asks the gateway for a new key when there are no known keys
+// left. It returns the keys that are still worth trying, since a rejected key is dropped.
+func nextAPIKey(known []string, devicetype string) (string, []string, error) {
+	if len(known) > 0 {
+		setGatewayStatus(statusChecking, "Checking the API key with the gateway", nil)
+		err := checkAPIKey(known[0])
+		if errors.Is(err, errUnauthorized) {
+			log.Printf("The gateway rejected the API key\n")
+			return "", known[1:], err
+		}
+		if err != nil {
+			setGatewayStatus(statusChecking, "Checking the API key with the gateway", err)
+			return "", known, err
+		}
+		return known[0], known, nil
+	}
+	key, err := registerAPIKey(devicetype)
+	if err != nil {
+		setGatewayStatus(statusWaiting, "Click \"Authenticate app\" in Phoscon (Menu > Gateway > Advanced) to unlock the gateway", err)
+		return "", known, err
+	}
+	if err = saveAPIKey(key); err != nil {
+		log.Printf("Error saving the new API key, it has to be added to systemconfig.json by hand: %s\n", err)
+	}
+	return key, known, nil
+}
+
+// waitForAPIKey keeps trying to get a working API key for the gateway and saves it for all unit assets.
+// It returns false if the system was asked to shut down before it got one.
+func waitForAPIKey(sys *components.System, uacs []UnitAsset) bool {
+	known := knownAPIKeys(uacs)
+	devicetype := deviceType(sys.Name)
+	for {
+		key, rest, err := nextAPIKey(known, devicetype)
+		known = rest
+		switch {
+		case err == nil:
+			apikey = key
+			setGatewayStatus(statusReady, "", nil)
+			log.Printf("Got an API key from the gateway\n")
+			return true
+		case errors.Is(err, errUnauthorized):
+			continue // Try the next key right away
+		case errors.Is(err, errGatewayLocked):
+			log.Printf("Waiting for the gateway to be unlocked in Phoscon, to get an API key\n")
+		default:
+			log.Printf("Error getting an API key, trying again in %s: %s\n", apikeyRetry, err)
+		}
+		select {
+		case <-sys.Sigs:
+			return false
+		case <-time.After(apikeyRetry):
+		}
+	}
+}
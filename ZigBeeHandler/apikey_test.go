@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sdoque/mbaigo/components"
+)
+
+// gatewayTransport acts like a deCONZ gateway that accepts some API keys and can be unlocked for new ones
+type gatewayTransport struct {
+	keys     map[string]bool
+	unlocked bool
+	posts    int
+}
+
+func (t *gatewayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	status, body := http.StatusOK, "{}"
+	switch {
+	case req.Method == http.MethodPost && req.URL.Path == "/api":
+		t.posts++
+		if t.unlocked {
+			t.keys["NEWKEY"] = true
+			body = `[{"success": {"username": "NEWKEY"}}]`
+		} else {
+			status = http.StatusForbidden
+			body = `[{"error": {"type": 101, "address": "/", "description": "link button not pressed"}}]`
+		}
+	case strings.HasSuffix(req.URL.Path, "/lights"):
+		key := strings.Split(req.URL.Path, "/")[2]
+		if !t.keys[key] {
+			status = http.StatusForbidden
+			body = `[{"error": {"type": 1, "address": "/lights", "description": "unauthorized user"}}]`
+		}
+	}
+	return &http.Response{
+		Status:     http.StatusText(status),
+		StatusCode: status,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// useTempAPIKeyFile makes sure the tests doesn't touch a real saved key
+func useTempAPIKeyFile(t *testing.T) {
+	original := apikeyFile
+	apikeyFile = filepath.Join(t.TempDir(), "apikey.txt")
+	t.Cleanup(func() { apikeyFile = original })
+}
+
+func TestKnownAPIKeys(t *testing.T) {
+	useTempAPIKeyFile(t)
+	if keys := knownAPIKeys([]UnitAsset{{}, {}}); len(keys) != 0 {
+		t.Errorf("expected no known keys, got %v", keys)
+	}
+	// The first configured key is used
+	keys := knownAPIKeys([]UnitAsset{{}, {Apikey: "FIRST"}, {Apikey: "SECOND"}})
+	if strings.Join(keys, ",") != "FIRST" {
+		t.Errorf("expected only the first configured key, got %v", keys)
+	}
+	// Followed by the saved key
+	if err := saveAPIKey("SAVED"); err != nil {
+		t.Fatalf("expected no error saving the key, got %v", err)
+	}
+	if saved := loadAPIKey(); saved != "SAVED" {
+		t.Errorf("expected the saved key, got %q", saved)
+	}
+	keys = knownAPIKeys([]UnitAsset{{Apikey: "FIRST"}})
+	if strings.Join(keys, ",") != "FIRST,SAVED" {
+		t.Errorf("expected the configured and saved keys, got %v", keys)
+	}
+	keys = knownAPIKeys([]UnitAsset{{Apikey: "SAVED"}})
+	if strings.Join(keys, ",") != "SAVED" {
+		t.Errorf("expected the same key only once, got %v", keys)
+	}
+}
+
+func TestDeviceType(t *testing.T) {
+	if dt := deviceType("ZigBeeHandler"); !strings.HasPrefix(dt, "ZigBeeHandler") {
+		t.Errorf("expected the devicetype to start with the system name, got %q", dt)
+	}
+	if dt := deviceType(strings.Repeat("x", 50)); len(dt) != maxDeviceType {
+		t.Errorf("expected the devicetype to be cut to %d characters, got %d", maxDeviceType, len(dt))
+	}
+}
+
+func TestCheckAPIKey(t *testing.T) {
+	gateway = "localhost:8080"
+	http.DefaultClient.Transport = &gatewayTransport{keys: map[string]bool{"GOOD": true}}
+	if err := checkAPIKey("GOOD"); err != nil {
+		t.Errorf("expected the key to be accepted, got %v", err)
+	}
+	if err := checkAPIKey("BAD"); !errors.Is(err, errUnauthorized) {
+		t.Errorf("expected errUnauthorized, got %v", err)
+	}
+	newMockTransport(&http.Response{StatusCode: 500, Body: io.NopCloser(strings.NewReader(""))}, false, nil)
+	if err := checkAPIKey("GOOD"); !errors.Is(err, errStatusCode) {
+		t.Errorf("expected errStatusCode, got %v", err)
+	}
+	newMockTransport(nil, false, errHTTP)
+	if err := checkAPIKey("GOOD"); err == nil {
+		t.Errorf("expected an error when the gateway can't be reached")
+	}
+}
+
+func TestRegisterAPIKey(t *testing.T) {
+	gateway = "localhost:8080"
+	gw := &gatewayTransport{keys: map[string]bool{}}
+	http.DefaultClient.Transport = gw
+	if _, err := registerAPIKey("test"); !errors.Is(err, errGatewayLocked) {
+		t.Errorf("expected errGatewayLocked, got %v", err)
+	}
+	gw.unlocked = true
+	if key, err := registerAPIKey("test"); err != nil || key != "NEWKEY" {
+		t.Errorf("expected a new key, got %q (%v)", key, err)
+	}
+
+	// Other errors from the gateway
+	newMockTransport(&http.Response{
+		StatusCode: 400,
+		Body:       io.NopCloser(strings.NewReader(`[{"error": {"type": 7, "description": "invalid value"}}]`)),
+	}, false, nil)
+	if _, err := registerAPIKey("test"); err == nil || !strings.Contains(err.Error(), "invalid value") {
+		t.Errorf("expected the error from the gateway, got %v", err)
+	}
+	newMockTransport(&http.Response{StatusCode: 500, Body: io.NopCloser(strings.NewReader("oops"))}, false, nil)
+	if _, err := registerAPIKey("test"); !errors.Is(err, errStatusCode) {
+		t.Errorf("expected errStatusCode, got %v", err)
+	}
+	newMockTransport(&http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader("[]"))}, false, nil)
+	if _, err := registerAPIKey("test"); !errors.Is(err, errStatusCode) {
+		t.Errorf("expected errStatusCode for an empty answer, got %v", err)
+	}
+}
+
+func TestNextAPIKey(t *testing.T) {
+	useTempAPIKeyFile(t)
+	gateway = "localhost:8080"
+	gw := &gatewayTransport{keys: map[string]bool{"GOOD": true}}
+	http.DefaultClient.Transport = gw
+
+	// A rejected key is dropped
+	key, rest, err := nextAPIKey([]string{"BAD", "GOOD"}, "test")
+	if !errors.Is(err, errUnauthorized) || key != "" || strings.Join(rest, ",") != "GOOD" {
+		t.Errorf("expected the bad key to be dropped, got %q %v (%v)", key, rest, err)
+	}
+	key, _, err = nextAPIKey(rest, "test")
+	if err != nil || key != "GOOD" {
+		t.Errorf("expected the good key, got %q (%v)", key, err)
+	}
+	if gw.posts != 0 {
+		t.Errorf("expected no new key to be registered, got %d tries", gw.posts)
+	}
+
+	// Waiting for the gateway to be unlocked
+	_, _, err = nextAPIKey(nil, "test")
+	status := getGatewayStatus()
+	if !errors.Is(err, errGatewayLocked) || status.Status != statusWaiting || status.Gateway != gateway {
+		t.Errorf("expected to wait for the gateway unlock, got %+v (%v)", status, err)
+	}
+	gw.unlocked = true
+	key, _, err = nextAPIKey(nil, "test")
+	if err != nil || key != "NEWKEY" {
+		t.Errorf("expected a new key, got %q (%v)", key, err)
+	}
+	if saved := loadAPIKey(); saved != "NEWKEY" {
+		t.Errorf("expected the new key to be saved, got %q", saved)
+	}
+}
+
+func TestWaitForAPIKey(t *testing.T) {
+	useTempAPIKeyFile(t)
+	gateway = "localhost:8080"
+	gw := &gatewayTransport{keys: map[string]bool{}, unlocked: true}
+	http.DefaultClient.Transport = gw
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sys := components.NewSystem("ZigBeeHandler", ctx)
+
+	// The configured key was removed from the gateway, so a new one is registered
+	if !waitForAPIKey(&sys, []UnitAsset{{Apikey: "REMOVED"}}) {
+		t.Fatalf("expected to get an API key")
+	}
+	if apikey != "NEWKEY" || gw.posts != 1 {
+		t.Errorf("expected the new key after %d tries, got %q", gw.posts, apikey)
+	}
+	if status := getGatewayStatus(); status.Status != statusReady || status.Error != "" {
+		t.Errorf("expected the status to be authorized, got %+v", status)
+	}
+	// The saved key is used the next time
+	gw.unlocked = false
+	apikey = ""
+	if !waitForAPIKey(&sys, []UnitAsset{{Apikey: "REMOVED"}}) || apikey != "NEWKEY" || gw.posts != 1 {
+		t.Errorf("expected the saved key to be used, got %q after %d tries", apikey, gw.posts)
+	}
+	apikey = ""
+}
+
+func TestStatus(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	setGatewayStatus(statusWaiting, "unlock it", errGatewayLocked)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://localhost:8870/ZigBeeHandler/SmartThermostat1/gateway", nil)
+	ua.status(w, r)
+	resp := w.Result()
+	if resp.StatusCode != good_code {
+		t.Errorf("expected good status code: %v, got %v", good_code, resp.StatusCode)
+	}
+	var status GatewayStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatalf("expected a JSON status, got %v", err)
+	}
+	if status.Status != statusWaiting || status.Message != "unlock it" || status.Error != errGatewayLocked.Error() {
+		t.Errorf("expected the waiting status, got %+v", status)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("PUT", "http://localhost:8870/ZigBeeHandler/SmartThermostat1/gateway", nil)
+	ua.status(w, r)
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404 for PUT, got %v", w.Result().StatusCode)
+	}
+	setGatewayStatus(statusReady, "", nil)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/sdoque/mbaigo/components"
+	"github.com/sdoque/mbaigo/forms"
+	"github.com/sdoque/mbaigo/usecases"
+)
+
+// The switches send a buttonevent code for each press, in the form XYYY where X is the button (1 for the first
+// button on a remote) and YYY is the kind of press. The actions of a switch maps these codes to what should
+// happen, for example {"1002": {"action": "toggle"}, "1001": {"action": "dimup"}, "2002": {"action": "scene",
+// "group": "1", "scene": "2"}}.
+// https://dresden-elektronik.github.io/deconz-rest-doc/endpoints/sensors/button_events/
+const (
+	pressInitial      int = 0 // The button was pressed down
+	pressHold         int = 1 // Sent repeatedly while the button is held down
+	pressShortRelease int = 2 // Released after a short press
+	pressLongRelease  int = 3 // Released after being held down
+	pressDouble       int = 4
+	pressTriple       int = 5
+)
+
+// buttonCode returns the buttonevent code for a press on a button, eg. buttonCode(2, pressLongRelease) = 2003
+func buttonCode(button, press int) int {
+	return button*1000 + press
+}
+
+// The available actions
+const (
+	actionToggle  string = "toggle"  // Turns the targets on or off, the opposite of the last time
+	actionOn      string = "on"      // Turns the targets on
+	actionOff     string = "off"     // Turns the targets off
+	actionDimUp   string = "dimup"   // Makes the targets brighter by a step
+	actionDimDown string = "dimdown" // Makes the targets darker by a step
+	actionScene   string = "scene"   // Recalls a scene in a group
+	actionService string = "service" // Sends a value to a service of another Arrowhead system
+)
+
+// A ButtonAction is what the switch does for a buttonevent
+type ButtonAction struct {
+	Action     string              `json:"action"`
+	Targets    []string            `json:"targets"`    // Uniqueids of the lights and plugs, the slaves are used if it's empty
+	Step       int                 `json:"step"`       // Brightness change when dimming (of 254), a default is used if it's 0
+	Group      string              `json:"group"`      // Id of the group on the gateway, used for scenes (the group of the slaves if it's empty)
+	Scene      string              `json:"scene"`      // Id of the scene in the group
+	Service    string              `json:"service"`    // Definition of the Arrowhead service to set
+	Details    map[string][]string `json:"details"`    // Used by the orchestrator to find the service, eg. {"Location": ["Kitchen"]}
+	Value      float64             `json:"value"`      // Value sent to the service
+	Unit       string              `json:"unit"`       // Unit of the value sent to the service
+	Transition float64             `json:"transition"` // Seconds for the lights to fade, the gateway default is used if it's 0
+}
+
+// The actions used if a switch doesn't have any of its own, which keeps the old behaviour
+var defaultActions = map[string]ButtonAction{
+	strconv.Itoa(buttonCode(1, pressShortRelease)): {Action: actionToggle},
+}
+
+// The brightness step used when dimming, if the action doesn't set one
+const defaultDimStep int = 32
+
+var errUnknownAction error = fmt.Errorf("unknown button action")
+var errBadButtonCode error = fmt.Errorf("bad buttonevent code")
+var errMissingScene error = fmt.Errorf("missing group or scene")
+var errMissingService error = fmt.Errorf("missing service")
+
+// actions returns the action map of the switch, or the default actions if it has none
+func (ua *UnitAsset) actions() map[string]ButtonAction {
+	if len(ua.Actions) < 1 {
+		return defaultActions
+	}
+	return ua.Actions
+}
+
+// validateActions makes sure the actions can be used, so a typo is found at startup instead of at a button press
+func validateActions(actions map[string]ButtonAction) error {
+	for code, a := range actions {
+		if _, err := strconv.Atoi(code); err != nil {
+			return fmt.Errorf("%w: %q", errBadButtonCode, code)
+		}
+		switch a.Action {
+		case actionToggle, actionOn, actionOff, actionDimUp, actionDimDown:
+		case actionScene:
+			if a.Scene == "" {
+				return fmt.Errorf("%s: %w", code, errMissingScene)
+			}
+		case actionService:
+			if a.Service == "" {
+				return fmt.Errorf("%s: %w", code, errMissingService)
+			}
+		default:
+			return fmt.Errorf("%s: %w: %q", code, errUnknownAction, a.Action)
+		}
+	}
+	return nil
+}
+
+// actionCervice returns the key of the consumed service used by an action in the cervices map
+func actionCervice(code string) string {
+	return "button" + code
+}
+
+// newActionCervices creates the consumed services for the actions that sends values to other systems
+func newActionCervices(actions map[string]ButtonAction, protos []string) components.Cervices {
+	cervices := components.Cervices{}
+	for code, a := range actions {
+		if a.Action != actionService {
+			continue
+		}
+		details := components.MergeDetails(map[string][]string{"Forms": {"SignalA_v1a"}}, a.Details)
+		if a.Unit != "" {
+			details["Unit"] = []string{a.Unit}
+		}
+		cervices[actionCervice(code)] = &components.Cervice{
+			Name:    a.Service,
+			Protos:  protos,
+			Url:     make([]string, 0),
+			Details: details,
+		}
+	}
+	return cervices
+}
+
+// runAction performs the action for a buttonevent. The state is the on/off state last set by the switch,
+// which is used by the toggle action.
+func (ua *UnitAsset) runAction(code string, a ButtonAction, state bool) (newState bool, err error) {
+	newState = state
+	switch a.Action {
+	case actionToggle:
+		newState = !state
+		err = ua.setTargets(a.Targets, a.payload(map[string]any{"on": newState}))
+	case actionOn:
+		newState = true
+		err = ua.setTargets(a.Targets, a.payload(map[string]any{"on": true}))
+	case actionOff:
+		newState = false
+		err = ua.setTargets(a.Targets, a.payload(map[string]any{"on": false}))
+	case actionDimUp, actionDimDown:
+		step := a.Step
+		if step == 0 {
+			step = defaultDimStep
+		}
+		if a.Action == actionDimDown {
+			step = -step
+		}
+		err = ua.setTargets(a.Targets, a.payload(map[string]any{"bri_inc": step}))
+	case actionScene:
+		group := a.Group
+		if group == "" {
+			group = ua.group.get()
+		}
+		if group == "" {
+			return newState, fmt.Errorf("%s: %w", code, errMissingScene)
+		}
+		err = recallScene(group, a.Scene)
+	case actionService:
+		err = ua.sendActionValue(code, a)
+	default:
+		err = fmt.Errorf("%w: %q", errUnknownAction, a.Action)
+	}
+	return
+}
+
+// payload creates the light state sent by the action, with its transition time
+func (a ButtonAction) payload(state map[string]any) string {
+	if a.Transition > 0 {
+		state["transitiontime"] = transitionTime(a.Transition)
+	}
+	b, _ := json.Marshal(state) // Can't fail for these simple values
+	return string(b)
+}
+
+// setTargets sends the new state to the lights and plugs, or to the slaves of the switch if there are no targets.
+// The slaves are set all at once through their group, if the switch has one.
+func (ua *UnitAsset) setTargets(targets []string, payload string) error {
+	if group := ua.group.get(); len(targets) < 1 && group != "" {
+		return setGroup(group, payload)
+	}
+	if len(targets) < 1 {
+		for _, id := range ua.Slaves {
+			targets = append(targets, id)
+		}
+	}
+	for _, id := range targets {
+		apiURL := fmt.Sprintf("http://%s/api/%s/lights/%s/state", gateway, apikey, id)
+		req, err := createPutRequest(payload, apiURL)
+		if err != nil {
+			return err
+		}
+		if err = sendPutRequest(req); err != nil {
+			return fmt.Errorf("%s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// recallScene recalls a scene stored on the gateway
+func recallScene(group, scene string) error {
+	apiURL := fmt.Sprintf("http://%s/api/%s/groups/%s/scenes/%s/recall", gateway, apikey, group, scene)
+	req, err := createPutRequest("{}", apiURL)
+	if err != nil {
+		return err
+	}
+	return sendPutRequest(req)
+}
+
+// sendActionValue sends the value of the action to a service of another system
+func (ua *UnitAsset) sendActionValue(code string, a ButtonAction) error {
+	cer, found := ua.CervicesMap[actionCervice(code)]
+	if !found {
+		return fmt.Errorf("%s: %w", code, errMissingService)
+	}
+	var of forms.SignalA_v1a
+	of.NewForm()
+	of.Value = a.Value
+	of.Unit = a.Unit
+	of.Timestamp = time.Now()
+	op, err := usecases.Pack(&of, "application/json")
+	if err != nil {
+		return err
+	}
+	return usecases.SetState(cer, ua.Owner, op)
+}
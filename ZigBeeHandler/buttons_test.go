@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sdoque/mbaigo/components"
+)
+
+// recordTransport answers all requests with 200 OK and remembers them as "METHOD path body"
+type recordTransport struct {
+	mutex    sync.Mutex
+	requests []string
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := ""
+	if req.Body != nil {
+		b, _ := io.ReadAll(req.Body)
+		body = string(b)
+	}
+	t.mutex.Lock()
+	t.requests = append(t.requests, req.Method+" "+req.URL.Path+" "+body)
+	t.mutex.Unlock()
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: 200,
+		Body:       io.NopCloser(strings.NewReader(`{}`)),
+		Request:    req,
+	}, nil
+}
+
+func (t *recordTransport) take() []string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	r := t.requests
+	t.requests = nil
+	return r
+}
+
+func TestButtonCode(t *testing.T) {
+	if code := buttonCode(1, pressShortRelease); code != 1002 {
+		t.Errorf("expected 1002, got %d", code)
+	}
+	if code := buttonCode(4, pressDouble); code != 4004 {
+		t.Errorf("expected 4004, got %d", code)
+	}
+}
+
+func TestValidateActions(t *testing.T) {
+	table := []struct {
+		actions map[string]ButtonAction
+		err     error
+	}{
+		{nil, nil},
+		{map[string]ButtonAction{"1002": {Action: actionToggle}, "1001": {Action: actionDimUp}, "1003": {Action: actionOff}}, nil},
+		{map[string]ButtonAction{"2002": {Action: actionScene, Group: "1", Scene: "2"}}, nil},
+		{map[string]ButtonAction{"2002": {Action: actionScene, Scene: "2"}}, nil}, // Uses the group of the slaves
+		{map[string]ButtonAction{"2002": {Action: actionService, Service: "setpoint", Value: 21}}, nil},
+		{map[string]ButtonAction{"short": {Action: actionToggle}}, errBadButtonCode},
+		{map[string]ButtonAction{"1002": {Action: "explode"}}, errUnknownAction},
+		{map[string]ButtonAction{"1002": {Action: actionScene, Group: "1"}}, errMissingScene},
+		{map[string]ButtonAction{"1002": {Action: actionService}}, errMissingService},
+	}
+	for _, test := range table {
+		if err := validateActions(test.actions); !errors.Is(err, test.err) {
+			t.Errorf("expected %v for %+v, got %v", test.err, test.actions, err)
+		}
+	}
+}
+
+func TestButtonActions(t *testing.T) {
+	gateway = "localhost:8080"
+	apikey = "1234"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sys := components.NewSystem("ZigBeeHandler", ctx)
+	sys.Husk = &components.Husk{ProtoPort: map[string]int{"http": 8870}}
+	uac := UnitAsset{
+		Name:     "Remote",
+		Model:    "ZHASwitch",
+		Uniqueid: "remote",
+		Slaves:   map[string]string{"Lamp": "lamp"},
+		Actions: map[string]ButtonAction{
+			"1002": {Action: actionToggle},
+			"1001": {Action: actionDimUp},
+			"2001": {Action: actionDimDown, Step: 10, Targets: []string{"spot1", "spot2"}},
+			"2003": {Action: actionOff, Targets: []string{"spot1"}, Transition: 2},
+			"3002": {Action: actionScene, Group: "4", Scene: "7"},
+			"4004": {Action: actionService, Service: "setpoint", Details: map[string][]string{"Location": {"Kitchen"}}, Value: 22, Unit: "Celsius"},
+		},
+	}
+	ua, _ := newResource(uac, &sys, nil)
+	sw := ua.(*UnitAsset)
+	cer, found := sw.CervicesMap[actionCervice("4004")]
+	if !found || cer.Name != "setpoint" || cer.Details["Location"][0] != "Kitchen" || cer.Details["Unit"][0] != "Celsius" {
+		t.Fatalf("expected a cervice for the service action, got %+v", cer)
+	}
+	cer.Url = []string{"http://localhost:8871/Comfortstat/Kitchen/setpoint"}
+
+	tr := &recordTransport{}
+	http.DefaultClient.Transport = tr
+	table := []struct {
+		event    string
+		state    bool
+		requests []string
+	}{
+		{"1002", true, []string{"PUT /api/1234/lights/lamp/state {\"on\":true}"}},
+		{"1002", false, []string{"PUT /api/1234/lights/lamp/state {\"on\":false}"}},
+		{"1001", false, []string{"PUT /api/1234/lights/lamp/state {\"bri_inc\":32}"}},
+		{"2001", false, []string{"PUT /api/1234/lights/spot1/state {\"bri_inc\":-10}", "PUT /api/1234/lights/spot2/state {\"bri_inc\":-10}"}},
+		{"2003", false, []string{"PUT /api/1234/lights/spot1/state {\"on\":false,\"transitiontime\":20}"}},
+		{"3002", false, []string{"PUT /api/1234/groups/4/scenes/7/recall {}"}},
+		{"9002", false, nil}, // Not mapped
+	}
+	state := false
+	for _, test := range table {
+		msg := `{"state": {"buttonevent": ` + test.event + `}, "uniqueid": "remote"}`
+		var err error
+		state, err = sw.handleWebSocketMsg(state, []byte(msg))
+		if err != nil {
+			t.Errorf("expected no error for %s, got %v", test.event, err)
+		}
+		if state != test.state {
+			t.Errorf("expected the state to be %t after %s", test.state, test.event)
+		}
+		if got := tr.take(); strings.Join(got, "\n") != strings.Join(test.requests, "\n") {
+			t.Errorf("expected %v for %s, got %v", test.requests, test.event, got)
+		}
+	}
+
+	// The service action sends a form to the other system
+	if _, err := sw.handleWebSocketMsg(state, []byte(`{"state": {"buttonevent": 4004}, "uniqueid": "remote"}`)); err != nil {
+		t.Errorf("expected no error for the service action, got %v", err)
+	}
+	got := tr.take()
+	if len(got) != 1 || !strings.HasPrefix(got[0], "PUT /Comfortstat/Kitchen/setpoint") || !strings.Contains(got[0], `"value": 22`) {
+		t.Errorf("expected the setpoint to be sent, got %v", got)
+	}
+
+	// Events from other switches are ignored
+	if _, err := sw.handleWebSocketMsg(state, []byte(`{"state": {"buttonevent": 1002}, "uniqueid": "other"}`)); err != nil || len(tr.take()) != 0 {
+		t.Errorf("expected events from other switches to be ignored, got %v", err)
+	}
+
+	// Errors are returned with the buttonevent
+	newMockTransport(nil, false, errHTTP)
+	if _, err := sw.handleWebSocketMsg(state, []byte(`{"state": {"buttonevent": 3002}, "uniqueid": "remote"}`)); err == nil || !strings.Contains(err.Error(), "3002") {
+		t.Errorf("expected an error for the buttonevent, got %v", err)
+	}
+	delete(sw.CervicesMap, actionCervice("4004"))
+	if _, err := sw.runAction("4004", sw.Actions["4004"], false); !errors.Is(err, errMissingService) {
+		t.Errorf("expected errMissingService, got %v", err)
+	}
+	if _, err := sw.runAction("1002", ButtonAction{Action: "explode"}, false); !errors.Is(err, errUnknownAction) {
+		t.Errorf("expected errUnknownAction, got %v", err)
+	}
+
+	// Bad actions stops the startup
+	sw.Actions["1002"] = ButtonAction{Action: "explode"}
+	websocketport = "443"
+	if err := sw.startup(); !errors.Is(err, errUnknownAction) {
+		t.Errorf("expected the startup to fail, got %v", err)
+	}
+	apikey = ""
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/sdoque/mbaigo/forms"
+)
+
+// The state cache keeps the latest state of all lights and sensors in memory, so reads doesn't need a call
+// to the gateway each time. It's updated by the "changed" events from the websocket, and the whole state is
+// fetched again regularly in case an event was missed. Reads only go to the gateway when the cache is stale.
+
+// How often the whole state is fetched from the gateway
+const cacheRefresh time.Duration = time.Minute
+
+// How old the cached state of a device can be before it's considered stale, it's longer than the refresh
+// period so a single failed refresh doesn't make all reads go to the gateway
+const cacheMaxAge time.Duration = 3 * cacheRefresh
+
+// The formats used by the gateway for "lastupdated", in UTC
+var lastUpdatedLayouts = []string{"2006-01-02T15:04:05.000", "2006-01-02T15:04:05"}
+
+// The formats used by the gateway for "lastseen", which only has minutes
+var lastSeenLayouts = []string{"2006-01-02T15:04Z", time.RFC3339}
+
+// The cached state and config of a light or sensor
+type cacheEntry struct {
+	state    map[string]json.RawMessage
+	config   map[string]json.RawMessage
+	lastSeen time.Time // When the gateway last heard from the device, zero if it hasn't said
+	received time.Time // When the cache was last updated for the device
+}
+
+// A stateCache holds the latest state of the devices, keyed by uniqueid
+type stateCache struct {
+	mutex   sync.Mutex
+	devices map[string]*cacheEntry
+}
+
+// The cache shared by all unit assets
+var deviceCache = newStateCache()
+
+func newStateCache() *stateCache {
+	return &stateCache{devices: make(map[string]*cacheEntry)}
+}
+
+// merge updates the cached attributes of a device with the ones in state and config, the other attributes are
+// kept since the events only contains what has changed
+func (c *stateCache) merge(uniqueid string, state, config map[string]json.RawMessage) {
+	if uniqueid == "" || (len(state) < 1 && len(config) < 1) {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, found := c.devices[uniqueid]
+	if !found {
+		entry = &cacheEntry{state: make(map[string]json.RawMessage), config: make(map[string]json.RawMessage)}
+		c.devices[uniqueid] = entry
+	}
+	for k, v := range state {
+		entry.state[k] = v
+	}
+	for k, v := range config {
+		entry.config[k] = v
+	}
+	entry.received = time.Now()
+}
+
+// seen notes when the gateway last heard from a device, unless the time is unknown
+func (c *stateCache) seen(uniqueid string, lastSeen time.Time) {
+	if uniqueid == "" || lastSeen.IsZero() {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, found := c.devices[uniqueid]
+	if !found {
+		// Not received until the state is merged, so it isn't used for lookups before that
+		entry = &cacheEntry{state: make(map[string]json.RawMessage), config: make(map[string]json.RawMessage)}
+		c.devices[uniqueid] = entry
+	}
+	entry.lastSeen = lastSeen
+}
+
+// parseLastSeen reads a "lastseen" time from the gateway, it's false if the time is missing or can't be read
+func parseLastSeen(s string) (time.Time, bool) {
+	for _, layout := range lastSeenLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// handleEvent updates the cache with the changes from a websocket event
+func (c *stateCache) handleEvent(ev gatewayEvent) {
+	if ev.Event != "changed" {
+		return
+	}
+	var state, config map[string]json.RawMessage
+	if len(ev.State) > 0 {
+		if err := json.Unmarshal(ev.State, &state); err != nil {
+			log.Printf("Error parsing the state of %s: %s\n", ev.UniqueID, err)
+			return
+		}
+	}
+	if len(ev.Config) > 0 {
+		if err := json.Unmarshal(ev.Config, &config); err != nil {
+			log.Printf("Error parsing the config of %s: %s\n", ev.UniqueID, err)
+			return
+		}
+	}
+	c.merge(ev.UniqueID, state, config)
+	if len(ev.Attr) > 0 {
+		var attr struct {
+			LastSeen string `json:"lastseen"`
+		}
+		if err := json.Unmarshal(ev.Attr, &attr); err != nil {
+			log.Printf("Error parsing the attributes of %s: %s\n", ev.UniqueID, err)
+			return
+		}
+		if t, ok := parseLastSeen(attr.LastSeen); ok {
+			c.seen(ev.UniqueID, t)
+		}
+	}
+}
+
+// Parts of the full state from the gateway
+type resourceJSON struct {
+	UniqueID string                     `json:"uniqueid"`
+	LastSeen string                     `json:"lastseen"`
+	State    map[string]json.RawMessage `json:"state"`
+	Config   map[string]json.RawMessage `json:"config"`
+}
+
+type fullStateJSON struct {
+	Config struct {
+		WebsocketPort int `json:"websocketport"`
+	} `json:"config"`
+	Lights  map[string]resourceJSON `json:"lights"`
+	Sensors map[string]resourceJSON `json:"sensors"`
+}
+
+// refresh fetches the state of all devices from the gateway, and returns the websocket port from the config
+func (c *stateCache) refresh() (port int, err error) {
+	var full fullStateJSON
+	if err = getJSON("http://"+gateway+"/api/"+apikey, &full); err != nil {
+		return 0, err
+	}
+	for _, resources := range []map[string]resourceJSON{full.Lights, full.Sensors} {
+		for _, r := range resources {
+			c.merge(r.UniqueID, r.State, r.Config)
+			if t, ok := parseLastSeen(r.LastSeen); ok {
+				c.seen(r.UniqueID, t)
+			}
+		}
+	}
+	return full.Config.WebsocketPort, nil
+}
+
+// start fills the cache, and keeps it updated from the websocket and the regular refresh until the
+// context is cancelled
+func (c *stateCache) start(ctx context.Context) {
+	port, err := c.refresh()
+	if err != nil {
+		log.Printf("Error fetching the state of the devices: %s\n", err)
+	}
+	// No unit asset has looked up the websocket port yet
+	if websocketport == "startup" && port > 0 {
+		websocketport = fmt.Sprint(port)
+	}
+	events.addHandler(c.handleEvent)
+	events.start(ctx)
+	go c.loop(ctx)
+}
+
+func (c *stateCache) loop(ctx context.Context) {
+	ticker := time.NewTicker(cacheRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := c.refresh(); err != nil {
+				log.Printf("Error fetching the state of the devices: %s\n", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// lookup returns a cached state attribute of a device and when it was updated. The bool is false if the
+// attribute is missing or the device is stale, and the gateway has to be asked instead.
+func (c *stateCache) lookup(uniqueid, key string) (json.RawMessage, time.Time, bool) {
+	return c.find(uniqueid, key, false)
+}
+
+// find works like lookup, but can look in the config of the device instead of the state
+func (c *stateCache) find(uniqueid, key string, config bool) (json.RawMessage, time.Time, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, found := c.devices[uniqueid]
+	if !found || time.Since(entry.received) > cacheMaxAge {
+		return nil, time.Time{}, false
+	}
+	attributes := entry.state
+	if config {
+		attributes = entry.config
+	}
+	value, found := attributes[key]
+	if !found {
+		return nil, time.Time{}, false
+	}
+	return value, lastUpdated(entry), true
+}
+
+// snapshot returns copies of the cached state and config of a device, and when it was last seen. The bool is
+// false if the device is missing or stale.
+func (c *stateCache) snapshot(uniqueid string) (state, config map[string]json.RawMessage, lastSeen time.Time, ok bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, found := c.devices[uniqueid]
+	if !found || time.Since(entry.received) > cacheMaxAge {
+		return nil, nil, time.Time{}, false
+	}
+	return maps.Clone(entry.state), maps.Clone(entry.config), entry.lastSeen, true
+}
+
+// lastUpdated returns the time the gateway last updated the device, or the time the cache got the state
+// if the gateway doesn't say (lights doesn't have a "lastupdated")
+func lastUpdated(entry *cacheEntry) time.Time {
+	var s string
+	if err := json.Unmarshal(entry.state["lastupdated"], &s); err == nil {
+		for _, layout := range lastUpdatedLayouts {
+			if t, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+				return t
+			}
+		}
+	}
+	return entry.received
+}
+
+// number returns a cached numeric state attribute
+func (c *stateCache) number(uniqueid, key string) (float64, time.Time, bool) {
+	raw, updated, ok := c.lookup(uniqueid, key)
+	if !ok {
+		return 0, updated, false
+	}
+	var value float64
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return 0, updated, false
+	}
+	return value, updated, true
+}
+
+// boolean returns a cached true/false state attribute
+func (c *stateCache) boolean(uniqueid, key string) (bool, time.Time, bool) {
+	raw, updated, ok := c.lookup(uniqueid, key)
+	if !ok {
+		return false, updated, false
+	}
+	var value bool
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return false, updated, false
+	}
+	return value, updated, true
+}
+
+// cachedForm returns a form with a cached numeric state attribute, using the time the gateway updated it
+func cachedForm(uniqueid, key, unit string) (f forms.SignalA_v1a, ok bool) {
+	value, updated, ok := deviceCache.number(uniqueid, key)
+	if !ok {
+		return f, false
+	}
+	f = getForm(value, unit)
+	f.Timestamp = updated
+	return f, true
+}
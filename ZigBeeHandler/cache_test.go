@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sdoque/mbaigo/components"
+	"github.com/sdoque/mbaigo/forms"
+)
+
+const fullStateExample string = `{
+	"config": {"websocketport": 8443},
+	"lights": {
+		"1": {"uniqueid": "plug", "lastseen": "2025-01-02T03:04Z", "state": {"on": true, "reachable": true}}
+	},
+	"sensors": {
+		"2": {"uniqueid": "plug-power", "state": {"power": 25, "current": 110, "voltage": 231, "lastupdated": "2025-01-02T03:04:05.678"}},
+		"3": {"uniqueid": "plug-consumption", "state": {"consumption": 1234, "lastupdated": "2025-01-02T03:04:05"}, "config": {"on": true}}
+	}
+}`
+
+// useTestCache replaces the shared cache with an empty one during the test
+func useTestCache(t *testing.T) *stateCache {
+	original := deviceCache
+	deviceCache = newStateCache()
+	t.Cleanup(func() { deviceCache = original })
+	return deviceCache
+}
+
+func TestCacheRefresh(t *testing.T) {
+	gateway = "localhost:8080"
+	apikey = "1234"
+	c := useTestCache(t)
+	http.DefaultClient.Transport = pathTransport{map[string]string{"/api/1234": fullStateExample}}
+	port, err := c.refresh()
+	if err != nil || port != 8443 {
+		t.Fatalf("expected the websocket port and no error, got %d (%v)", port, err)
+	}
+	on, _, ok := c.boolean("plug", "on")
+	if !ok || !on {
+		t.Errorf("expected the plug to be on, got %t (%t)", on, ok)
+	}
+	power, updated, ok := c.number("plug-power", "power")
+	if !ok || power != 25 || !updated.Equal(time.Date(2025, 1, 2, 3, 4, 5, 678e6, time.UTC)) {
+		t.Errorf("expected 25 W at lastupdated, got %v at %v (%t)", power, updated, ok)
+	}
+	if _, updated, _ = c.number("plug-consumption", "consumption"); !updated.Equal(time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("expected the lastupdated without milliseconds, got %v", updated)
+	}
+	// Missing or wrong type
+	if _, _, ok = c.number("plug", "power"); ok {
+		t.Errorf("expected no power for the light")
+	}
+	if _, _, ok = c.number("plug", "on"); ok {
+		t.Errorf("expected a bool to not be read as a number")
+	}
+	if _, _, ok = c.boolean("missing", "on"); ok {
+		t.Errorf("expected nothing for a missing device")
+	}
+	if _, _, seen, _ := c.snapshot("plug"); !seen.Equal(time.Date(2025, 1, 2, 3, 4, 0, 0, time.UTC)) {
+		t.Errorf("expected the plug to be seen at lastseen, got %v", seen)
+	}
+
+	// Errors from the gateway
+	http.DefaultClient.Transport = pathTransport{map[string]string{}}
+	if _, err = c.refresh(); err == nil {
+		t.Errorf("expected an error when the gateway doesn't answer")
+	}
+}
+
+func TestCacheEvents(t *testing.T) {
+	c := useTestCache(t)
+	c.merge("plug-power", map[string]json.RawMessage{"power": json.RawMessage("25"), "voltage": json.RawMessage("230")}, nil)
+
+	// Only the changed attributes are in the event
+	c.handleEvent(gatewayEvent{Event: "changed", UniqueID: "plug-power", State: json.RawMessage(`{"power": 40}`)})
+	if power, _, _ := c.number("plug-power", "power"); power != 40 {
+		t.Errorf("expected the power to change to 40, got %v", power)
+	}
+	if voltage, _, _ := c.number("plug-power", "voltage"); voltage != 230 {
+		t.Errorf("expected the voltage to be kept, got %v", voltage)
+	}
+	// Ignored events
+	c.handleEvent(gatewayEvent{Event: "added", UniqueID: "plug-power", State: json.RawMessage(`{"power": 1}`)})
+	c.handleEvent(gatewayEvent{Event: "changed", UniqueID: "plug-power", State: json.RawMessage(`not json`)})
+	c.handleEvent(gatewayEvent{Event: "changed", UniqueID: "", State: json.RawMessage(`{"power": 1}`)})
+	if power, _, _ := c.number("plug-power", "power"); power != 40 {
+		t.Errorf("expected the power to stay at 40, got %v", power)
+	}
+
+	// Stale devices
+	c.devices["plug-power"].received = time.Now().Add(-cacheMaxAge - time.Second)
+	if _, _, ok := c.number("plug-power", "power"); ok {
+		t.Errorf("expected a stale device to not be used")
+	}
+}
+
+func TestCacheLastSeen(t *testing.T) {
+	table := []struct {
+		lastSeen string
+		want     time.Time
+		ok       bool
+	}{
+		{"2025-01-02T03:04Z", time.Date(2025, 1, 2, 3, 4, 0, 0, time.UTC), true},
+		{"2025-01-02T03:04:05Z", time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC), true},
+		{"", time.Time{}, false},
+		{"yesterday", time.Time{}, false},
+	}
+	for _, test := range table {
+		if got, ok := parseLastSeen(test.lastSeen); !got.Equal(test.want) || ok != test.ok {
+			t.Errorf("expected %v (%t) for %q, got %v (%t)", test.want, test.ok, test.lastSeen, got, ok)
+		}
+	}
+
+	c := useTestCache(t)
+	// Not used before the state is known
+	c.handleEvent(gatewayEvent{Event: "changed", UniqueID: "lamp", Attr: json.RawMessage(`{"lastseen": "2025-01-02T03:04Z"}`)})
+	if _, _, _, ok := c.snapshot("lamp"); ok {
+		t.Errorf("expected no snapshot without a state")
+	}
+	c.merge("lamp", map[string]json.RawMessage{"on": json.RawMessage("true")}, nil)
+	c.handleEvent(gatewayEvent{Event: "changed", UniqueID: "lamp", Attr: json.RawMessage(`{"lastseen": "2025-01-02T03:05Z"}`)})
+	state, _, seen, ok := c.snapshot("lamp")
+	if !ok || !seen.Equal(time.Date(2025, 1, 2, 3, 5, 0, 0, time.UTC)) || string(state["on"]) != "true" {
+		t.Errorf("expected the lamp to be seen at 03:05, got %v %v (%t)", seen, state, ok)
+	}
+	// The snapshot is a copy
+	state["on"] = json.RawMessage("false")
+	if on, _, _ := c.boolean("lamp", "on"); !on {
+		t.Errorf("expected the cache to be unchanged by the snapshot")
+	}
+	// Bad or missing times are ignored
+	c.handleEvent(gatewayEvent{Event: "changed", UniqueID: "lamp", Attr: json.RawMessage(`not json`)})
+	c.handleEvent(gatewayEvent{Event: "changed", UniqueID: "lamp", Attr: json.RawMessage(`{"name": "Lamp"}`)})
+	if _, _, seen, _ = c.snapshot("lamp"); !seen.Equal(time.Date(2025, 1, 2, 3, 5, 0, 0, time.UTC)) {
+		t.Errorf("expected the last seen time to be kept, got %v", seen)
+	}
+}
+
+func TestCachedReads(t *testing.T) {
+	gateway = "localhost:8080"
+	c := useTestCache(t)
+	c.merge("plug", map[string]json.RawMessage{"on": json.RawMessage("true")}, nil)
+	c.merge("plug-power", map[string]json.RawMessage{
+		"power":       json.RawMessage("25"),
+		"current":     json.RawMessage("110"),
+		"voltage":     json.RawMessage("231"),
+		"lastupdated": json.RawMessage(`"2025-01-02T03:04:05.678"`),
+	}, nil)
+	c.merge("plug-consumption", map[string]json.RawMessage{"consumption": json.RawMessage("1234")}, nil)
+	ua := initTemplate().(*UnitAsset)
+	ua.Model = "Smart plug"
+	ua.Uniqueid = "plug"
+	ua.Slaves = map[string]string{"ZHAPower": "plug-power", "ZHAConsumption": "plug-consumption"}
+
+	// The gateway isn't asked when the cache has the state
+	tr := newMockTransport(nil, false, errHTTP)
+	if f, err := ua.getState(); err != nil || f.Value != 1 {
+		t.Errorf("expected the cached state, got %v (%v)", f.Value, err)
+	}
+	readings := []struct {
+		get   func() (float64, string, time.Time, error)
+		value float64
+		unit  string
+	}{
+		{wrapGetter(ua.getPower), 25, "W"},
+		{wrapGetter(ua.getCurrent), 110, "mA"},
+		{wrapGetter(ua.getVoltage), 231, "V"},
+		{wrapGetter(ua.getConsumption), 1234, "Wh"},
+	}
+	for _, r := range readings {
+		value, unit, ts, err := r.get()
+		if err != nil || value != r.value || unit != r.unit || ts.IsZero() {
+			t.Errorf("expected %v %s, got %v %s at %v (%v)", r.value, r.unit, value, unit, ts, err)
+		}
+	}
+	if _, _, ts, _ := readings[0].get(); !ts.Equal(time.Date(2025, 1, 2, 3, 4, 5, 678e6, time.UTC)) {
+		t.Errorf("expected the lastupdated timestamp, got %v", ts)
+	}
+	if tr.hits["localhost"] != 0 {
+		t.Errorf("expected no calls to the gateway, got %d", tr.hits["localhost"])
+	}
+
+	// Falls back to the gateway when the cache is stale
+	c.devices["plug"].received = time.Now().Add(-cacheMaxAge - time.Second)
+	body := `{"state": {"on": false}}`
+	newMockTransport(&http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body))}, false, nil)
+	if f, err := ua.getState(); err != nil || f.Value != 0 {
+		t.Errorf("expected the state from the gateway, got %v (%v)", f.Value, err)
+	}
+}
+
+// wrapGetter makes the getters easier to loop over in the tests
+func wrapGetter(get func() (forms.SignalA_v1a, error)) func() (float64, string, time.Time, error) {
+	return func() (float64, string, time.Time, error) {
+		f, err := get()
+		return f.Value, f.Unit, f.Timestamp, err
+	}
+}
+
+func TestCacheStart(t *testing.T) {
+	srv, _, _ := newWebsocketServer(t, `{"t": "event", "e": "changed", "r": "sensors", "uniqueid": "plug-power", "state": {"power": 99}}`)
+	defer srv.Close()
+	apikey = "1234"
+	c := useTestCache(t)
+	original := events
+	events = newEventStream()
+	defer func() { events = original }()
+	http.DefaultClient.Transport = pathTransport{map[string]string{"/api/1234": fullStateExample}}
+	ctx, cancel := context.WithCancel(context.Background())
+	sys := components.NewSystem("ZigBeeHandler", ctx)
+	c.start(sys.Ctx)
+	waitConnected(t, events, true)
+	deadline := time.Now().Add(2 * time.Second)
+	for power, _, _ := c.number("plug-power", "power"); power != 99 && time.Now().Before(deadline); power, _, _ = c.number("plug-power", "power") {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if power, _, _ := c.number("plug-power", "power"); power != 99 {
+		t.Errorf("expected the power from the websocket event, got %v", power)
+	}
+	cancel()
+	waitConnected(t, events, false)
+	gateway = "localhost:8080"
+}
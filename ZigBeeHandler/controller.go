@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The feedback loop of a smart plug turns it on when the room is colder than the setpoint, and off when it's
+// warmer. To keep it from chattering around the setpoint, which wears out relays and is bad for heat pumps
+// and compressors, the plug is only switched when the temperature is outside of a deadband around the
+// setpoint. It's also kept on (or off) for a minimum time, and the number of switches per hour can be limited.
+
+// The window used for the maximum switching rate
+const switchWindow time.Duration = time.Hour
+
+// The reasons the controller shows for the state of the plug
+const (
+	reasonCold     string = "below the deadband"
+	reasonWarm     string = "above the deadband"
+	reasonDeadband string = "inside the deadband"
+	reasonMinOn    string = "waiting for the minimum on time"
+	reasonMinOff   string = "waiting for the minimum off time"
+	reasonMaxRate  string = "too many switches in the last hour"
+)
+
+// ControllerState shows what the feedback loop of a smart plug is doing
+type ControllerState struct {
+	On          bool      `json:"on"`          // The state set by the controller
+	Temperature float64   `json:"temperature"` // Celsius, the last temperature used
+	Setpoint    float64   `json:"setpoint"`    // Celsius
+	Lower       float64   `json:"lower"`       // The plug is turned on below this temperature
+	Upper       float64   `json:"upper"`       // The plug is turned off above this temperature
+	Reason      string    `json:"reason"`      // Why the plug is in its state
+	Changed     time.Time `json:"changed"`     // When the controller last switched the plug
+	Switches    int       `json:"switches"`    // The number of switches in the last hour
+	Checked     time.Time `json:"checked"`     // When the temperature was last checked
+}
+
+// A plugController keeps the state of the feedback loop between the checks
+type plugController struct {
+	mutex    sync.Mutex
+	known    bool        // If the state of the plug is known, it isn't until the first switch or reading
+	on       bool        // The state of the plug
+	changed  time.Time   // When the plug was last switched
+	switches []time.Time // When the plug was switched, within the switch window
+	state    ControllerState
+}
+
+// The limits of the controller, from the configuration of the unit asset
+type controllerLimits struct {
+	deadband    float64       // Celsius, the total width of the deadband
+	minOn       time.Duration // The plug isn't turned off before it has been on this long
+	minOff      time.Duration // The plug isn't turned on before it has been off this long
+	maxSwitches int           // Switches allowed per hour, 0 means no limit
+}
+
+// limits returns the controller limits of the unit asset, its times are configured in seconds
+func (ua *UnitAsset) limits() controllerLimits {
+	return controllerLimits{
+		deadband:    ua.Deadband,
+		minOn:       time.Duration(ua.MinOnTime * float64(time.Second)),
+		minOff:      time.Duration(ua.MinOffTime * float64(time.Second)),
+		maxSwitches: ua.MaxSwitches,
+	}
+}
+
+// decide returns if the plug should be on and why
+func (c *plugController) decide(temp, setpt float64, l controllerLimits, now time.Time) (on bool, reason string) {
+	lower, upper := setpt-l.deadband/2, setpt+l.deadband/2
+	switch {
+	case temp < lower:
+		on, reason = true, reasonCold
+	case temp > upper:
+		on, reason = false, reasonWarm
+	case !c.known:
+		on, reason = temp < setpt, reasonDeadband // Nothing to keep yet
+	default:
+		return c.on, reasonDeadband
+	}
+	if !c.known || on == c.on {
+		return
+	}
+	// The plug should be switched, if the limits allows it
+	switch {
+	case c.on && now.Sub(c.changed) < l.minOn:
+		return c.on, reasonMinOn
+	case !c.on && now.Sub(c.changed) < l.minOff:
+		return c.on, reasonMinOff
+	case l.maxSwitches > 0 && len(c.switches) >= l.maxSwitches:
+		return c.on, reasonMaxRate
+	}
+	return
+}
+
+// controlPlug switches the plug depending on the temperature, within the limits of the unit asset.
+// The mutex isn't held while the plug is switched, so the controller service doesn't wait for the gateway.
+func (ua *UnitAsset) controlPlug(temp float64) error {
+	c := ua.controller
+	setpt := ua.getSetPoint().Value
+	l := ua.limits()
+	now := time.Now()
+	f, stateErr := ua.getState()
+
+	c.mutex.Lock()
+	// Someone else might have switched the plug, eg. with a switch
+	if stateErr == nil {
+		if c.known && c.on != (f.Value == 1) {
+			c.changed = now
+		}
+		c.known, c.on = true, f.Value == 1
+	}
+	for len(c.switches) > 0 && now.Sub(c.switches[0]) >= switchWindow {
+		c.switches = c.switches[1:]
+	}
+	on, reason := c.decide(temp, setpt, l, now)
+	toggle := !c.known || on != c.on
+	c.mutex.Unlock()
+
+	var err error
+	if toggle {
+		err = ua.toggleState(on)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if toggle && err == nil {
+		c.known, c.on, c.changed = true, on, now
+		c.switches = append(c.switches, now)
+		// So the next check doesn't mistake the old cached state for someone else switching the plug
+		deviceCache.merge(ua.Uniqueid, map[string]json.RawMessage{"on": json.RawMessage(strconv.FormatBool(on))}, nil)
+	}
+	c.state = ControllerState{
+		On:          c.on,
+		Temperature: temp,
+		Setpoint:    setpt,
+		Lower:       setpt - l.deadband/2,
+		Upper:       setpt + l.deadband/2,
+		Reason:      reason,
+		Changed:     c.changed,
+		Switches:    len(c.switches),
+		Checked:     now,
+	}
+	return err
+}
+
+// getController returns the state of the feedback loop
+func (ua *UnitAsset) getController() ControllerState {
+	ua.controller.mutex.Lock()
+	defer ua.controller.mutex.Unlock()
+	return ua.controller.state
+}
+
+// Function used by the webhandler to get the state of the feedback loop of a smart plug
+func (rsc *UnitAsset) controllerState(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		if !rsc.supports("controller") {
+			http.Error(w, "That device doesn't support that method.", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(rsc.getController()); err != nil {
+			log.Printf("Error encoding the controller state: %s\n", err)
+		}
+	default:
+		http.Error(w, "Method is not supported", http.StatusNotFound)
+	}
+}
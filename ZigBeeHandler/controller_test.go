@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDecide(t *testing.T) {
+	now := time.Now()
+	limits := controllerLimits{deadband: 1, minOn: 5 * time.Minute, minOff: 10 * time.Minute, maxSwitches: 2}
+	table := []struct {
+		name     string
+		c        *plugController
+		temp     float64
+		wantOn   bool
+		reason   string
+		limitsOf controllerLimits
+	}{
+		{"unknown and cold", &plugController{}, 19.9, true, reasonDeadband, limits},
+		{"unknown and warm", &plugController{}, 20.1, false, reasonDeadband, limits},
+		{"off inside the deadband", &plugController{known: true}, 19.6, false, reasonDeadband, limits},
+		{"on inside the deadband", &plugController{known: true, on: true}, 20.4, true, reasonDeadband, limits},
+		{"off and cold", &plugController{known: true, changed: now.Add(-time.Hour)}, 19.4, true, reasonCold, limits},
+		{"on and warm", &plugController{known: true, on: true, changed: now.Add(-time.Hour)}, 20.6, false, reasonWarm, limits},
+		{"minimum on time", &plugController{known: true, on: true, changed: now.Add(-time.Minute)}, 21, true, reasonMinOn, limits},
+		{"minimum off time", &plugController{known: true, changed: now.Add(-time.Minute)}, 19, false, reasonMinOff, limits},
+		{"rate limit", &plugController{known: true, changed: now.Add(-time.Hour), switches: []time.Time{now, now}}, 19, false, reasonMaxRate, limits},
+		{"no limits", &plugController{known: true, on: true, changed: now, switches: []time.Time{now, now, now}}, 20.1, false, reasonWarm, controllerLimits{}},
+	}
+	for _, test := range table {
+		on, reason := test.c.decide(test.temp, 20, test.limitsOf, now)
+		if on != test.wantOn || reason != test.reason {
+			t.Errorf("%s: expected %t (%s), got %t (%s)", test.name, test.wantOn, test.reason, on, reason)
+		}
+	}
+}
+
+func TestControlPlug(t *testing.T) {
+	ua := newTestAsset(t, "Plug", "Smart plug")
+	ua.Period = 10
+	ua.MinOnTime = 0
+	ua.MinOffTime = 0
+	ua.MaxSwitches = 2
+	c := useTestCache(t)
+	tr := &recordTransport{}
+	http.DefaultClient.Transport = tr
+
+	// The plug is on, and it's cold
+	c.merge("plug", map[string]json.RawMessage{"on": json.RawMessage("true")}, nil)
+	if err := ua.controlPlug(19); err != nil || len(tr.take()) != 0 {
+		t.Errorf("expected nothing to be sent when the plug is already on (%v)", err)
+	}
+	// Inside the deadband
+	if err := ua.controlPlug(20.2); err != nil || len(tr.take()) != 0 {
+		t.Errorf("expected nothing to be sent inside the deadband (%v)", err)
+	}
+	// Too warm
+	if err := ua.controlPlug(20.3); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if got := tr.take(); len(got) != 1 || got[0] != `PUT /api/1234/lights/plug/state {"on":false}` {
+		t.Errorf("expected the plug to be turned off, got %v", got)
+	}
+	if on, _, _ := c.boolean("plug", "on"); on {
+		t.Errorf("expected the new state to be cached")
+	}
+	// Cold again
+	if err := ua.controlPlug(19.7); err != nil || len(tr.take()) != 1 {
+		t.Errorf("expected the plug to be turned on (%v)", err)
+	}
+	// Two switches in the last hour is the limit
+	if err := ua.controlPlug(21); err != nil || len(tr.take()) != 0 {
+		t.Errorf("expected the plug to stay on because of the rate limit (%v)", err)
+	}
+	s := ua.getController()
+	if !s.On || s.Reason != reasonMaxRate || s.Switches != 2 || s.Lower != 19.75 || s.Upper != 20.25 || s.Temperature != 21 {
+		t.Errorf("expected the controller state, got %+v", s)
+	}
+	// The old switches are forgotten after an hour
+	ua.controller.switches = []time.Time{time.Now().Add(-2 * time.Hour)}
+	if err := ua.controlPlug(21); err != nil || len(tr.take()) != 1 {
+		t.Errorf("expected the plug to be turned off after the hour (%v)", err)
+	}
+	if s = ua.getController(); s.Switches != 1 {
+		t.Errorf("expected one switch in the last hour, got %d", s.Switches)
+	}
+
+	// Someone else turns the plug on, which starts the minimum on time
+	ua.MinOnTime = 300
+	c.merge("plug", map[string]json.RawMessage{"on": json.RawMessage("true")}, nil)
+	if err := ua.controlPlug(21); err != nil || len(tr.take()) != 0 {
+		t.Errorf("expected the plug to stay on for the minimum on time (%v)", err)
+	}
+	if s = ua.getController(); s.Reason != reasonMinOn {
+		t.Errorf("expected to wait for the minimum on time, got %+v", s)
+	}
+
+	// Failing to switch the plug
+	ua.MinOnTime = 0
+	newMockTransport(nil, false, errHTTP)
+	if err := ua.controlPlug(21); err == nil {
+		t.Errorf("expected an error when the plug can't be reached")
+	}
+	if s = ua.getController(); !s.On {
+		t.Errorf("expected the plug to still be on, got %+v", s)
+	}
+}
+
+func TestControllerService(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	ua.Model = "Smart plug"
+	ua.Period = 10
+	ua.controller.state = ControllerState{On: true, Reason: reasonCold}
+	request := func(method string) *http.Response {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, "http://localhost:8870/ZigBeeHandler/Plug/controller", nil)
+		ua.Serving(w, r, "controller")
+		return w.Result()
+	}
+
+	var s ControllerState
+	resp := request("GET")
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil || resp.StatusCode != good_code || s.Reason != reasonCold {
+		t.Errorf("expected the controller state, got %d %+v (%v)", resp.StatusCode, s, err)
+	}
+	if resp = request("PUT"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404 for PUT, got %d", resp.StatusCode)
+	}
+	// Plugs controlled by a switch doesn't have a feedback loop
+	ua.Period = 0
+	if resp = request("GET"); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected status 500 without a feedback loop, got %d", resp.StatusCode)
+	}
+	ua.Model = "ZHAThermostat"
+	ua.Period = 10
+	if resp = request("GET"); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected status 500 for thermostats, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"slices"
+
+	"github.com/sdoque/mbaigo/components"
+)
+
+// The device registry lists what each kind of device supports. A unit asset only gets the services of its
+// model, and the http handlers checks the registry instead of the model names. A new kind of device is added
+// by adding it to the registry (and its readings, if it's a sensor).
+
+// A deviceModel describes what a kind of device supports
+type deviceModel struct {
+	Resource string            // Where the gateway lists the device, "lights" or "sensors"
+	Services []string          // Subpaths of the services the device supports, besides the ones of its readings
+	Light    lightCapabilities // What a light always supports, more is detected from its state at startup
+	Readings []sensorReading   // The readings of a sensor, each is served by its own service
+}
+
+// The services supported by all devices
+var commonServices = []string{"gateway", "health", "reachable", "lastseen", "stale"}
+
+// The services for the whole system, which are only supported by the first unit asset in the configuration
+// since they would be the same for all of them (and the summary checks every device)
+var systemServices = []string{"summary"}
+
+// The services of lights that are only supported when the light has the capability
+var lightServices = []string{"state", "brightness", "colortemp", "hue", "saturation", "xy", "transition"}
+
+// The supported devices, keyed by the model used by the unit assets (which is the deCONZ type)
+var deviceModels = map[string]deviceModel{
+	"Smart plug": {
+		Resource: "lights",
+		Services: []string{"state", "setpoint", "consumption", "power", "current", "voltage", "controller"},
+	},
+	"On/Off light":            {Resource: "lights", Services: lightServices},
+	"Dimmable light":          {Resource: "lights", Services: lightServices, Light: lightCapabilities{Brightness: true}},
+	"Color temperature light": {Resource: "lights", Services: lightServices, Light: lightCapabilities{Brightness: true, ColorTemp: true}},
+	"Color light":             {Resource: "lights", Services: lightServices, Light: lightCapabilities{Brightness: true, HueSat: true, XY: true}},
+	"Extended color light": {
+		Resource: "lights",
+		Services: lightServices,
+		Light:    lightCapabilities{Brightness: true, ColorTemp: true, HueSat: true, XY: true},
+	},
+	"ZHASwitch": {Resource: "sensors", Services: []string{"scene", "scenes"}},
+	"ZHAThermostat": {
+		Resource: "sensors",
+		Services: []string{"setpoint", "thermostat", "battery"},
+		// What the thermostat reports, unlike the setpoint service which shows the setpoint sent to it
+		Readings: []sensorReading{
+			{Service: "temperature", Key: "temperature", Unit: "Celsius", Divisor: 100},
+			{Service: "heatsetpoint", Key: "heatsetpoint", Unit: "Celsius", Divisor: 100, Config: true},
+			{Service: "valve", Key: "valve", Unit: "Percent", Divisor: 2.55}, // 0-255
+			{Service: "windowopen", Key: "windowopen", Unit: "Binary", Divisor: 1, Values: windowOpenValues},
+		},
+	},
+	"ZHATemperature": {
+		Resource: "sensors",
+		Services: []string{"battery"},
+		Readings: []sensorReading{{Service: "temperature", Key: "temperature", Unit: "Celsius", Divisor: 100}},
+	},
+	"ZHAHumidity": {
+		Resource: "sensors",
+		Services: []string{"battery"},
+		Readings: []sensorReading{{Service: "humidity", Key: "humidity", Unit: "Percent", Divisor: 100}},
+	},
+	"ZHAPressure": {
+		Resource: "sensors",
+		Services: []string{"battery"},
+		Readings: []sensorReading{{Service: "pressure", Key: "pressure", Unit: "hPa", Divisor: 1}},
+	},
+	"ZHALightLevel": {
+		Resource: "sensors",
+		Services: []string{"battery"},
+		Readings: []sensorReading{{Service: "lightlevel", Key: "lux", Unit: "Lux", Divisor: 1}},
+	},
+	"ZHAPresence": {
+		Resource: "sensors",
+		Services: []string{"battery"},
+		Readings: []sensorReading{{Service: "presence", Key: "presence", Unit: "Binary", Divisor: 1}},
+	},
+	"ZHAOpenClose": {
+		Resource: "sensors",
+		Services: []string{"battery"},
+		Readings: []sensorReading{{Service: "open", Key: "open", Unit: "Binary", Divisor: 1}},
+	},
+}
+
+// The deCONZ types that uses the model of another type
+var deviceTypeModels = map[string]string{
+	"On/Off plug-in unit": "Smart plug",
+	"On/Off output":       "Smart plug",
+}
+
+// modelOfType returns the model used for a deCONZ type, if it's supported and found in the resource
+func modelOfType(deviceType, resource string) (string, bool) {
+	model := deviceType
+	if m, found := deviceTypeModels[deviceType]; found {
+		model = m
+	}
+	m, found := deviceModels[model]
+	return model, found && m.Resource == resource
+}
+
+// has checks if the model supports the service, not counting the capabilities of lights
+func (m deviceModel) has(service string) bool {
+	if slices.Contains(commonServices, service) {
+		return true
+	}
+	for _, r := range m.Readings {
+		if r.Service == service {
+			return true
+		}
+	}
+	return slices.Contains(m.Services, service)
+}
+
+// detectsLight checks if the capabilities of the model has to be detected at startup
+func (m deviceModel) detectsLight() bool {
+	return slices.ContainsFunc(m.Services, func(s string) bool {
+		return s != "state" && slices.Contains(lightServices, s)
+	})
+}
+
+// supports checks if the unit asset supports the service, including the capabilities detected for a light and
+// the group set up for a switch
+func (ua *UnitAsset) supports(service string) bool {
+	if slices.Contains(systemServices, service) {
+		return ua.systemAsset
+	}
+	if !deviceModels[ua.Model].has(service) {
+		return false
+	}
+	switch service {
+	case "brightness", "transition":
+		return ua.capabilities.Brightness
+	case "colortemp":
+		return ua.capabilities.ColorTemp
+	case "hue", "saturation":
+		return ua.capabilities.HueSat
+	case "xy":
+		return ua.capabilities.XY
+	case "scene", "scenes":
+		return ua.group.get() != "" // Only a switch with a group has scenes
+	case "controller":
+		return ua.Period >= 1 // Only a smart plug with a feedback loop has a controller
+	}
+	return true
+}
+
+// filterServices returns the services that are kept, the others are left out
+func filterServices(services []components.Service, keep func(subpath string) bool) []components.Service {
+	var kept []components.Service
+	for _, s := range services {
+		if keep(s.SubPath) {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// dropUnsupported removes the services the unit asset doesn't support, after its capabilities (or group) has been detected.
+// The map is replaced instead of changed, since the http server might be reading it.
+func (ua *UnitAsset) dropUnsupported() {
+	services := make(components.Services)
+	for name, s := range ua.ServicesMap {
+		if ua.supports(s.SubPath) {
+			services[name] = s
+		}
+	}
+	ua.ServicesMap = services
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/sdoque/mbaigo/components"
+)
+
+func TestModelOfType(t *testing.T) {
+	table := []struct {
+		deviceType, resource, model string
+		found                       bool
+	}{
+		{"Smart plug", "lights", "Smart plug", true},
+		{"On/Off plug-in unit", "lights", "Smart plug", true},
+		{"Dimmable light", "lights", "Dimmable light", true},
+		{"ZHATemperature", "sensors", "ZHATemperature", true},
+		{"ZHATemperature", "lights", "ZHATemperature", false},
+		{"Range extender", "lights", "Range extender", false},
+		{"ZHAConsumption", "sensors", "ZHAConsumption", false},
+	}
+	for _, test := range table {
+		model, found := modelOfType(test.deviceType, test.resource)
+		if model != test.model || found != test.found {
+			t.Errorf("expected %s (%t) for %s in %s, got %s (%t)", test.model, test.found, test.deviceType, test.resource, model, found)
+		}
+	}
+}
+
+// The registry and the template has to agree, or a service can't be registered
+func TestRegistryServices(t *testing.T) {
+	template := initTemplate().GetServices()
+	used := make(map[string]bool)
+	for _, s := range commonServices {
+		used[s] = true
+		if _, found := template[s]; !found {
+			t.Errorf("common service %s is missing from the template", s)
+		}
+	}
+	for _, s := range systemServices {
+		used[s] = true
+		if _, found := template[s]; !found {
+			t.Errorf("system service %s is missing from the template", s)
+		}
+	}
+	for model, m := range deviceModels {
+		for _, s := range m.Services {
+			used[s] = true
+			if _, found := template[s]; !found {
+				t.Errorf("%s: service %s is missing from the template", model, s)
+			}
+		}
+		for _, r := range m.Readings {
+			used[r.Service] = true
+			if _, found := template[r.Service]; !found {
+				t.Errorf("%s: reading service %s is missing from the template", model, r.Service)
+			}
+		}
+		if m.Resource != "lights" && m.Resource != "sensors" {
+			t.Errorf("%s: unknown resource %q", model, m.Resource)
+		}
+	}
+	for s := range template {
+		if !used[s] {
+			t.Errorf("service %s isn't supported by any device", s)
+		}
+	}
+}
+
+func TestSupports(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	table := []struct {
+		model   string
+		service string
+		want    bool
+	}{
+		{"Smart plug", "consumption", true},
+		{"Smart plug", "gateway", true},
+		{"Smart plug", "brightness", false},
+		{"ZHAThermostat", "setpoint", true},
+		{"ZHAThermostat", "valve", true},
+		{"ZHAThermostat", "state", false},
+		{"ZHAOpenClose", "open", true},
+		{"ZHAOpenClose", "battery", true},
+		{"ZHAOpenClose", "temperature", false},
+		{"ZHASwitch", "battery", false},
+		{"Unknown device", "state", false},
+		{"Unknown device", "gateway", true},
+		// Not detected yet
+		{"Extended color light", "state", true},
+		{"Extended color light", "brightness", false},
+	}
+	for _, test := range table {
+		ua.Model = test.model
+		if got := ua.supports(test.service); got != test.want {
+			t.Errorf("expected %t for %s on %s, got %t", test.want, test.service, test.model, got)
+		}
+	}
+
+	ua.Model = "Color temperature light"
+	ua.capabilities = lightCapabilities{Brightness: true, ColorTemp: true}
+	for service, want := range map[string]bool{"brightness": true, "transition": true, "colortemp": true, "hue": false, "xy": false} {
+		if got := ua.supports(service); got != want {
+			t.Errorf("expected %t for %s, got %t", want, service, got)
+		}
+	}
+	// The capabilities of a light doesn't add services to other models
+	ua.Model = "Smart plug"
+	if ua.supports("brightness") {
+		t.Errorf("expected plugs to not support brightness")
+	}
+}
+
+func TestResourceServices(t *testing.T) {
+	var servs []components.Service
+	for _, s := range initTemplate().GetServices() {
+		servs = append(servs, *s)
+	}
+	sys := components.NewSystem("ZigBeeHandler", nil)
+	sys.Husk = &components.Husk{ProtoPort: map[string]int{"http": 8870}}
+	table := []struct {
+		model string
+		want  []string
+	}{
+		{"ZHATemperature", []string{"temperature", "battery"}},
+		{"ZHASwitch", []string{"scene", "scenes"}},
+		{"Smart plug", []string{"state", "setpoint", "consumption", "power", "current", "voltage", "controller"}},
+	}
+	for _, test := range table {
+		ua, _ := newResource(UnitAsset{Name: "Device", Model: test.model}, &sys, servs)
+		services := ua.GetServices()
+		test.want = append(test.want, commonServices...)
+		if len(services) != len(test.want) {
+			t.Errorf("expected %v for %s, got %d services", test.want, test.model, len(services))
+		}
+		for _, s := range test.want {
+			if _, found := services[s]; !found {
+				t.Errorf("expected %s to have the %s service", test.model, s)
+			}
+		}
+	}
+
+	// Lights drops the services they don't support after the detection
+	ua, _ := newResource(UnitAsset{Name: "Lamp", Model: "Dimmable light"}, &sys, servs)
+	light := ua.(*UnitAsset)
+	if len(light.ServicesMap) != len(lightServices)+len(commonServices) {
+		t.Errorf("expected all light services before the detection, got %d", len(light.ServicesMap))
+	}
+	light.capabilities = lightCapabilities{Brightness: true}
+	light.dropUnsupported()
+	if len(light.ServicesMap) != 3+len(commonServices) {
+		t.Errorf("expected state, brightness, transition and the common services, got %v", light.ServicesMap)
+	}
+
+	// Plugs without a feedback loop drops the controller
+	ua, _ = newResource(UnitAsset{Name: "Plug", Model: "Smart plug"}, &sys, servs)
+	plug := ua.(*UnitAsset)
+	plug.dropUnsupported()
+	if _, found := plug.ServicesMap["controller"]; found {
+		t.Errorf("expected a plug without a period to drop the controller, got %v", plug.ServicesMap)
+	}
+
+	// Only the first unit asset in the configuration has the services for the whole system
+	ua, _ = newResource(UnitAsset{Name: "First", Model: "Smart plug", systemAsset: true}, &sys, servs)
+	first := ua.(*UnitAsset)
+	if _, found := first.ServicesMap["summary"]; !found || !first.supports("summary") {
+		t.Errorf("expected the first unit asset to have the summary, got %v", first.ServicesMap)
+	}
+	first.dropUnsupported()
+	if _, found := first.ServicesMap["summary"]; !found {
+		t.Errorf("expected the summary to be kept, got %v", first.ServicesMap)
+	}
+	if _, found := light.ServicesMap["summary"]; found || light.supports("summary") {
+		t.Errorf("expected the other unit assets to be without the summary, got %v", light.ServicesMap)
+	}
+}
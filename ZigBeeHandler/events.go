@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// The gateway pushes all changes (button presses, new sensor readings, lights turning on...) as events on a
+// single websocket. The system keeps one connection to it and hands the events to the unit assets that has
+// subscribed to the uniqueid of the device, so the unit assets doesn't need their own connections.
+
+// A gatewayEvent is a message from the gateway websocket
+// https://dresden-elektronik.github.io/deconz-rest-doc/endpoints/websocket/
+type gatewayEvent struct {
+	Type     string          `json:"t"`        // Always "event"
+	Event    string          `json:"e"`        // "changed", "added", "deleted" or "scene-called"
+	Resource string          `json:"r"`        // "lights", "sensors", "groups" or "scenes"
+	ID       string          `json:"id"`       // The id of the resource on the gateway
+	UniqueID string          `json:"uniqueid"` // Missing for groups and scenes
+	State    json.RawMessage `json:"state"`
+	Config   json.RawMessage `json:"config"`
+	Attr     json.RawMessage `json:"attr"` // Attributes outside of the state and config, eg. "lastseen"
+	Raw      []byte          `json:"-"`    // The whole message
+}
+
+// How long to wait before reconnecting, the wait is doubled after each failed attempt
+const (
+	eventRetryMin time.Duration = time.Second
+	eventRetryMax time.Duration = time.Minute
+)
+
+// How many events a subscriber can fall behind, before new events are dropped
+const eventBuffer int = 16
+
+// An eventStream keeps the connection to the gateway websocket and the subscribers of the events
+type eventStream struct {
+	once        sync.Once
+	mutex       sync.Mutex
+	subscribers map[string][]chan gatewayEvent // Keyed by uniqueid
+	handlers    []func(gatewayEvent)           // Gets all events
+	connected   bool
+}
+
+// The event stream shared by all unit assets
+var events = newEventStream()
+
+func newEventStream() *eventStream {
+	return &eventStream{subscribers: make(map[string][]chan gatewayEvent)}
+}
+
+// start connects to the gateway websocket in the background, it's only done once no matter how many
+// unit assets calls it. The connection is closed when the context is cancelled.
+func (s *eventStream) start(ctx context.Context) {
+	s.once.Do(func() {
+		go s.run(ctx)
+	})
+}
+
+// subscribe returns a channel that receives all events for the device with the uniqueid
+func (s *eventStream) subscribe(uniqueid string) <-chan gatewayEvent {
+	ch := make(chan gatewayEvent, eventBuffer)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.subscribers[uniqueid] = append(s.subscribers[uniqueid], ch)
+	return ch
+}
+
+// addHandler adds a function that is called with every event, it has to be quick since it blocks the stream
+func (s *eventStream) addHandler(h func(gatewayEvent)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.handlers = append(s.handlers, h)
+}
+
+// publish hands the event to the handlers and to the subscribers of its uniqueid, without waiting for
+// slow subscribers
+func (s *eventStream) publish(ev gatewayEvent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, h := range s.handlers {
+		h(ev)
+	}
+	for _, ch := range s.subscribers[ev.UniqueID] {
+		select {
+		case ch <- ev:
+		default:
+			log.Printf("Dropped a websocket event for %s, the unit asset is too slow\n", ev.UniqueID)
+		}
+	}
+}
+
+// isConnected checks if the websocket is connected right now
+func (s *eventStream) isConnected() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.connected
+}
+
+func (s *eventStream) setConnected(connected bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.connected = connected
+}
+
+// websocketURL returns the address of the websocket, on the same host as the gateway
+func websocketURL() string {
+	host, _, err := net.SplitHostPort(gateway)
+	if err != nil {
+		host = gateway // No port
+	}
+	return "ws://" + net.JoinHostPort(host, websocketport)
+}
+
+// run keeps the websocket connected until the context is cancelled, reconnecting with a growing wait
+// when the connection fails or is lost
+func (s *eventStream) run(ctx context.Context) {
+	retry := eventRetryMin
+	for {
+		received, err := s.listen(ctx)
+		if ctx.Err() != nil {
+			return // Shutdown
+		}
+		if received {
+			retry = eventRetryMin // The connection worked for a while, so start over
+		}
+		log.Printf("Websocket connection to the gateway lost, reconnecting in %s: %s\n", retry, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retry):
+		}
+		retry = min(retry*2, eventRetryMax)
+	}
+}
+
+// listen connects to the websocket and publishes the events until the connection fails or the context
+// is cancelled. It returns true if any events were received.
+func (s *eventStream) listen(ctx context.Context) (received bool, err error) {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, websocketURL(), nil)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	s.setConnected(true)
+	defer s.setConnected(false)
+
+	// ReadMessage blocks, so the connection is closed from here to stop it on shutdown
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		_, b, err := conn.ReadMessage()
+		if err != nil {
+			return received, err
+		}
+		received = true
+		var ev gatewayEvent
+		if err := json.Unmarshal(b, &ev); err != nil {
+			log.Printf("Error parsing websocket event: %s\n", err)
+			continue
+		}
+		ev.Raw = b
+		s.publish(ev)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sdoque/mbaigo/components"
+)
+
+// newWebsocketServer starts a fake gateway websocket, that sends the messages to each new connection and then
+// waits until the client or the hangup channel closes it. The gateway and websocketport are set to point at it.
+func newWebsocketServer(t *testing.T, messages ...string) (*httptest.Server, chan struct{}, chan struct{}) {
+	connected := make(chan struct{}, 10)
+	hangup := make(chan struct{})
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("expected no upgrade error, got %v", err)
+			return
+		}
+		defer conn.Close()
+		connected <- struct{}{}
+		for _, m := range messages {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(m)); err != nil {
+				return
+			}
+		}
+		// Wait for the client to hang up
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+		select {
+		case <-closed:
+		case <-hangup:
+		}
+	}))
+	host, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	gateway = host + ":80"
+	websocketport = port
+	return srv, connected, hangup
+}
+
+func receiveEvent(t *testing.T, ch <-chan gatewayEvent) gatewayEvent {
+	t.Helper()
+	select {
+	case ev := <-ch:
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected an event, got none")
+	}
+	return gatewayEvent{}
+}
+
+// waitConnected waits until the stream is (or isn't) connected
+func waitConnected(t *testing.T, s *eventStream, connected bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for s.isConnected() != connected && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s.isConnected() != connected {
+		t.Fatalf("expected the stream connected to be %t", connected)
+	}
+}
+
+func TestWebsocketURL(t *testing.T) {
+	websocketport = "443"
+	gateway = "192.168.1.20:80"
+	if u := websocketURL(); u != "ws://192.168.1.20:443" {
+		t.Errorf("expected the websocket on the gateway host, got %s", u)
+	}
+	gateway = "gateway.local"
+	if u := websocketURL(); u != "ws://gateway.local:443" {
+		t.Errorf("expected the websocket on the gateway host, got %s", u)
+	}
+	gateway = "localhost:8080"
+}
+
+func TestPublish(t *testing.T) {
+	s := newEventStream()
+	first, second := s.subscribe("switch"), s.subscribe("switch")
+	other := s.subscribe("plug")
+	s.publish(gatewayEvent{UniqueID: "switch"})
+	receiveEvent(t, first)
+	receiveEvent(t, second)
+	select {
+	case <-other:
+		t.Errorf("expected no event for another uniqueid")
+	default:
+	}
+	// A full subscriber doesn't block the others
+	for i := 0; i <= eventBuffer; i++ {
+		s.publish(gatewayEvent{UniqueID: "switch"})
+	}
+	if len(first) != eventBuffer {
+		t.Errorf("expected %d buffered events, got %d", eventBuffer, len(first))
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	srv, connected, hangup := newWebsocketServer(t,
+		`{"t": "event", "e": "changed", "r": "sensors", "id": "5", "uniqueid": "switch", "state": {"buttonevent": 1002}}`,
+		`not json`,
+		`{"t": "event", "e": "changed", "r": "lights", "id": "1", "uniqueid": "plug", "state": {"on": true}}`,
+	)
+	defer srv.Close()
+	defer func() { gateway = "localhost:8080" }()
+	ctx, cancel := context.WithCancel(context.Background())
+	s := newEventStream()
+	ch := s.subscribe("switch")
+	s.start(ctx)
+	s.start(ctx) // Only one connection
+
+	ev := receiveEvent(t, ch)
+	if ev.Resource != "sensors" || ev.ID != "5" || string(ev.State) != `{"buttonevent": 1002}` || len(ev.Raw) == 0 {
+		t.Errorf("expected the button event, got %+v", ev)
+	}
+	if !s.isConnected() {
+		t.Errorf("expected the stream to be connected")
+	}
+	if len(connected) != 1 {
+		t.Errorf("expected a single connection, got %d", len(connected))
+	}
+
+	// Reconnects after losing the connection
+	hangup <- struct{}{}
+	receiveEvent(t, ch)
+
+	// Stops on shutdown, even while waiting for messages
+	cancel()
+	waitConnected(t, s, false)
+}
+
+func TestListenForEvents(t *testing.T) {
+	gateway = "localhost:8080"
+	ua := initTemplate().(*UnitAsset)
+	ua.Model = "ZHASwitch"
+	ua.Uniqueid = "14:ef:14:10:00:b2:b2:89-01"
+	ua.Slaves["Plug1"] = "34:ef:34:10:00:b2:b2:89-XX"
+	newMockTransport(&http.Response{StatusCode: 200, Body: http.NoBody}, false, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan gatewayEvent, 1)
+	done := make(chan struct{})
+	go func() {
+		ua.listenForEvents(ctx, ch)
+		close(done)
+	}()
+	ch <- gatewayEvent{UniqueID: ua.Uniqueid, Raw: []byte(`{"state": {"buttonevent": 1002}, "uniqueid": "14:ef:14:10:00:b2:b2:89-01"}`)}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected the switch to stop on shutdown")
+	}
+}
+
+func TestStartupSwitch(t *testing.T) {
+	srv, _, _ := newWebsocketServer(t)
+	defer srv.Close()
+	defer func() { gateway = "localhost:8080" }()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sys := components.NewSystem("ZigBeeHandler", ctx)
+	ua := initTemplate().(*UnitAsset)
+	ua.Owner = &sys
+	ua.Model = "ZHASwitch"
+	// A switch no longer dials the websocket itself (nor crashes the system if it can't)
+	events = newEventStream()
+	if err := ua.startup(); err != nil {
+		t.Errorf("expected no errors, got %v", err)
+	}
+	waitConnected(t, events, true)
+	cancel()
+	waitConnected(t, events, false)
+}
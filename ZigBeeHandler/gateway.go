@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sdoque/mbaigo/components"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// The gateway is found by trying different discovery strategies in order, so the system keeps working
+// without internet access when the deCONZ gateway is on the local network (or the same machine).
+const (
+	discoverConfig    string = "config"    // The "gateway" (host:port) set in systemconfig.json
+	discoverSSDP      string = "ssdp"      // UPnP/SSDP search on the local network
+	discoverMDNS      string = "mdns"      // Multicast DNS search on the local network
+	discoverLocalhost string = "localhost" // Probing the default ports on the same machine
+	discoverCloud     string = "cloud"     // The discover tool at phoscon.de (needs internet access)
+)
+
+// The order used if the configuration doesn't set any strategies
+var defaultDiscovery = []string{discoverConfig, discoverSSDP, discoverMDNS, discoverLocalhost, discoverCloud}
+
+// A discoveryFunc returns the host:port of a gateway, the configured gateway is used by the config strategy
+type discoveryFunc func(configured string) (string, error)
+
+// The available strategies, it's a variable so the tests can replace the network calls
+var discoveryStrategies = map[string]discoveryFunc{
+	discoverConfig:    discoverFromConfig,
+	discoverSSDP:      discoverFromSSDP,
+	discoverMDNS:      discoverFromMDNS,
+	discoverLocalhost: discoverFromLocalhost,
+	discoverCloud:     discoverFromCloud,
+}
+
+// How long to wait before trying all strategies again, when no gateway was found
+const discoveryRetry time.Duration = 10 * time.Second
+
+// How long to wait for answers from the local network
+const discoveryTimeout time.Duration = 3 * time.Second
+
+// The ports probed by the localhost strategy, deCONZ uses 80 by default but 8080 is common on a Raspberry Pi
+var localhostPorts = []int{80, 8080}
+
+var errNoGatewayConfig error = fmt.Errorf("no gateway configured")
+var errNotDeconz error = fmt.Errorf("not a deCONZ gateway")
+var errUnknownStrategy error = fmt.Errorf("unknown discovery strategy")
+
+// discoverGateway tries the strategies in order and saves the first gateway found,
+// returning the name of the strategy that found it
+func discoverGateway(configured string, strategies []string) (string, error) {
+	if len(strategies) < 1 {
+		strategies = defaultDiscovery
+	}
+	for _, s := range strategies {
+		find, found := discoveryStrategies[s]
+		if !found {
+			log.Printf("Gateway discovery: %s: %q\n", errUnknownStrategy, s)
+			continue
+		}
+		gw, err := find(configured)
+		if err != nil {
+			log.Printf("Gateway discovery using %s failed: %s\n", s, err)
+			continue
+		}
+		gateway = gw
+		return s, nil
+	}
+	return "", errMissingGateway
+}
+
+// gatewaySettings returns the first gateway and discovery strategies set by any of the unit assets
+func gatewaySettings(uacs []UnitAsset) (configured string, strategies []string) {
+	for _, uac := range uacs {
+		if configured == "" {
+			configured = uac.Gateway
+		}
+		if len(strategies) < 1 {
+			strategies = uac.Discovery
+		}
+	}
+	return
+}
+
+// waitForGateway keeps trying to discover the gateway until it's found, and adds it to the system details.
+// It returns false if the system was asked to shut down before a gateway was found.
+func waitForGateway(sys *components.System, uacs []UnitAsset) bool {
+	configured, strategies := gatewaySettings(uacs)
+	for {
+		strategy, err := discoverGateway(configured, strategies)
+		if err == nil {
+			log.Printf("Found gateway %s using %s\n", gateway, strategy)
+			if sys.Husk.Details == nil {
+				sys.Husk.Details = make(map[string][]string)
+			}
+			sys.Husk.Details["Gateway"] = []string{gateway}
+			sys.Husk.Details["GatewayDiscovery"] = []string{strategy}
+			return true
+		}
+		log.Printf("Error getting gateway, trying again in %s: %s\n", discoveryRetry, err)
+		select {
+		case <-sys.Sigs:
+			return false
+		case <-time.After(discoveryRetry):
+		}
+	}
+}
+
+func discoverFromConfig(configured string) (string, error) {
+	if configured == "" {
+		return "", errNoGatewayConfig
+	}
+	return configured, nil
+}
+
+func discoverFromCloud(string) (string, error) {
+	if err := findGateway(); err != nil {
+		return "", err
+	}
+	return gateway, nil
+}
+
+func discoverFromLocalhost(string) (string, error) {
+	for _, port := range localhostPorts {
+		host := fmt.Sprintf("localhost:%d", port)
+		if err := verifyGateway(host); err == nil {
+			return host, nil
+		}
+	}
+	return "", errMissingGateway
+}
+
+// Parts of the unauthenticated gateway config, which is used for making sure a host is running deCONZ
+type gatewayConfigJSON struct {
+	Name     string `json:"name"`
+	ModelID  string `json:"modelid"`
+	BridgeID string `json:"bridgeid"`
+}
+
+// verifyGateway makes sure that a host:port is a deCONZ gateway, and not some other device on the network
+func verifyGateway(host string) error {
+	client := http.Client{Transport: http.DefaultClient.Transport, Timeout: discoveryTimeout}
+	req, err := createGetRequest("http://" + host + "/api/config")
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > 299 {
+		return errStatusCode
+	}
+	var conf gatewayConfigJSON
+	if err := json.NewDecoder(resp.Body).Decode(&conf); err != nil {
+		return err
+	}
+	if conf.ModelID != "deCONZ" {
+		return errNotDeconz
+	}
+	return nil
+}
+
+// searchMulticast sends a query to a multicast address and hands each answer to parse, returning the first
+// host that is verified to be a deCONZ gateway
+func searchMulticast(addr *net.UDPAddr, query []byte, parse func(msg []byte, from net.Addr) (string, bool)) (string, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if _, err = conn.WriteTo(query, addr); err != nil {
+		return "", err
+	}
+	if err = conn.SetReadDeadline(time.Now().Add(discoveryTimeout)); err != nil {
+		return "", err
+	}
+	tried := make(map[string]bool)
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			break // Most likely the deadline, no more answers
+		}
+		host, ok := parse(buf[:n], from)
+		if !ok || tried[host] {
+			continue
+		}
+		tried[host] = true
+		if err := verifyGateway(host); err == nil {
+			return host, nil
+		}
+	}
+	return "", errMissingGateway
+}
+
+var ssdpAddr = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+
+const ssdpSearch string = "M-SEARCH * HTTP/1.1\r\n" +
+	"HOST: 239.255.255.250:1900\r\n" +
+	"MAN: \"ssdp:discover\"\r\n" +
+	"MX: 2\r\n" +
+	"ST: ssdp:all\r\n\r\n"
+
+func discoverFromSSDP(string) (string, error) {
+	return searchMulticast(ssdpAddr, []byte(ssdpSearch), parseSSDPResponse)
+}
+
+// parseSSDPResponse returns the host:port from the location of a SSDP answer, if it looks like a deCONZ gateway
+// (which adds a "GWID.phoscon.de" header, or announces itself as a Hue bridge)
+func parseSSDPResponse(msg []byte, _ net.Addr) (string, bool) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(msg)), nil)
+	if err != nil {
+		return "", false
+	}
+	resp.Body.Close()
+	if resp.Header.Get("GWID.phoscon.de") == "" && !strings.Contains(resp.Header.Get("Server"), "IpBridge") {
+		return "", false
+	}
+	u, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || u.Host == "" {
+		return "", false
+	}
+	return u.Host, true
+}
+
+var mdnsAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// deCONZ announces itself as a Hue compatible bridge
+const mdnsService string = "_hue._tcp.local."
+
+func discoverFromMDNS(string) (string, error) {
+	query, err := mdnsQuery(mdnsService)
+	if err != nil {
+		return "", err
+	}
+	return searchMulticast(mdnsAddr, query, parseMDNSResponse)
+}
+
+// mdnsQuery creates a mDNS question for the instances of a service
+func mdnsQuery(service string) ([]byte, error) {
+	name, err := dnsmessage.NewName(service)
+	if err != nil {
+		return nil, err
+	}
+	msg := dnsmessage.Message{
+		Questions: []dnsmessage.Question{{Name: name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}},
+	}
+	return msg.Pack()
+}
+
+// parseMDNSResponse returns the host:port from the SRV (and A) records of a mDNS answer. If the answer has
+// no A record for the target, the address that sent the answer is used instead.
+func parseMDNSResponse(msg []byte, from net.Addr) (string, bool) {
+	var m dnsmessage.Message
+	if err := m.Unpack(msg); err != nil || !m.Header.Response {
+		return "", false
+	}
+	var target string
+	var port uint16
+	ips := make(map[string]string)
+	for _, r := range append(m.Answers, m.Additionals...) {
+		switch body := r.Body.(type) {
+		case *dnsmessage.SRVResource:
+			target, port = body.Target.String(), body.Port
+		case *dnsmessage.AResource:
+			ips[r.Header.Name.String()] = net.IP(body.A[:]).String()
+		}
+	}
+	if port == 0 {
+		return "", false
+	}
+	ip, found := ips[target]
+	if !found {
+		udp, ok := from.(*net.UDPAddr)
+		if !ok {
+			return "", false
+		}
+		ip = udp.IP.String()
+	}
+	return net.JoinHostPort(ip, fmt.Sprint(port)), true
+}
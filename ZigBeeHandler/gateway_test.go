@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/sdoque/mbaigo/components"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestDiscoverGateway(t *testing.T) {
+	original := discoveryStrategies
+	defer func() { discoveryStrategies = original }()
+	var tried []string
+	fake := func(name, host string) discoveryFunc {
+		return func(string) (string, error) {
+			tried = append(tried, name)
+			if host == "" {
+				return "", errMissingGateway
+			}
+			return host, nil
+		}
+	}
+	discoveryStrategies = map[string]discoveryFunc{
+		discoverConfig:    discoverFromConfig,
+		discoverSSDP:      fake(discoverSSDP, ""),
+		discoverMDNS:      fake(discoverMDNS, ""),
+		discoverLocalhost: fake(discoverLocalhost, "localhost:80"),
+		discoverCloud:     fake(discoverCloud, "192.168.1.2:80"),
+	}
+
+	// The strategies are tried in order, until one finds a gateway
+	strategy, err := discoverGateway("", nil)
+	if err != nil || strategy != discoverLocalhost || gateway != "localhost:80" {
+		t.Errorf("expected localhost to find the gateway, got %s %s (%v)", strategy, gateway, err)
+	}
+	if strings.Join(tried, ",") != "ssdp,mdns,localhost" {
+		t.Errorf("expected the local strategies to be tried first, got %v", tried)
+	}
+	// An explicit gateway is always used first
+	strategy, err = discoverGateway("10.0.0.5:8080", nil)
+	if err != nil || strategy != discoverConfig || gateway != "10.0.0.5:8080" {
+		t.Errorf("expected the configured gateway, got %s %s (%v)", strategy, gateway, err)
+	}
+	// The order can be changed, and unknown strategies are skipped
+	strategy, _ = discoverGateway("", []string{"carrier pigeon", discoverCloud, discoverLocalhost})
+	if strategy != discoverCloud || gateway != "192.168.1.2:80" {
+		t.Errorf("expected the cloud to find the gateway, got %s %s", strategy, gateway)
+	}
+	// No gateway found
+	if _, err = discoverGateway("", []string{discoverSSDP}); !errors.Is(err, errMissingGateway) {
+		t.Errorf("expected errMissingGateway, got %v", err)
+	}
+	gateway = "localhost:8080"
+}
+
+func TestWaitForGateway(t *testing.T) {
+	original := discoveryStrategies
+	defer func() { discoveryStrategies = original }()
+	discoveryStrategies = map[string]discoveryFunc{discoverConfig: discoverFromConfig}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sys := components.NewSystem("ZigBeeHandler", ctx)
+	sys.Husk = &components.Husk{}
+	uacs := []UnitAsset{{Name: "a"}, {Name: "b", Gateway: "localhost:8080", Discovery: []string{discoverConfig}}}
+	if !waitForGateway(&sys, uacs) {
+		t.Fatalf("expected the gateway to be found")
+	}
+	if sys.Husk.Details["Gateway"][0] != "localhost:8080" || sys.Husk.Details["GatewayDiscovery"][0] != discoverConfig {
+		t.Errorf("expected the gateway in the system details, got %v", sys.Husk.Details)
+	}
+}
+
+func TestVerifyGateway(t *testing.T) {
+	resp := &http.Response{
+		Status:     "200 OK",
+		StatusCode: 200,
+		Body:       io.NopCloser(strings.NewReader(`{"name": "Phoscon-GW", "modelid": "deCONZ", "bridgeid": "00212EFFFF000000"}`)),
+	}
+	newMockTransport(resp, false, nil)
+	if err := verifyGateway("localhost:8080"); err != nil {
+		t.Errorf("expected a deCONZ gateway, got %v", err)
+	}
+	// Other Hue compatible bridges are ignored
+	resp.Body = io.NopCloser(strings.NewReader(`{"name": "Philips hue", "modelid": "BSB002"}`))
+	newMockTransport(resp, false, nil)
+	if err := verifyGateway("localhost:8080"); !errors.Is(err, errNotDeconz) {
+		t.Errorf("expected errNotDeconz, got %v", err)
+	}
+	resp.StatusCode = 404
+	resp.Body = io.NopCloser(strings.NewReader(``))
+	newMockTransport(resp, false, nil)
+	if err := verifyGateway("localhost:8080"); !errors.Is(err, errStatusCode) {
+		t.Errorf("expected errStatusCode, got %v", err)
+	}
+	newMockTransport(resp, false, errHTTP)
+	if err := verifyGateway("localhost:8080"); err == nil {
+		t.Errorf("expected an error when the host can't be reached")
+	}
+}
+
+func TestParseSSDPResponse(t *testing.T) {
+	deconz := "HTTP/1.1 200 OK\r\n" +
+		"CACHE-CONTROL: max-age=100\r\n" +
+		"LOCATION: http://192.168.1.20:80/description.xml\r\n" +
+		"SERVER: Linux/3.14.0 UPnP/1.0 IpBridge/1.26.0\r\n" +
+		"GWID.phoscon.de: 00212EFFFF000000\r\n" +
+		"ST: upnp:rootdevice\r\n\r\n"
+	if host, ok := parseSSDPResponse([]byte(deconz), nil); !ok || host != "192.168.1.20:80" {
+		t.Errorf("expected the gateway at 192.168.1.20:80, got %q", host)
+	}
+	tv := "HTTP/1.1 200 OK\r\n" +
+		"LOCATION: http://192.168.1.30:8008/ssdp/device-desc.xml\r\n" +
+		"SERVER: Linux/3.8 UPnP/1.0 Chromecast\r\n\r\n"
+	if _, ok := parseSSDPResponse([]byte(tv), nil); ok {
+		t.Errorf("expected other devices to be ignored")
+	}
+	if _, ok := parseSSDPResponse([]byte("garbage"), nil); ok {
+		t.Errorf("expected bad answers to be ignored")
+	}
+}
+
+func TestParseMDNSResponse(t *testing.T) {
+	query, err := mdnsQuery(mdnsService)
+	if err != nil {
+		t.Fatalf("expected a mDNS query, got %v", err)
+	}
+	var q dnsmessage.Message
+	if err = q.Unpack(query); err != nil || len(q.Questions) != 1 || q.Questions[0].Type != dnsmessage.TypePTR {
+		t.Errorf("expected a PTR question, got %+v (%v)", q.Questions, err)
+	}
+
+	answer := func(withA bool) []byte {
+		service := dnsmessage.MustNewName(mdnsService)
+		instance := dnsmessage.MustNewName("Phoscon-GW." + mdnsService)
+		target := dnsmessage.MustNewName("phoscon.local.")
+		m := dnsmessage.Message{
+			Header: dnsmessage.Header{Response: true},
+			Answers: []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: service, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET},
+				Body:   &dnsmessage.PTRResource{PTR: instance},
+			}},
+			Additionals: []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: instance, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET},
+				Body:   &dnsmessage.SRVResource{Target: target, Port: 80},
+			}},
+		}
+		if withA {
+			m.Additionals = append(m.Additionals, dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: target, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+				Body:   &dnsmessage.AResource{A: [4]byte{192, 168, 1, 20}},
+			})
+		}
+		b, err := m.Pack()
+		if err != nil {
+			t.Fatalf("expected a packed answer, got %v", err)
+		}
+		return b
+	}
+	from := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 99), Port: 5353}
+	if host, ok := parseMDNSResponse(answer(true), from); !ok || host != "192.168.1.20:80" {
+		t.Errorf("expected the gateway at 192.168.1.20:80, got %q", host)
+	}
+	// Without an A record the sender is used
+	if host, ok := parseMDNSResponse(answer(false), from); !ok || host != "192.168.1.99:80" {
+		t.Errorf("expected the gateway at 192.168.1.99:80, got %q", host)
+	}
+	// The query itself isn't an answer
+	if _, ok := parseMDNSResponse(query, from); ok {
+		t.Errorf("expected questions to be ignored")
+	}
+}
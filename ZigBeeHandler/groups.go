@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/sdoque/mbaigo/forms"
+	"github.com/sdoque/mbaigo/usecases"
+)
+
+// A switch puts its slaves in a group on the gateway at startup, so they're switched together by one request
+// instead of one by one (which is visible with many lights, and leaves them mixed if one of the requests fails).
+// The group is named after the switch so it's found again after a restart, and it's deleted at shutdown.
+// Scenes are stored in the group, and can be recalled by the switch or through the scene services.
+// https://dresden-elektronik.github.io/deconz-rest-doc/endpoints/groups/
+// https://dresden-elektronik.github.io/deconz-rest-doc/endpoints/scenes/
+
+// The gateway doesn't allow longer group names
+const maxGroupName int = 32
+
+var errGroupRejected error = fmt.Errorf("group change rejected by the gateway")
+var errMissingGroup error = fmt.Errorf("switch has no group")
+
+// switchGroup keeps track of the group holding the slaves of a switch
+type switchGroup struct {
+	mutex sync.Mutex
+	id    string // Id of the group on the gateway, empty if the switch doesn't have one
+	scene string // Id of the last scene recalled or stored through the switch
+}
+
+// get returns the id of the group, or an empty string if there's no group
+func (g *switchGroup) get() string {
+	if g == nil {
+		return "" // Unit assets that aren't made by newResource or initTemplate
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.id
+}
+
+// set changes the id of the group, the last scene belonged to the old group
+func (g *switchGroup) set(id string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.id, g.scene = id, ""
+}
+
+// lastScene returns the id of the last scene recalled or stored
+func (g *switchGroup) lastScene() string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.scene
+}
+
+// setScene notes the scene that was recalled or stored
+func (g *switchGroup) setScene(scene string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.scene = scene
+}
+
+// groupName returns the name of the group for the switch
+func (ua *UnitAsset) groupName() string {
+	name := "Arrowhead " + ua.Name
+	if len(name) > maxGroupName {
+		name = name[:maxGroupName]
+	}
+	return name
+}
+
+// createdID returns the id of a group or scene from the answer of the gateway when creating it
+func createdID(data []byte) (string, error) {
+	var answers configAnswerJSON
+	if err := json.Unmarshal(data, &answers); err != nil {
+		return "", fmt.Errorf("%w: %s", errGroupRejected, err)
+	}
+	for _, a := range answers {
+		if a.Error.Description != "" {
+			return "", fmt.Errorf("%w: %s", errGroupRejected, a.Error.Description)
+		}
+		var id string
+		if raw, found := a.Success["id"]; found && json.Unmarshal(raw, &id) == nil {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("%w: no id in the answer", errGroupRejected)
+}
+
+// slaveLights returns the ids the gateway uses for the slaves, sorted so the group doesn't change between restarts
+func (ua *UnitAsset) slaveLights() (ids []string, err error) {
+	var lights map[string]deviceJSON
+	if err = getJSON("http://"+gateway+"/api/"+apikey+"/lights", &lights); err != nil {
+		return nil, err
+	}
+	byUniqueID := make(map[string]string)
+	for id, d := range lights {
+		byUniqueID[d.UniqueID] = id
+	}
+	for name, uniqueid := range ua.Slaves {
+		id, found := byUniqueID[uniqueid]
+		if !found {
+			return nil, fmt.Errorf("%s: %w: %s", name, errMissingUniqueID, uniqueid)
+		}
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// syncGroup creates the group of the switch if it doesn't exist yet, and makes sure it holds the slaves
+func (ua *UnitAsset) syncGroup() error {
+	lights, err := ua.slaveLights()
+	if err != nil {
+		return err
+	}
+	apiURL := "http://" + gateway + "/api/" + apikey + "/groups"
+	var groups map[string]groupJSON
+	if err = getJSON(apiURL, &groups); err != nil {
+		return err
+	}
+	name := ua.groupName()
+	var id string
+	for gid, g := range groups {
+		if g.Name == name {
+			id = gid
+			break
+		}
+	}
+	if id == "" {
+		req, err := createPostRequest(fmt.Sprintf(`{"name": %q}`, name), apiURL)
+		if err != nil {
+			return err
+		}
+		data, err := sendRequest(req)
+		if err != nil {
+			return err
+		}
+		if id, err = createdID(data); err != nil {
+			return err
+		}
+	}
+	members, _ := json.Marshal(map[string][]string{"lights": lights}) // Can't fail for a list of strings
+	req, err := createPutRequest(string(members), apiURL+"/"+id)
+	if err != nil {
+		return err
+	}
+	if err = sendPutRequest(req); err != nil {
+		return err
+	}
+	ua.group.set(id)
+	return nil
+}
+
+// deleteGroup removes the group of the switch from the gateway
+func (ua *UnitAsset) deleteGroup() error {
+	id := ua.group.get()
+	if id == "" {
+		return nil
+	}
+	req, err := createDeleteRequest(fmt.Sprintf("http://%s/api/%s/groups/%s", gateway, apikey, id))
+	if err != nil {
+		return err
+	}
+	if err = sendPutRequest(req); err != nil {
+		return err
+	}
+	ua.group.set("")
+	return nil
+}
+
+// groupCleanup deletes the group of the switch when the system shuts down
+func (ua *UnitAsset) groupCleanup(ctx context.Context) {
+	<-ctx.Done()
+	if err := ua.deleteGroup(); err != nil {
+		log.Printf("Error deleting the group of %s: %s\n", ua.Name, err)
+	}
+}
+
+// setGroup sends the new state to all lights in the group at once
+func setGroup(group, payload string) error {
+	apiURL := fmt.Sprintf("http://%s/api/%s/groups/%s/action", gateway, apikey, group)
+	req, err := createPutRequest(payload, apiURL)
+	if err != nil {
+		return err
+	}
+	return sendPutRequest(req)
+}
+
+// A scene in a group on the gateway
+type sceneJSON struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// getScenes lists the scenes in the group of the switch, sorted by id
+func (ua *UnitAsset) getScenes() (scenes []sceneJSON, err error) {
+	group := ua.group.get()
+	if group == "" {
+		return nil, errMissingGroup
+	}
+	var found map[string]sceneJSON
+	if err = getJSON(fmt.Sprintf("http://%s/api/%s/groups/%s/scenes", gateway, apikey, group), &found); err != nil {
+		return nil, err
+	}
+	scenes = []sceneJSON{}
+	for id, s := range found {
+		s.ID = id
+		scenes = append(scenes, s)
+	}
+	slices.SortFunc(scenes, func(a, b sceneJSON) int {
+		x, _ := strconv.Atoi(a.ID)
+		y, _ := strconv.Atoi(b.ID)
+		return x - y
+	})
+	return scenes, nil
+}
+
+// storeScene saves the current state of the lights in the group as the scene, a new scene is created if the
+// scene doesn't have an id
+func (ua *UnitAsset) storeScene(s sceneJSON) (sceneJSON, error) {
+	group := ua.group.get()
+	if group == "" {
+		return s, errMissingGroup
+	}
+	apiURL := fmt.Sprintf("http://%s/api/%s/groups/%s/scenes", gateway, apikey, group)
+	if s.ID == "" {
+		req, err := createPostRequest(fmt.Sprintf(`{"name": %q}`, s.Name), apiURL)
+		if err != nil {
+			return s, err
+		}
+		data, err := sendRequest(req)
+		if err != nil {
+			return s, err
+		}
+		if s.ID, err = createdID(data); err != nil {
+			return s, err
+		}
+	}
+	req, err := createPutRequest("{}", apiURL+"/"+s.ID+"/store")
+	if err != nil {
+		return s, err
+	}
+	if err = sendPutRequest(req); err != nil {
+		return s, err
+	}
+	ua.group.setScene(s.ID)
+	return s, nil
+}
+
+// getScene returns the last scene recalled or stored in the group of the switch
+func (ua *UnitAsset) getScene() (f forms.SignalA_v1a, err error) {
+	scene, err := strconv.Atoi(ua.group.lastScene())
+	if err != nil {
+		return f, fmt.Errorf("no scene recalled yet: %w", err)
+	}
+	return getForm(float64(scene), "Scene"), nil
+}
+
+// setScene recalls the scene with the id of the form value in the group of the switch
+func (ua *UnitAsset) setScene(f forms.SignalA_v1a) error {
+	group := ua.group.get()
+	if group == "" {
+		return errMissingGroup
+	}
+	scene := strconv.Itoa(int(f.Value))
+	if err := recallScene(group, scene); err != nil {
+		return err
+	}
+	ua.group.setScene(scene)
+	return nil
+}
+
+// Function used by the webhandler to get the last scene of a switch (GET) or recall a scene (PUT)
+func (rsc *UnitAsset) scene(w http.ResponseWriter, r *http.Request) {
+	if !rsc.supports("scene") {
+		http.Error(w, "That device doesn't support that method.", http.StatusInternalServerError)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		f, err := rsc.getScene()
+		if err != nil {
+			http.Error(w, "Failed getting data, or data not present", http.StatusInternalServerError)
+			return
+		}
+		usecases.HTTPProcessGetRequest(w, r, &f)
+	case "PUT":
+		sig, err := usecases.HTTPProcessSetRequest(w, r)
+		if err != nil {
+			http.Error(w, "Request incorrectly formatted", http.StatusBadRequest)
+			return
+		}
+		if err := rsc.setScene(sig); err != nil {
+			log.Printf("Error recalling a scene for %s: %s\n", rsc.Name, err)
+			http.Error(w, "Something went wrong when recalling the scene", http.StatusInternalServerError)
+		}
+	default:
+		http.Error(w, "Method is not supported", http.StatusNotFound)
+	}
+}
+
+// Function used by the webhandler to list the scenes of a switch (GET) or store the state of its lights in a scene (PUT)
+func (rsc *UnitAsset) scenes(w http.ResponseWriter, r *http.Request) {
+	if !rsc.supports("scenes") {
+		http.Error(w, "That device doesn't support that method.", http.StatusInternalServerError)
+		return
+	}
+	var body any
+	switch r.Method {
+	case "GET":
+		scenes, err := rsc.getScenes()
+		if err != nil {
+			http.Error(w, "Failed getting data, or data not present", http.StatusInternalServerError)
+			return
+		}
+		body = scenes
+	case "PUT":
+		var s sceneJSON
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil || (s.ID == "" && s.Name == "") {
+			http.Error(w, "Request incorrectly formatted", http.StatusBadRequest)
+			return
+		}
+		stored, err := rsc.storeScene(s)
+		if err != nil {
+			log.Printf("Error storing a scene for %s: %s\n", rsc.Name, err)
+			http.Error(w, "Something went wrong when storing the scene", http.StatusInternalServerError)
+			return
+		}
+		body = stored
+	default:
+		http.Error(w, "Method is not supported", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Error encoding the scenes: %s\n", err)
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sdoque/mbaigo/forms"
+)
+
+// groupGateway keeps groups and scenes like the gateway does, and remembers the changes as "METHOD path body"
+type groupGateway struct {
+	mutex    sync.Mutex
+	groups   map[string]string // Id to name
+	reject   bool              // Rejects new groups and scenes
+	requests []string
+}
+
+func (g *groupGateway) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := ""
+	if req.Body != nil {
+		b, _ := io.ReadAll(req.Body)
+		body = string(b)
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	path := strings.TrimPrefix(req.URL.Path, "/api/1234")
+	answer := "[]"
+	switch {
+	case req.Method == http.MethodGet && path == "/lights":
+		answer = `{
+			"1": {"name": "Lamp 1", "type": "Dimmable light", "uniqueid": "lamp-1"},
+			"2": {"name": "Lamp 2", "type": "Dimmable light", "uniqueid": "lamp-2"},
+			"3": {"name": "Plug", "type": "Smart plug", "uniqueid": "plug"}
+		}`
+	case req.Method == http.MethodGet && path == "/groups":
+		groups := make(map[string]groupJSON)
+		for id, name := range g.groups {
+			groups[id] = groupJSON{Name: name}
+		}
+		b, _ := json.Marshal(groups)
+		answer = string(b)
+	case req.Method == http.MethodGet && strings.HasSuffix(path, "/scenes"):
+		answer = `{"2": {"name": "Evening", "lights": ["1", "2"]}, "1": {"name": "Morning", "lights": ["1", "2"]}}`
+	case req.Method == http.MethodPost && g.reject:
+		answer = `[{"error": {"type": 7, "address": "` + path + `", "description": "invalid value"}}]`
+	case req.Method == http.MethodPost && path == "/groups":
+		g.groups["7"] = "created"
+		answer = `[{"success": {"id": "7"}}]`
+	case req.Method == http.MethodPost:
+		answer = `[{"success": {"id": "3"}}]`
+	case req.Method == http.MethodDelete:
+		delete(g.groups, strings.TrimPrefix(path, "/groups/"))
+	}
+	if req.Method != http.MethodGet {
+		g.requests = append(g.requests, req.Method+" "+path+" "+body)
+	}
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: 200,
+		Body:       io.NopCloser(strings.NewReader(answer)),
+		Request:    req,
+	}, nil
+}
+
+func (g *groupGateway) take() []string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	r := g.requests
+	g.requests = nil
+	return r
+}
+
+// newTestSwitch returns a switch with two lamps as slaves, using a groupGateway
+func newTestSwitch(t *testing.T) (*UnitAsset, *groupGateway) {
+	t.Helper()
+	ua := newTestAsset(t, "Switch", "ZHASwitch")
+	g := &groupGateway{groups: map[string]string{"1": "Kitchen"}}
+	http.DefaultClient.Transport = g
+	ua.Slaves = map[string]string{"Lamp2": "lamp-2", "Lamp1": "lamp-1"}
+	return ua, g
+}
+
+func TestCreatedID(t *testing.T) {
+	table := []struct {
+		answer string
+		id     string
+		err    error
+	}{
+		{`[{"success": {"id": "5"}}]`, "5", nil},
+		{`[{"error": {"type": 3, "address": "/groups", "description": "resource not available"}}]`, "", errGroupRejected},
+		{`[{"success": {"/groups/5/name": "test"}}]`, "", errGroupRejected},
+		{`[]`, "", errGroupRejected},
+		{`{"test": "test ok"}`, "", errGroupRejected},
+	}
+	for _, test := range table {
+		id, err := createdID([]byte(test.answer))
+		if id != test.id || !errors.Is(err, test.err) {
+			t.Errorf("expected %q (%v) for %s, got %q (%v)", test.id, test.err, test.answer, id, err)
+		}
+	}
+}
+
+func TestGroupName(t *testing.T) {
+	ua := initTemplate().(*UnitAsset)
+	ua.Name = "Switch"
+	if name := ua.groupName(); name != "Arrowhead Switch" {
+		t.Errorf("expected Arrowhead Switch, got %s", name)
+	}
+	ua.Name = strings.Repeat("x", 40)
+	if name := ua.groupName(); len(name) != maxGroupName {
+		t.Errorf("expected the name to be cut to %d characters, got %d", maxGroupName, len(name))
+	}
+}
+
+func TestSyncGroup(t *testing.T) {
+	ua, g := newTestSwitch(t)
+
+	// Creates the group and puts the slaves in it
+	if err := ua.syncGroup(); err != nil {
+		t.Fatalf("expected no errors, got %v", err)
+	}
+	want := []string{
+		`POST /groups {"name": "Arrowhead Switch"}`,
+		`PUT /groups/7 {"lights":["1","2"]}`,
+	}
+	if got := g.take(); !slices.Equal(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if id := ua.group.get(); id != "7" {
+		t.Errorf("expected group 7, got %q", id)
+	}
+
+	// Uses the group again after a restart
+	g.groups["7"] = "Arrowhead Switch"
+	ua.group.set("")
+	if err := ua.syncGroup(); err != nil {
+		t.Fatalf("expected no errors, got %v", err)
+	}
+	want = []string{`PUT /groups/7 {"lights":["1","2"]}`}
+	if got := g.take(); !slices.Equal(got, want) || ua.group.get() != "7" {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	// A slave that isn't on the gateway
+	ua.group.set("")
+	ua.Slaves["Lamp3"] = "lamp-3"
+	if err := ua.syncGroup(); !errors.Is(err, errMissingUniqueID) {
+		t.Errorf("expected %v, got %v", errMissingUniqueID, err)
+	}
+	delete(ua.Slaves, "Lamp3")
+
+	// The gateway refuses to create the group
+	delete(g.groups, "7")
+	g.reject = true
+	if err := ua.syncGroup(); !errors.Is(err, errGroupRejected) || ua.group.get() != "" {
+		t.Errorf("expected %v, got %v", errGroupRejected, err)
+	}
+
+	// The gateway can't be reached
+	newMockTransport(nil, false, fmt.Errorf("Test error"))
+	if err := ua.syncGroup(); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestDeleteGroup(t *testing.T) {
+	ua, g := newTestSwitch(t)
+	if err := ua.deleteGroup(); err != nil || len(g.take()) != 0 {
+		t.Errorf("expected nothing to delete, got %v", err)
+	}
+	if err := ua.syncGroup(); err != nil {
+		t.Fatalf("expected no errors, got %v", err)
+	}
+	g.take()
+
+	// The group is deleted at shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		ua.groupCleanup(ctx)
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected the group to be deleted at shutdown")
+	}
+	want := []string{"DELETE /groups/7 "}
+	if got := g.take(); !slices.Equal(got, want) || ua.group.get() != "" {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if _, found := g.groups["7"]; found {
+		t.Errorf("expected the group to be gone from the gateway")
+	}
+
+	ua.group.set("7")
+	newMockTransport(nil, false, fmt.Errorf("Test error"))
+	if err := ua.deleteGroup(); err == nil || ua.group.get() != "7" {
+		t.Errorf("expected an error and the group to be kept")
+	}
+}
+
+func TestSetTargetsGroup(t *testing.T) {
+	ua, g := newTestSwitch(t)
+	if err := ua.syncGroup(); err != nil {
+		t.Fatalf("expected no errors, got %v", err)
+	}
+	g.take()
+
+	// The slaves are switched together by the group
+	if err := ua.setTargets(nil, `{"on":true}`); err != nil {
+		t.Errorf("expected no errors, got %v", err)
+	}
+	want := []string{`PUT /groups/7/action {"on":true}`}
+	if got := g.take(); !slices.Equal(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	// Targets are still set one by one
+	if err := ua.setTargets([]string{"plug"}, `{"on":true}`); err != nil {
+		t.Errorf("expected no errors, got %v", err)
+	}
+	want = []string{`PUT /lights/plug/state {"on":true}`}
+	if got := g.take(); !slices.Equal(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	// A scene action without a group uses the group of the slaves
+	if _, err := ua.runAction("2002", ButtonAction{Action: actionScene, Scene: "2"}, false); err != nil {
+		t.Errorf("expected no errors, got %v", err)
+	}
+	want = []string{`PUT /groups/7/scenes/2/recall {}`}
+	if got := g.take(); !slices.Equal(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	ua.group.set("")
+	if _, err := ua.runAction("2002", ButtonAction{Action: actionScene, Scene: "2"}, false); !errors.Is(err, errMissingScene) {
+		t.Errorf("expected %v, got %v", errMissingScene, err)
+	}
+}
+
+func TestSceneServices(t *testing.T) {
+	ua, g := newTestSwitch(t)
+	request := func(method, service, body string) *http.Response {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, "http://localhost:8870/ZigBeeHandler/Switch/"+service, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		ua.Serving(w, r, service)
+		return w.Result()
+	}
+
+	// Not supported without a group
+	for _, service := range []string{"scene", "scenes"} {
+		if resp := request("GET", service, ""); resp.StatusCode != http.StatusInternalServerError {
+			t.Errorf("expected %s to be unsupported, got %d", service, resp.StatusCode)
+		}
+	}
+	if err := ua.syncGroup(); err != nil {
+		t.Fatalf("expected no errors, got %v", err)
+	}
+	g.take()
+
+	// Nothing has been recalled yet
+	if resp := request("GET", "scene", ""); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected status 500 before a scene is recalled, got %d", resp.StatusCode)
+	}
+	var scenes []sceneJSON
+	resp := request("GET", "scenes", "")
+	if err := json.NewDecoder(resp.Body).Decode(&scenes); err != nil || len(scenes) != 2 || scenes[0] != (sceneJSON{"1", "Morning"}) {
+		t.Errorf("expected the scenes sorted by id, got %+v (%v)", scenes, err)
+	}
+
+	// Recalls a scene
+	body := `{"value": 2, "unit": "Scene", "version": "SignalA_v1.0"}`
+	if resp = request("PUT", "scene", body); resp.StatusCode != good_code {
+		t.Errorf("expected the scene to be recalled, got %d", resp.StatusCode)
+	}
+	want := []string{`PUT /groups/7/scenes/2/recall {}`}
+	if got := g.take(); !slices.Equal(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	var f forms.SignalA_v1a
+	resp = request("GET", "scene", "")
+	if err := json.NewDecoder(resp.Body).Decode(&f); err != nil || f.Value != 2 {
+		t.Errorf("expected the last scene, got %v (%v)", f.Value, err)
+	}
+	if resp = request("PUT", "scene", "not json"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a bad form, got %d", resp.StatusCode)
+	}
+
+	// Stores the lights in an existing and a new scene
+	var s sceneJSON
+	resp = request("PUT", "scenes", `{"id": "1"}`)
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil || s.ID != "1" {
+		t.Errorf("expected the scene to be stored, got %+v (%v)", s, err)
+	}
+	resp = request("PUT", "scenes", `{"name": "Night"}`)
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil || s != (sceneJSON{"3", "Night"}) {
+		t.Errorf("expected a new scene to be stored, got %+v (%v)", s, err)
+	}
+	want = []string{
+		`PUT /groups/7/scenes/1/store {}`,
+		`POST /groups/7/scenes {"name": "Night"}`,
+		`PUT /groups/7/scenes/3/store {}`,
+	}
+	if got := g.take(); !slices.Equal(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if ua.group.lastScene() != "3" {
+		t.Errorf("expected the stored scene to be the last one, got %q", ua.group.lastScene())
+	}
+	for _, body := range []string{"not json", "{}"} {
+		if resp = request("PUT", "scenes", body); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status 400 for %s, got %d", body, resp.StatusCode)
+		}
+	}
+	g.reject = true
+	if resp = request("PUT", "scenes", `{"name": "Night"}`); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected status 500 when the gateway rejects the scene, got %d", resp.StatusCode)
+	}
+	for _, service := range []string{"scene", "scenes"} {
+		if resp = request("POST", service, ""); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("expected status 404 for POST on %s, got %d", service, resp.StatusCode)
+		}
+	}
+
+	// The gateway can't be reached
+	newMockTransport(nil, false, fmt.Errorf("Test error"))
+	if resp = request("GET", "scenes", ""); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", resp.StatusCode)
+	}
+	if resp = request("PUT", "scene", body); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", resp.StatusCode)
+	}
+}
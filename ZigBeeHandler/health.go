@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sdoque/mbaigo/forms"
+	"github.com/sdoque/mbaigo/usecases"
+)
+
+// The gateway keeps track of when it last heard from each device ("lastseen"), if it can reach it ("reachable")
+// and the battery of wireless devices. Some devices stops responding without the gateway noticing (it keeps
+// them reachable), so a device that hasn't been heard from in a while is flagged as stale. Other systems can
+// check the health of a device before acting on it, and the collector can keep track of it over time.
+
+// How long a device can go without being heard from before it's stale, battery powered sensors reports at
+// least every hour
+const staleAfter time.Duration = time.Hour
+
+// Battery level (percent) below which the battery is low, for devices that doesn't report it themselves
+const lowBatteryLevel float64 = 10
+
+var errUnknownModel error = fmt.Errorf("unknown device model")
+
+// DeviceHealth shows if a device is working
+type DeviceHealth struct {
+	Name       string    `json:"name"`
+	Model      string    `json:"model"`
+	Reachable  bool      `json:"reachable"`         // If the gateway can reach the device
+	LastSeen   time.Time `json:"lastseen"`          // When the gateway last heard from the device, zero if unknown
+	Battery    *float64  `json:"battery,omitempty"` // Percent, only for battery powered devices
+	LowBattery bool      `json:"lowbattery"`
+	Stale      bool      `json:"stale"` // Not heard from within the stale time
+}
+
+// healthy checks if the device is reachable, not stale and doesn't need a new battery
+func (h DeviceHealth) healthy() bool {
+	return h.Reachable && !h.Stale && !h.LowBattery
+}
+
+// HealthSummary shows the health of all devices handled by the system
+type HealthSummary struct {
+	Devices    int            `json:"devices"`
+	Reachable  int            `json:"reachable"`
+	Stale      int            `json:"stale"`
+	LowBattery int            `json:"lowbattery"`
+	Unhealthy  []DeviceHealth `json:"unhealthy"` // The devices that are unreachable, stale or low on battery, by name
+	Checked    time.Time      `json:"checked"`
+}
+
+// attribute reads the key from the first of the attributes that has it into v
+func attribute(key string, v any, attributes ...map[string]json.RawMessage) bool {
+	for _, a := range attributes {
+		if raw, found := a[key]; found {
+			return json.Unmarshal(raw, v) == nil
+		}
+	}
+	return false
+}
+
+// getHealth returns the health of the device, from the cache if it's fresh or else from the gateway
+func (ua *UnitAsset) getHealth() (h DeviceHealth, err error) {
+	h = DeviceHealth{Name: ua.Name, Model: ua.Model}
+	state, config, lastSeen, ok := deviceCache.snapshot(ua.Uniqueid)
+	if !ok {
+		resource := deviceModels[ua.Model].Resource
+		if resource == "" {
+			return h, fmt.Errorf("%w: %s", errUnknownModel, ua.Model)
+		}
+		var r resourceJSON
+		if err = getJSON("http://"+gateway+"/api/"+apikey+"/"+resource+"/"+ua.Uniqueid, &r); err != nil {
+			return h, err
+		}
+		lastSeen, _ = parseLastSeen(r.LastSeen)
+		deviceCache.merge(ua.Uniqueid, r.State, r.Config)
+		deviceCache.seen(ua.Uniqueid, lastSeen)
+		state, config = r.State, r.Config
+	}
+	// Lights has it in the state, sensors in the config
+	attribute("reachable", &h.Reachable, state, config)
+	var battery float64
+	if attribute("battery", &battery, config, state) {
+		h.Battery = &battery
+		h.LowBattery = battery < lowBatteryLevel
+	}
+	var low bool
+	if attribute("lowbattery", &low, state) && low {
+		h.LowBattery = true
+	}
+	h.LastSeen = lastSeen
+	if h.LastSeen.IsZero() {
+		// Older gateways doesn't have "lastseen", but sensors has the time of their last report
+		h.LastSeen = lastUpdated(&cacheEntry{state: state})
+	}
+	if h.LastSeen.IsZero() {
+		h.Stale = !h.Reachable // Nothing else to go by
+	} else {
+		h.Stale = time.Since(h.LastSeen) > staleAfter
+	}
+	return h, nil
+}
+
+// getHealthSignal returns a part of the health of the device as a form, for the services the collector can sample
+func (ua *UnitAsset) getHealthSignal(service string) (f forms.SignalA_v1a, err error) {
+	h, err := ua.getHealth()
+	if err != nil {
+		return
+	}
+	binary := func(b bool) float64 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	switch service {
+	case "reachable":
+		f = getForm(binary(h.Reachable), "Binary")
+	case "stale":
+		f = getForm(binary(h.Stale), "Binary")
+	case "lastseen":
+		if h.LastSeen.IsZero() {
+			return f, fmt.Errorf("%w: lastseen", errMissingAttribute)
+		}
+		f = getForm(time.Since(h.LastSeen).Round(time.Second).Seconds(), "Seconds")
+		f.Timestamp = h.LastSeen
+	default:
+		return f, fmt.Errorf("%w: %s", errBadFormValue, service)
+	}
+	return f, nil
+}
+
+// getHealthSummary collects the health of all devices in the system. A device that can't be checked counts
+// as unreachable and stale.
+func (ua *UnitAsset) getHealthSummary() (s HealthSummary) {
+	s.Unhealthy = []DeviceHealth{}
+	for _, a := range ua.Owner.UAssets { // The map isn't changed once the http server is started
+		other, ok := (*a).(*UnitAsset)
+		if !ok {
+			continue
+		}
+		h, err := other.getHealth()
+		if err != nil {
+			log.Printf("Error checking the health of %s: %s\n", other.Name, err)
+			h.Reachable, h.Stale = false, true
+		}
+		s.Devices++
+		if h.Reachable {
+			s.Reachable++
+		}
+		if h.Stale {
+			s.Stale++
+		}
+		if h.LowBattery {
+			s.LowBattery++
+		}
+		if !h.healthy() {
+			s.Unhealthy = append(s.Unhealthy, h)
+		}
+	}
+	slices.SortFunc(s.Unhealthy, func(a, b DeviceHealth) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	s.Checked = time.Now()
+	return s
+}
+
+// Function used by the webhandler to get the health of a device
+func (rsc *UnitAsset) health(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		h, err := rsc.getHealth()
+		if err != nil {
+			http.Error(w, "Failed getting data, or data not present", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(h); err != nil {
+			log.Printf("Error encoding the device health: %s\n", err)
+		}
+	default:
+		http.Error(w, "Method is not supported", http.StatusNotFound)
+	}
+}
+
+// Function used by the webhandler to get if a device is reachable, stale or when it was last seen
+func (rsc *UnitAsset) healthSignal(w http.ResponseWriter, r *http.Request, service string) {
+	switch r.Method {
+	case "GET":
+		f, err := rsc.getHealthSignal(service)
+		if err != nil {
+			http.Error(w, "Failed getting data, or data not present", http.StatusInternalServerError)
+			return
+		}
+		usecases.HTTPProcessGetRequest(w, r, &f)
+	default:
+		http.Error(w, "Method is not supported", http.StatusNotFound)
+	}
+}
+
+// Function used by the webhandler to get the health of all devices handled by the system
+func (rsc *UnitAsset) healthSummary(w http.ResponseWriter, r *http.Request) {
+	if !rsc.supports("summary") {
+		http.Error(w, "That device doesn't support that method.", http.StatusInternalServerError)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(rsc.getHealthSummary()); err != nil {
+			log.Printf("Error encoding the health summary: %s\n", err)
+		}
+	default:
+		http.Error(w, "Method is not supported", http.StatusNotFound)
+	}
+}
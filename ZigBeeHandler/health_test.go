@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sdoque/mbaigo/components"
+	"github.com/sdoque/mbaigo/forms"
+)
+
+// newTestDevice returns a unit asset for a device with the attributes and last seen time in the cache
+func newTestDevice(t *testing.T, c *stateCache, name, model, state, config string, lastSeen time.Time) *UnitAsset {
+	t.Helper()
+	ua := newTestAsset(t, name, model)
+	var s, cfg map[string]json.RawMessage
+	json.Unmarshal([]byte(state), &s)
+	json.Unmarshal([]byte(config), &cfg)
+	c.merge(name, s, cfg)
+	c.seen(name, lastSeen)
+	return ua
+}
+
+func TestGetHealth(t *testing.T) {
+	c := useTestCache(t)
+	now := time.Now().UTC()
+	recent := now.Add(-time.Minute).Format("2006-01-02T15:04:05")
+	battery := func(b float64) *float64 { return &b }
+	table := []struct {
+		name, model, state, config string
+		lastSeen                   time.Time
+		want                       DeviceHealth
+	}{
+		{"lamp", "Dimmable light", `{"on": true, "reachable": true}`, `{}`, now,
+			DeviceHealth{Reachable: true}},
+		{"lost", "Smart plug", `{"on": true, "reachable": true}`, `{}`, now.Add(-2 * time.Hour),
+			DeviceHealth{Reachable: true, Stale: true}},
+		{"sensor", "ZHATemperature", `{"temperature": 2000}`, `{"reachable": true, "battery": 80}`, now,
+			DeviceHealth{Reachable: true, Battery: battery(80)}},
+		{"empty", "ZHATemperature", `{"temperature": 2000}`, `{"reachable": true, "battery": 5}`, now,
+			DeviceHealth{Reachable: true, Battery: battery(5), LowBattery: true}},
+		{"flagged", "ZHASwitch", `{"lowbattery": true}`, `{"reachable": true, "battery": 50}`, now,
+			DeviceHealth{Reachable: true, Battery: battery(50), LowBattery: true}},
+		// Without "lastseen" the last report of a sensor is used
+		{"old", "ZHAPresence", `{"presence": false, "lastupdated": "` + recent + `"}`, `{"reachable": true}`, time.Time{},
+			DeviceHealth{Reachable: true}},
+		{"unknown", "Smart plug", `{"on": false, "reachable": false}`, `{}`, time.Time{},
+			DeviceHealth{Stale: true}},
+	}
+	for _, test := range table {
+		ua := newTestDevice(t, c, test.name, test.model, test.state, test.config, test.lastSeen)
+		h, err := ua.getHealth()
+		if err != nil {
+			t.Errorf("%s: expected no errors, got %v", test.name, err)
+			continue
+		}
+		if h.Name != test.name || h.Model != test.model || h.Reachable != test.want.Reachable || h.Stale != test.want.Stale ||
+			h.LowBattery != test.want.LowBattery || (h.Battery == nil) != (test.want.Battery == nil) ||
+			(h.Battery != nil && *h.Battery != *test.want.Battery) {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.want, h)
+		}
+		if !test.lastSeen.IsZero() && !h.LastSeen.Equal(test.lastSeen) {
+			t.Errorf("%s: expected to be seen at %v, got %v", test.name, test.lastSeen, h.LastSeen)
+		}
+	}
+}
+
+func TestGetHealthFromGateway(t *testing.T) {
+	gateway = "localhost:8080"
+	apikey = "1234"
+	useTestCache(t)
+	http.DefaultClient.Transport = pathTransport{map[string]string{
+		"/api/1234/sensors/sensor": `{"uniqueid": "sensor", "lastseen": "2025-01-02T03:04Z", "state": {"temperature": 2000}, "config": {"reachable": true, "battery": 90}}`,
+	}}
+	ua := newTestAsset(t, "Sensor", "ZHATemperature")
+	h, err := ua.getHealth()
+	if err != nil || !h.Reachable || !h.Stale || h.Battery == nil || *h.Battery != 90 ||
+		!h.LastSeen.Equal(time.Date(2025, 1, 2, 3, 4, 0, 0, time.UTC)) {
+		t.Errorf("expected the health from the gateway, got %+v (%v)", h, err)
+	}
+	// It's in the cache now
+	if _, _, seen, ok := deviceCache.snapshot("sensor"); !ok || !seen.Equal(h.LastSeen) {
+		t.Errorf("expected the sensor to be cached, got %v (%t)", seen, ok)
+	}
+
+	ua.Uniqueid = "missing"
+	if _, err = ua.getHealth(); err == nil {
+		t.Errorf("expected an error for a device that isn't on the gateway")
+	}
+	ua.Model = "test"
+	if _, err = ua.getHealth(); !errors.Is(err, errUnknownModel) {
+		t.Errorf("expected %v, got %v", errUnknownModel, err)
+	}
+}
+
+func TestHealthServices(t *testing.T) {
+	c := useTestCache(t)
+	ua := newTestDevice(t, c, "lamp", "Dimmable light", `{"on": true, "reachable": true}`, `{}`, time.Now().Add(-time.Minute))
+	request := func(method, service string) *http.Response {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, "http://localhost:8870/ZigBeeHandler/lamp/"+service, nil)
+		ua.Serving(w, r, service)
+		return w.Result()
+	}
+
+	var h DeviceHealth
+	resp := request("GET", "health")
+	if err := json.NewDecoder(resp.Body).Decode(&h); err != nil || !h.Reachable || h.Stale || h.Battery != nil {
+		t.Errorf("expected a healthy lamp, got %+v (%v)", h, err)
+	}
+	table := []struct {
+		service string
+		value   float64
+		unit    string
+	}{
+		{"reachable", 1, "Binary"},
+		{"stale", 0, "Binary"},
+		{"lastseen", 60, "Seconds"},
+	}
+	for _, test := range table {
+		var f forms.SignalA_v1a
+		resp = request("GET", test.service)
+		if err := json.NewDecoder(resp.Body).Decode(&f); err != nil || f.Value != test.value || f.Unit != test.unit {
+			t.Errorf("expected %v %s for %s, got %v %s (%v)", test.value, test.unit, test.service, f.Value, f.Unit, err)
+		}
+	}
+	if resp = request("GET", "summary"); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected status 500 for the summary of a device that isn't the first, got %d", resp.StatusCode)
+	}
+	ua.systemAsset = true
+	for _, service := range []string{"health", "reachable", "summary"} {
+		if resp = request("PUT", service); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("expected status 404 for PUT on %s, got %d", service, resp.StatusCode)
+		}
+	}
+
+	// Nothing known about when the lamp was last seen
+	ua = newTestDevice(t, c, "other", "Dimmable light", `{"on": true, "reachable": true}`, `{}`, time.Time{})
+	if resp = request("GET", "lastseen"); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected status 500 without a last seen time, got %d", resp.StatusCode)
+	}
+	if _, err := ua.getHealthSignal("battery"); !errors.Is(err, errBadFormValue) {
+		t.Errorf("expected %v, got %v", errBadFormValue, err)
+	}
+
+	// The device can't be checked
+	ua.Model = "test"
+	ua.Uniqueid = "missing"
+	for _, service := range []string{"health", "reachable"} {
+		if resp = request("GET", service); resp.StatusCode != http.StatusInternalServerError {
+			t.Errorf("expected status 500 for %s, got %d", service, resp.StatusCode)
+		}
+	}
+}
+
+func TestHealthSummary(t *testing.T) {
+	c := useTestCache(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sys := components.NewSystem("ZigBeeHandler", ctx)
+	sys.UAssets = make(map[string]*components.UnitAsset)
+	now := time.Now()
+	devices := []*UnitAsset{
+		newTestDevice(t, c, "lamp", "Dimmable light", `{"reachable": true}`, `{}`, now),
+		newTestDevice(t, c, "sensor", "ZHATemperature", `{}`, `{"reachable": true, "battery": 5}`, now),
+		newTestDevice(t, c, "plug", "Smart plug", `{"reachable": false}`, `{}`, now.Add(-2*time.Hour)),
+		newTestDevice(t, c, "broken", "test", `{}`, `{}`, now),
+	}
+	for _, d := range devices {
+		var ua components.UnitAsset = d
+		d.Owner = &sys
+		sys.UAssets[d.Name] = &ua
+	}
+	devices[0].systemAsset = true
+	w := httptest.NewRecorder()
+	devices[0].Serving(w, httptest.NewRequest("GET", "http://localhost:8870/ZigBeeHandler/lamp/summary", nil), "summary")
+	var s HealthSummary
+	if err := json.NewDecoder(w.Result().Body).Decode(&s); err != nil {
+		t.Fatalf("expected a summary, got %v", err)
+	}
+	if s.Devices != 4 || s.Reachable != 2 || s.Stale != 2 || s.LowBattery != 1 {
+		t.Errorf("expected 4 devices with 2 reachable, 2 stale and 1 low battery, got %+v", s)
+	}
+	var names []string
+	for _, h := range s.Unhealthy {
+		names = append(names, h.Name)
+	}
+	if strings.Join(names, ",") != "broken,plug,sensor" {
+		t.Errorf("expected the unhealthy devices sorted by name, got %v", names)
+	}
+}
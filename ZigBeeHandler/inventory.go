@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/sdoque/mbaigo/components"
+)
+
+// The inventory lists all supported devices from the gateway and creates unit assets for them, so they
+// don't have to be added by hand in systemconfig.json. Devices that are already in the configuration
+// are left alone, so hand-tuned unit assets keeps their settings. The inventory is only taken at startup,
+// before the services are registered and the http server is started, since unit assets added later wouldn't
+// be registered. Newly paired devices are picked up at the next restart.
+
+// How often (in seconds) the thermostats found by the inventory checks that they use the setpoint
+const thermostatPeriod time.Duration = 60
+
+// Parts of a light or sensor from the gateway
+type deviceJSON struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	UniqueID string `json:"uniqueid"`
+	Config   struct {
+		HeatSetpoint float64 `json:"heatsetpoint"`
+	} `json:"config"`
+}
+
+// Parts of a group from the gateway, the group names are used as locations
+type groupJSON struct {
+	Name             string   `json:"name"`
+	Lights           []string `json:"lights"`
+	DeviceMembership []string `json:"devicemembership"`
+}
+
+// getJSON sends a get request to the gateway API and unmarshals the response into v
+func getJSON(apiURL string, v any) error {
+	req, err := createGetRequest(apiURL)
+	if err != nil {
+		return err
+	}
+	data, err := sendGetRequest(req)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// getInventory lists all devices on the gateway that are in the device registry as unit asset configurations, sorted by name
+func getInventory() (uacs []UnitAsset, err error) {
+	apiURL := "http://" + gateway + "/api/" + apikey
+	var lights, sensors map[string]deviceJSON
+	var groups map[string]groupJSON
+	if err = getJSON(apiURL+"/lights", &lights); err != nil {
+		return nil, fmt.Errorf("lights: %w", err)
+	}
+	if err = getJSON(apiURL+"/sensors", &sensors); err != nil {
+		return nil, fmt.Errorf("sensors: %w", err)
+	}
+	if err = getJSON(apiURL+"/groups", &groups); err != nil {
+		return nil, fmt.Errorf("groups: %w", err)
+	}
+
+	// Devices in a group (or switches controlling a group) are located where the group is
+	locations := make(map[string]string)
+	for _, g := range groups {
+		for _, id := range g.Lights {
+			locations["lights/"+id] = g.Name
+		}
+		for _, id := range g.DeviceMembership {
+			locations["sensors/"+id] = g.Name
+		}
+	}
+	for id, d := range lights {
+		if model, found := modelOfType(d.Type, "lights"); found {
+			uacs = append(uacs, newInventoryAsset(d, model, locations["lights/"+id]))
+		}
+	}
+	for id, d := range sensors {
+		if model, found := modelOfType(d.Type, "sensors"); found {
+			uacs = append(uacs, newInventoryAsset(d, model, locations["sensors/"+id]))
+		}
+	}
+	sort.Slice(uacs, func(i, j int) bool {
+		return uacs[i].Name < uacs[j].Name
+	})
+	return uacs, nil
+}
+
+// Characters that can't be used in a unit asset name, since it's part of the service URLs
+var badNameChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// newInventoryAsset creates a unit asset configuration for a device
+func newInventoryAsset(d deviceJSON, model, location string) UnitAsset {
+	uac := UnitAsset{
+		Name:     badNameChars.ReplaceAllString(d.Name, "_"),
+		Details:  map[string][]string{},
+		Model:    model,
+		Uniqueid: d.UniqueID,
+		Slaves:   map[string]string{},
+	}
+	if uac.Name == "" {
+		uac.Name = model
+	}
+	if location != "" {
+		uac.Details["Location"] = []string{location}
+	}
+	if model == "ZHAThermostat" {
+		// Keeps the current setpoint, instead of sending a default one at startup
+		uac.Setpt = d.Config.HeatSetpoint / 100
+		uac.Period = thermostatPeriod
+	}
+	return uac
+}
+
+// inventoryEnabled checks if any of the configured unit assets asks for the inventory
+func inventoryEnabled(uacs []UnitAsset) bool {
+	for _, uac := range uacs {
+		if uac.Inventory {
+			return true
+		}
+	}
+	return false
+}
+
+// An inventory keeps track of the devices that already has a unit asset
+type inventory struct {
+	sys   *components.System
+	servs []components.Service
+	known map[string]bool // Uniqueids of the devices with unit assets
+	names map[string]bool // Names of the unit assets
+}
+
+// newInventory creates an inventory that knows about the configured unit assets
+func newInventory(sys *components.System, servs []components.Service, uacs []UnitAsset) *inventory {
+	inv := &inventory{
+		sys:   sys,
+		servs: servs,
+		known: make(map[string]bool),
+		names: make(map[string]bool),
+	}
+	for _, uac := range uacs {
+		inv.known[uac.Uniqueid] = true
+		inv.names[uac.Name] = true
+	}
+	return inv
+}
+
+// uniqueName adds a number to the name if another unit asset already uses it
+func (inv *inventory) uniqueName(name string) string {
+	unique := name
+	for i := 2; inv.names[unique]; i++ {
+		unique = fmt.Sprintf("%s_%d", name, i)
+	}
+	return unique
+}
+
+// scan creates and starts unit assets for all new devices on the gateway
+func (inv *inventory) scan() error {
+	found, err := getInventory()
+	if err != nil {
+		return err
+	}
+	for _, uac := range found {
+		if inv.known[uac.Uniqueid] {
+			continue
+		}
+		uac.Name = inv.uniqueName(uac.Name)
+		ua, startup := newResource(uac, inv.sys, inv.servs)
+		if err := startup(); err != nil {
+			log.Printf("Error during startup of %s, skipping it until the next restart: %s\n", uac.Name, err)
+			continue
+		}
+		inv.known[uac.Uniqueid] = true
+		inv.names[uac.Name] = true
+		inv.add(ua)
+		log.Printf("Found %s (%s) with uniqueid %s\n", uac.Name, uac.Model, uac.Uniqueid)
+	}
+	return nil
+}
+
+// add puts a new unit asset in the system. It's only used at startup, the map of unit assets must not
+// be changed once the http server is reading it.
+func (inv *inventory) add(ua components.UnitAsset) {
+	inv.sys.UAssets[ua.GetName()] = &ua
+}
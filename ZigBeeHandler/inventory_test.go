@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/sdoque/mbaigo/components"
+)
+
+// pathTransport answers requests with the body set for the path, or 404 if it's missing
+type pathTransport struct {
+	bodies map[string]string
+}
+
+func (t pathTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, found := t.bodies[req.URL.Path]
+	status := http.StatusOK
+	if !found {
+		status = http.StatusNotFound
+	}
+	return &http.Response{
+		Status:     http.StatusText(status),
+		StatusCode: status,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+var inventoryExample = map[string]string{
+	"/api/1234/lights": `{
+		"1": {"name": "Kitchen plug", "type": "Smart plug", "uniqueid": "14:ef:14:10:00:00:00:01-01"},
+		"2": {"name": "Hallway lamp", "type": "Dimmable light", "uniqueid": "14:ef:14:10:00:00:00:02-01"},
+		"3": {"name": "Repeater", "type": "Range extender", "uniqueid": "14:ef:14:10:00:00:00:03-01"}
+	}`,
+	"/api/1234/sensors": `{
+		"1": {"name": "Thermostat", "type": "ZHAThermostat", "uniqueid": "14:ef:14:10:00:00:00:04-01-0201", "config": {"heatsetpoint": 2150}},
+		"2": {"name": "Switch", "type": "ZHASwitch", "uniqueid": "14:ef:14:10:00:00:00:05-01-1000"},
+		"3": {"name": "Consumption 1", "type": "ZHAConsumption", "uniqueid": "14:ef:14:10:00:00:00:01-01-0702"},
+		"4": {"name": "Bedroom temperature", "type": "ZHATemperature", "uniqueid": "00:15:8d:00:00:00:00:06-01-0402"}
+	}`,
+	"/api/1234/groups": `{
+		"1": {"name": "Kitchen", "lights": ["1"], "devicemembership": ["2"]},
+		"2": {"name": "Hallway", "lights": ["2"], "devicemembership": []}
+	}`,
+	"/api/1234/sensors/14:ef:14:10:00:00:00:04-01-0201/config": `[{"success": {"/sensors/1/config/heatsetpoint": 2150}}]`,
+	"/api/1234/lights/14:ef:14:10:00:00:00:02-01":              `{"type": "Dimmable light", "state": {"on": false, "bri": 100}}`,
+}
+
+func TestGetInventory(t *testing.T) {
+	gateway = "localhost:8080"
+	apikey = "1234"
+	http.DefaultClient.Transport = pathTransport{inventoryExample}
+
+	uacs, err := getInventory()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// The range extender and the power sensors aren't supported
+	if len(uacs) != 5 {
+		t.Fatalf("expected 5 devices, got %d: %+v", len(uacs), uacs)
+	}
+	byName := make(map[string]UnitAsset)
+	for _, uac := range uacs {
+		byName[uac.Name] = uac
+	}
+	plug := byName["Kitchen_plug"]
+	if plug.Model != "Smart plug" || plug.Details["Location"][0] != "Kitchen" {
+		t.Errorf("expected a smart plug in the kitchen, got %+v", plug)
+	}
+	if lamp := byName["Hallway_lamp"]; lamp.Model != "Dimmable light" || lamp.Details["Location"][0] != "Hallway" {
+		t.Errorf("expected a dimmable light in the hallway, got %+v", lamp)
+	}
+	if thermostat := byName["Thermostat"]; thermostat.Model != "ZHAThermostat" || thermostat.Setpt != 21.5 || thermostat.Period != thermostatPeriod {
+		t.Errorf("expected a thermostat with the current setpoint, got %+v", thermostat)
+	}
+	if sensor := byName["Bedroom_temperature"]; sensor.Model != "ZHATemperature" {
+		t.Errorf("expected a temperature sensor, got %+v", sensor)
+	}
+	if sw := byName["Switch"]; sw.Model != "ZHASwitch" || sw.Details["Location"][0] != "Kitchen" {
+		t.Errorf("expected a switch controlling the kitchen, got %+v", sw)
+	}
+
+	// Errors from the gateway
+	http.DefaultClient.Transport = pathTransport{map[string]string{}}
+	if _, err = getInventory(); err == nil {
+		t.Errorf("expected an error when the gateway doesn't answer")
+	}
+}
+
+func TestInventoryScan(t *testing.T) {
+	gateway = "localhost:8080"
+	websocketport = "443"
+	apikey = "1234"
+	// Without the switch, which would start listening to the websocket
+	bodies := make(map[string]string)
+	for path, body := range inventoryExample {
+		bodies[path] = body
+	}
+	bodies["/api/1234/sensors"] = `{
+		"1": {"name": "Thermostat", "type": "ZHAThermostat", "uniqueid": "14:ef:14:10:00:00:00:04-01-0201", "config": {"heatsetpoint": 2150}}
+	}`
+	http.DefaultClient.Transport = pathTransport{bodies}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sys := components.NewSystem("ZigBeeHandler", ctx)
+	sys.Husk = &components.Husk{ProtoPort: map[string]int{"http": 8870}}
+
+	// The plug is already configured by hand, with another name
+	configured := []UnitAsset{{Name: "HeaterPlug", Model: "Smart plug", Uniqueid: "14:ef:14:10:00:00:00:01-01", Inventory: true}}
+	if !inventoryEnabled(configured) {
+		t.Errorf("expected the inventory to be enabled")
+	}
+	inv := newInventory(&sys, nil, configured)
+	inv.names["Thermostat"] = true // Another unit asset uses the name
+	if err := inv.scan(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, found := sys.UAssets["Kitchen_plug"]; found {
+		t.Errorf("expected the configured plug to be kept as it is")
+	}
+	for _, name := range []string{"Hallway_lamp", "Thermostat_2"} {
+		if _, found := sys.UAssets[name]; !found {
+			t.Errorf("expected a new unit asset called %s, got %v", name, sys.UAssets)
+		}
+	}
+	// Nothing new the second time
+	count := len(sys.UAssets)
+	if err := inv.scan(); err != nil || len(sys.UAssets) != count {
+		t.Errorf("expected no new unit assets, got %d (%v)", len(sys.UAssets), err)
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sdoque/mbaigo/forms"
+	"github.com/sdoque/mbaigo/usecases"
+)
+
+// Lights can support more than turning on and off, depending on their type. What a light supports is detected
+// at startup from its deCONZ type and the attributes in its state, so a light with an unknown type still works.
+// https://dresden-elektronik.github.io/deconz-rest-doc/endpoints/lights/
+
+// lightCapabilities lists what a light supports, besides turning on and off
+type lightCapabilities struct {
+	Brightness bool `json:"brightness"`
+	ColorTemp  bool `json:"colortemp"`
+	HueSat     bool `json:"huesat"`
+	XY         bool `json:"xy"`
+}
+
+// detectCapabilities combines the capabilities of the light type with the attributes found in its state
+func detectCapabilities(lightType string, state map[string]json.RawMessage) lightCapabilities {
+	c := deviceModels[lightType].Light
+	has := func(key string) bool {
+		_, found := state[key]
+		return found
+	}
+	c.Brightness = c.Brightness || has("bri")
+	c.ColorTemp = c.ColorTemp || has("ct")
+	c.HueSat = c.HueSat || (has("hue") && has("sat"))
+	c.XY = c.XY || has("xy")
+	return c
+}
+
+// Parts of a light from the gateway
+type lightJSON struct {
+	Type  string                     `json:"type"`
+	State map[string]json.RawMessage `json:"state"`
+}
+
+var errMissingAttribute error = fmt.Errorf("attribute missing from the light state")
+var errOutOfRange error = fmt.Errorf("value out of range")
+
+// getLight fetches the type and state of the light from the gateway, the state is saved in the cache too
+func (ua *UnitAsset) getLight() (light lightJSON, err error) {
+	err = getJSON("http://"+gateway+"/api/"+apikey+"/lights/"+ua.Uniqueid, &light)
+	if err == nil {
+		deviceCache.merge(ua.Uniqueid, light.State, nil)
+	}
+	return
+}
+
+// detectLight finds out what the light supports
+func (ua *UnitAsset) detectLight() error {
+	light, err := ua.getLight()
+	if err != nil {
+		return err
+	}
+	ua.capabilities = detectCapabilities(light.Type, light.State)
+	return nil
+}
+
+// lightValue reads an attribute from the state of the light into v, from the cache if it's fresh or else
+// from the gateway, and returns when it was last updated
+func (ua *UnitAsset) lightValue(key string, v any) (time.Time, error) {
+	raw, updated, ok := deviceCache.lookup(ua.Uniqueid, key)
+	if !ok {
+		light, err := ua.getLight()
+		if err != nil {
+			return updated, err
+		}
+		if raw, ok = light.State[key]; !ok {
+			return updated, fmt.Errorf("%w: %s", errMissingAttribute, key)
+		}
+		updated = time.Now()
+	}
+	return updated, json.Unmarshal(raw, v)
+}
+
+// setLightState sends a new state to the light, using the transition time of the unit asset
+func (ua *UnitAsset) setLightState(state map[string]any) error {
+	if seconds := ua.transition.get(); seconds > 0 {
+		state["transitiontime"] = transitionTime(seconds)
+	}
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	req, err := createPutRequest(string(payload), "http://"+gateway+"/api/"+apikey+"/lights/"+ua.Uniqueid+"/state")
+	if err != nil {
+		return err
+	}
+	return sendPutRequest(req)
+}
+
+// lightTransition keeps track of the transition time of a light
+type lightTransition struct {
+	mutex   sync.Mutex
+	seconds float64
+}
+
+// get returns the transition time in seconds, or 0 if it isn't set
+func (t *lightTransition) get() float64 {
+	if t == nil {
+		return 0 // Unit assets that aren't made by newResource or initTemplate
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.seconds
+}
+
+// set changes the transition time in seconds
+func (t *lightTransition) set(seconds float64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.seconds = seconds
+}
+
+// transitionTime converts seconds to the tenths of seconds used by the gateway
+func transitionTime(seconds float64) int {
+	return int(math.Round(seconds * 10))
+}
+
+// scale converts a value from one range to another and rounds it, it's clamped to the new range
+func scale(value, fromMax, toMax float64) int {
+	return int(math.Round(math.Max(0, math.Min(toMax, value/fromMax*toMax))))
+}
+
+// getBrightness returns the brightness in percent, which is 0 when the light is off
+func (ua *UnitAsset) getBrightness() (f forms.SignalA_v1a, err error) {
+	var on bool
+	if _, err = ua.lightValue("on", &on); err != nil {
+		return
+	}
+	var bri float64
+	updated, err := ua.lightValue("bri", &bri)
+	if err != nil {
+		return
+	}
+	f = getForm(0, "Percent")
+	if on {
+		f.Value = math.Round(bri / 254 * 100)
+	}
+	f.Timestamp = updated
+	return f, nil
+}
+
+// setBrightness sets the brightness in percent, 0 turns the light off
+func (ua *UnitAsset) setBrightness(f forms.SignalA_v1a) error {
+	if f.Value < 0 || f.Value > 100 {
+		return errOutOfRange
+	}
+	if f.Value == 0 {
+		return ua.setLightState(map[string]any{"on": false})
+	}
+	return ua.setLightState(map[string]any{"on": true, "bri": max(1, scale(f.Value, 100, 254))})
+}
+
+// getColorTemp returns the color temperature in Kelvin
+func (ua *UnitAsset) getColorTemp() (f forms.SignalA_v1a, err error) {
+	var ct float64
+	updated, err := ua.lightValue("ct", &ct)
+	if err != nil {
+		return
+	}
+	if ct <= 0 {
+		return f, errOutOfRange
+	}
+	f = getForm(math.Round(1e6/ct), "Kelvin")
+	f.Timestamp = updated
+	return f, nil
+}
+
+// setColorTemp sets the color temperature in Kelvin, which the gateway wants in mireds
+func (ua *UnitAsset) setColorTemp(f forms.SignalA_v1a) error {
+	if f.Value < 1000 || f.Value > 10000 {
+		return errOutOfRange
+	}
+	return ua.setLightState(map[string]any{"ct": int(math.Round(1e6 / f.Value))})
+}
+
+// getHue returns the hue in degrees
+func (ua *UnitAsset) getHue() (f forms.SignalA_v1a, err error) {
+	var hue float64
+	updated, err := ua.lightValue("hue", &hue)
+	if err != nil {
+		return
+	}
+	f = getForm(math.Round(hue/65535*360), "Degrees")
+	f.Timestamp = updated
+	return f, nil
+}
+
+// setHue sets the hue in degrees (0-360)
+func (ua *UnitAsset) setHue(f forms.SignalA_v1a) error {
+	if f.Value < 0 || f.Value > 360 {
+		return errOutOfRange
+	}
+	return ua.setLightState(map[string]any{"hue": scale(f.Value, 360, 65535)})
+}
+
+// getSaturation returns the color saturation in percent
+func (ua *UnitAsset) getSaturation() (f forms.SignalA_v1a, err error) {
+	var sat float64
+	updated, err := ua.lightValue("sat", &sat)
+	if err != nil {
+		return
+	}
+	f = getForm(math.Round(sat/254*100), "Percent")
+	f.Timestamp = updated
+	return f, nil
+}
+
+// setSaturation sets the color saturation in percent
+func (ua *UnitAsset) setSaturation(f forms.SignalA_v1a) error {
+	if f.Value < 0 || f.Value > 100 {
+		return errOutOfRange
+	}
+	return ua.setLightState(map[string]any{"sat": scale(f.Value, 100, 254)})
+}
+
+// ColorXY is a color in the CIE xy color space
+type ColorXY struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// getXY returns the color of the light in the CIE xy color space
+func (ua *UnitAsset) getXY() (c ColorXY, err error) {
+	var xy [2]float64
+	if _, err = ua.lightValue("xy", &xy); err != nil {
+		return
+	}
+	return ColorXY{X: xy[0], Y: xy[1]}, nil
+}
+
+// setXY sets the color of the light in the CIE xy color space
+func (ua *UnitAsset) setXY(c ColorXY) error {
+	if c.X < 0 || c.X > 1 || c.Y < 0 || c.Y > 1 {
+		return errOutOfRange
+	}
+	return ua.setLightState(map[string]any{"xy": [2]float64{c.X, c.Y}})
+}
+
+// getTransition returns the transition time used when changing the light, in seconds
+func (ua *UnitAsset) getTransition() (f forms.SignalA_v1a) {
+	return getForm(ua.transition.get(), "Seconds")
+}
+
+// setTransition sets the transition time used when changing the light, in seconds
+func (ua *UnitAsset) setTransition(f forms.SignalA_v1a) error {
+	if f.Value < 0 {
+		return errOutOfRange
+	}
+	ua.transition.set(f.Value)
+	return nil
+}
+
+// lightSignal handles the GET and PUT requests of the light services that uses signal forms. The services
+// are only supported by lights that has the capability.
+func (rsc *UnitAsset) lightSignal(w http.ResponseWriter, r *http.Request, supported bool,
+	get func() (forms.SignalA_v1a, error), set func(forms.SignalA_v1a) error) {
+	if r.Method != "GET" && r.Method != "PUT" {
+		http.Error(w, "Method is not supported", http.StatusNotFound)
+		return
+	}
+	if !supported {
+		http.Error(w, "That device doesn't support that method.", http.StatusInternalServerError)
+		return
+	}
+	if r.Method == "GET" {
+		f, err := get()
+		if err != nil {
+			http.Error(w, "Failed getting data, or data not present", http.StatusInternalServerError)
+			return
+		}
+		usecases.HTTPProcessGetRequest(w, r, &f)
+		return
+	}
+	sig, err := usecases.HTTPProcessSetRequest(w, r)
+	if err != nil {
+		http.Error(w, "Request incorrectly formatted", http.StatusBadRequest)
+		return
+	}
+	if err = set(sig); err != nil {
+		http.Error(w, "Something went wrong when setting the light", http.StatusBadRequest)
+	}
+}
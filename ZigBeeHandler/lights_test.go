@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sdoque/mbaigo/forms"
+)
+
+func TestDetectCapabilities(t *testing.T) {
+	state := func(keys ...string) map[string]json.RawMessage {
+		s := map[string]json.RawMessage{"on": json.RawMessage("true")}
+		for _, k := range keys {
+			s[k] = json.RawMessage("1")
+		}
+		return s
+	}
+	table := []struct {
+		lightType string
+		state     map[string]json.RawMessage
+		want      lightCapabilities
+	}{
+		{"On/Off light", state(), lightCapabilities{}},
+		{"Smart plug", state(), lightCapabilities{}},
+		{"Dimmable light", state(), lightCapabilities{Brightness: true}},
+		{"Color temperature light", state("bri", "ct"), lightCapabilities{Brightness: true, ColorTemp: true}},
+		{"Extended color light", state(), lightCapabilities{Brightness: true, ColorTemp: true, HueSat: true, XY: true}},
+		// Unknown types are detected from the state
+		{"Some new light", state("bri", "hue", "sat", "xy"), lightCapabilities{Brightness: true, HueSat: true, XY: true}},
+		{"Some new light", state("hue"), lightCapabilities{}},
+	}
+	for _, test := range table {
+		if got := detectCapabilities(test.lightType, test.state); got != test.want {
+			t.Errorf("expected %+v for %s %v, got %+v", test.want, test.lightType, test.state, got)
+		}
+	}
+}
+
+func TestScale(t *testing.T) {
+	table := []struct {
+		value, fromMax, toMax float64
+		want                  int
+	}{
+		{50, 100, 254, 127},
+		{100, 100, 254, 254},
+		{150, 100, 254, 254},
+		{-5, 100, 254, 0},
+		{180, 360, 65535, 32768},
+	}
+	for _, test := range table {
+		if got := scale(test.value, test.fromMax, test.toMax); got != test.want {
+			t.Errorf("expected %d for %v, got %d", test.want, test.value, got)
+		}
+	}
+}
+
+func TestLightGetters(t *testing.T) {
+	ua := newTestAsset(t, "Lamp", "Extended color light")
+	c := useTestCache(t)
+	c.merge("lamp", map[string]json.RawMessage{
+		"on":  json.RawMessage("true"),
+		"bri": json.RawMessage("127"),
+		"ct":  json.RawMessage("370"),
+		"hue": json.RawMessage("21845"),
+		"sat": json.RawMessage("254"),
+		"xy":  json.RawMessage("[0.4, 0.35]"),
+	}, nil)
+	newMockTransport(nil, false, errHTTP) // Everything is read from the cache
+
+	table := []struct {
+		get  func() (forms.SignalA_v1a, error)
+		want float64
+		unit string
+	}{
+		{ua.getBrightness, 50, "Percent"},
+		{ua.getColorTemp, 2703, "Kelvin"},
+		{ua.getHue, 120, "Degrees"},
+		{ua.getSaturation, 100, "Percent"},
+	}
+	for _, test := range table {
+		f, err := test.get()
+		if err != nil || f.Value != test.want || f.Unit != test.unit {
+			t.Errorf("expected %v %s, got %v %s (%v)", test.want, test.unit, f.Value, f.Unit, err)
+		}
+	}
+	if xy, err := ua.getXY(); err != nil || xy.X != 0.4 || xy.Y != 0.35 {
+		t.Errorf("expected the xy color, got %+v (%v)", xy, err)
+	}
+	// The brightness of a light that is off is 0
+	c.merge("lamp", map[string]json.RawMessage{"on": json.RawMessage("false")}, nil)
+	if f, _ := ua.getBrightness(); f.Value != 0 {
+		t.Errorf("expected 0 when the light is off, got %v", f.Value)
+	}
+	// Bad color temperature
+	c.merge("lamp", map[string]json.RawMessage{"ct": json.RawMessage("0")}, nil)
+	if _, err := ua.getColorTemp(); !errors.Is(err, errOutOfRange) {
+		t.Errorf("expected errOutOfRange, got %v", err)
+	}
+}
+
+func TestLightValueFromGateway(t *testing.T) {
+	ua := newTestAsset(t, "Lamp", "Extended color light")
+	c := useTestCache(t)
+	http.DefaultClient.Transport = pathTransport{map[string]string{
+		"/api/1234/lights/lamp": `{"type": "Color temperature light", "state": {"on": true, "bri": 254, "ct": 250}}`,
+	}}
+	if f, err := ua.getBrightness(); err != nil || f.Value != 100 {
+		t.Errorf("expected 100 from the gateway, got %v (%v)", f.Value, err)
+	}
+	if _, _, ok := c.number("lamp", "ct"); !ok {
+		t.Errorf("expected the state from the gateway to be cached")
+	}
+	c.devices = map[string]*cacheEntry{}
+	if _, err := ua.getHue(); !errors.Is(err, errMissingAttribute) {
+		t.Errorf("expected errMissingAttribute, got %v", err)
+	}
+
+	// Detection at startup
+	websocketport = "443"
+	if err := ua.startup(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if ua.capabilities != (lightCapabilities{Brightness: true, ColorTemp: true}) {
+		t.Errorf("expected a color temperature light, got %+v", ua.capabilities)
+	}
+	for _, service := range []string{"hue", "saturation", "xy"} {
+		if _, found := ua.ServicesMap[service]; found {
+			t.Errorf("expected the %s service to be dropped", service)
+		}
+	}
+	http.DefaultClient.Transport = pathTransport{map[string]string{}}
+	if err := ua.startup(); err == nil {
+		t.Errorf("expected an error when the light can't be found")
+	}
+}
+
+func TestLightSetters(t *testing.T) {
+	ua := newTestAsset(t, "Lamp", "Extended color light")
+	tr := &recordTransport{}
+	http.DefaultClient.Transport = tr
+	signal := func(v float64) forms.SignalA_v1a {
+		return getForm(v, "")
+	}
+	table := []struct {
+		set  func(forms.SignalA_v1a) error
+		v    float64
+		want string
+	}{
+		{ua.setBrightness, 50, `{"bri":127,"on":true}`},
+		{ua.setBrightness, 0.1, `{"bri":1,"on":true}`},
+		{ua.setBrightness, 0, `{"on":false}`},
+		{ua.setColorTemp, 2700, `{"ct":370}`},
+		{ua.setHue, 360, `{"hue":65535}`},
+		{ua.setSaturation, 50, `{"sat":127}`},
+	}
+	for _, test := range table {
+		if err := test.set(signal(test.v)); err != nil {
+			t.Errorf("expected no error for %v, got %v", test.v, err)
+		}
+		want := "PUT /api/1234/lights/lamp/state " + test.want
+		if got := tr.take(); len(got) != 1 || got[0] != want {
+			t.Errorf("expected %s, got %v", want, got)
+		}
+	}
+	if err := ua.setXY(ColorXY{X: 0.5, Y: 0.25}); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if got := tr.take(); len(got) != 1 || !strings.HasSuffix(got[0], `{"xy":[0.5,0.25]}`) {
+		t.Errorf("expected the xy color, got %v", got)
+	}
+
+	// The transition time is added to all changes
+	if err := ua.setTransition(signal(1.5)); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if f := ua.getTransition(); f.Value != 1.5 || f.Unit != "Seconds" {
+		t.Errorf("expected 1.5 Seconds, got %v %s", f.Value, f.Unit)
+	}
+	if err := ua.toggleState(true); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if got := tr.take(); len(got) != 1 || !strings.HasSuffix(got[0], `{"on":true,"transitiontime":15}`) {
+		t.Errorf("expected the transition time, got %v", got)
+	}
+
+	// Out of range
+	bad := []struct {
+		set func(forms.SignalA_v1a) error
+		v   float64
+	}{
+		{ua.setBrightness, 101}, {ua.setBrightness, -1}, {ua.setColorTemp, 500}, {ua.setHue, 361},
+		{ua.setSaturation, -1}, {ua.setTransition, -1},
+	}
+	for _, test := range bad {
+		if err := test.set(signal(test.v)); !errors.Is(err, errOutOfRange) {
+			t.Errorf("expected errOutOfRange for %v, got %v", test.v, err)
+		}
+	}
+	if err := ua.setXY(ColorXY{X: 2}); !errors.Is(err, errOutOfRange) {
+		t.Errorf("expected errOutOfRange, got %v", err)
+	}
+	if len(tr.take()) != 0 {
+		t.Errorf("expected nothing to be sent for bad values")
+	}
+}
+
+func TestLightServices(t *testing.T) {
+	ua := newTestAsset(t, "Lamp", "Extended color light")
+	c := useTestCache(t)
+	c.merge("lamp", map[string]json.RawMessage{"on": json.RawMessage("true"), "bri": json.RawMessage("254"), "xy": json.RawMessage("[0.1, 0.2]")}, nil)
+	tr := &recordTransport{}
+	http.DefaultClient.Transport = tr
+	request := func(method, service, body string) *http.Response {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, "http://localhost:8870/ZigBeeHandler/Lamp/"+service, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		ua.Serving(w, r, service)
+		return w.Result()
+	}
+
+	// Not supported until the light has been detected
+	for _, service := range []string{"brightness", "colortemp", "hue", "saturation", "xy", "transition"} {
+		if resp := request("GET", service, ""); resp.StatusCode != http.StatusInternalServerError {
+			t.Errorf("expected %s to be unsupported, got %d", service, resp.StatusCode)
+		}
+	}
+	ua.capabilities = lightCapabilities{Brightness: true, XY: true}
+
+	resp := request("GET", "brightness", "")
+	var f forms.SignalA_v1a
+	if err := json.NewDecoder(resp.Body).Decode(&f); err != nil || resp.StatusCode != good_code || f.Value != 100 {
+		t.Errorf("expected the brightness, got %d %v (%v)", resp.StatusCode, f.Value, err)
+	}
+	body := `{"value": 50, "unit": "Percent", "version": "SignalA_v1.0"}`
+	if resp = request("PUT", "brightness", body); resp.StatusCode != good_code || len(tr.take()) != 1 {
+		t.Errorf("expected the brightness to be set, got %d", resp.StatusCode)
+	}
+	if resp = request("PUT", "brightness", `{"value": 500, "unit": "Percent", "version": "SignalA_v1.0"}`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a bad value, got %d", resp.StatusCode)
+	}
+	if resp = request("PUT", "brightness", "not json"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a bad form, got %d", resp.StatusCode)
+	}
+	if resp = request("POST", "brightness", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404 for POST, got %d", resp.StatusCode)
+	}
+	if resp = request("PUT", "transition", `{"value": 2, "unit": "Seconds", "version": "SignalA_v1.0"}`); resp.StatusCode != good_code || ua.transition.get() != 2 {
+		t.Errorf("expected the transition to be set, got %d %v", resp.StatusCode, ua.transition.get())
+	}
+	if resp = request("GET", "transition", ""); resp.StatusCode != good_code {
+		t.Errorf("expected the transition, got %d", resp.StatusCode)
+	}
+
+	// The xy service uses plain JSON
+	var xy ColorXY
+	resp = request("GET", "xy", "")
+	if err := json.NewDecoder(resp.Body).Decode(&xy); err != nil || xy.X != 0.1 || xy.Y != 0.2 {
+		t.Errorf("expected the xy color, got %+v (%v)", xy, err)
+	}
+	if resp = request("PUT", "xy", `{"x": 0.3, "y": 0.3}`); resp.StatusCode != good_code || len(tr.take()) != 1 {
+		t.Errorf("expected the xy color to be set, got %d", resp.StatusCode)
+	}
+	for _, body := range []string{"not json", `{"x": 3}`} {
+		if resp = request("PUT", "xy", body); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status 400 for %s, got %d", body, resp.StatusCode)
+		}
+	}
+	if resp = request("DELETE", "xy", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404 for DELETE, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/sdoque/mbaigo/forms"
+	"github.com/sdoque/mbaigo/usecases"
+)
+
+// Wireless sensors are read only. Each deCONZ sensor type has a reading in its state, which is served by the
+// service with the same name as the reading. The forms uses the time the sensor last reported ("lastupdated")
+// as timestamp, so stale readings from a sensor with a dead battery can be spotted.
+// https://dresden-elektronik.github.io/deconz-rest-doc/endpoints/sensors/
+
+// A sensorReading describes where a reading of a sensor type is found in its state (or config)
+type sensorReading struct {
+	Service string             // Subpath of the service
+	Key     string             // Attribute in the state
+	Unit    string             // Unit of the service
+	Divisor float64            // The gateway sends some readings as integers, eg. temperature in hundredths of a degree
+	Config  bool               // The attribute is in the config instead of the state
+	Values  map[string]float64 // Values of attributes that are strings, eg. "Open" = 1
+}
+
+// The window detection of the thermostats reports a string, some thermostats sends true/false instead
+var windowOpenValues = map[string]float64{"Closed": 0, "Hold": 0, "Open": 1, "External Open": 1, "External Closed": 0}
+
+// findReading returns the reading of the model served by the service
+func findReading(model, service string) (sensorReading, bool) {
+	for _, r := range deviceModels[model].Readings {
+		if r.Service == service {
+			return r, true
+		}
+	}
+	return sensorReading{}, false
+}
+
+// Parts of a sensor from the gateway
+type sensorStateJSON struct {
+	State  map[string]json.RawMessage `json:"state"`
+	Config map[string]json.RawMessage `json:"config"`
+}
+
+var errNotSensor error = fmt.Errorf("not a supported sensor")
+
+// getSensor fetches the state and config of the sensor from the gateway, they're saved in the cache too
+func (ua *UnitAsset) getSensor() (sensor sensorStateJSON, err error) {
+	err = getJSON("http://"+gateway+"/api/"+apikey+"/sensors/"+ua.Uniqueid, &sensor)
+	if err == nil {
+		deviceCache.merge(ua.Uniqueid, sensor.State, sensor.Config)
+	}
+	return
+}
+
+// sensorValue reads an attribute from the state (or the config) of the sensor into v, from the cache if
+// it's fresh or else from the gateway, and returns when the sensor last reported
+func (ua *UnitAsset) sensorValue(key string, config bool, v any) (time.Time, error) {
+	raw, updated, ok := deviceCache.find(ua.Uniqueid, key, config)
+	if !ok {
+		sensor, err := ua.getSensor()
+		if err != nil {
+			return updated, err
+		}
+		attributes := sensor.State
+		if config {
+			attributes = sensor.Config
+		}
+		if raw, ok = attributes[key]; !ok {
+			return updated, fmt.Errorf("%w: %s", errMissingAttribute, key)
+		}
+		// Use the time from the gateway, like the cache does
+		updated = lastUpdated(&cacheEntry{state: sensor.State, received: time.Now()})
+	}
+	return updated, json.Unmarshal(raw, v)
+}
+
+// getReading returns the reading of the sensor served by the service, true/false readings are returned as 1/0
+func (ua *UnitAsset) getReading(service string) (f forms.SignalA_v1a, err error) {
+	r, found := findReading(ua.Model, service)
+	if !found {
+		return f, errNotSensor
+	}
+	var value any
+	updated, err := ua.sensorValue(r.Key, r.Config, &value)
+	if err != nil {
+		return
+	}
+	switch v := value.(type) {
+	case bool:
+		f = getForm(0, r.Unit)
+		if v {
+			f.Value = 1
+		}
+	case float64:
+		// Rounding gets rid of float noise like 21.330000000000002
+		f = getForm(math.Round(v/r.Divisor*100)/100, r.Unit)
+	case string:
+		n, found := r.Values[v]
+		if !found {
+			return f, fmt.Errorf("%w: %s = %q", errBadFormValue, r.Key, v)
+		}
+		f = getForm(n, r.Unit)
+	default:
+		return f, fmt.Errorf("%w: %s", errBadFormValue, r.Key)
+	}
+	f.Timestamp = updated
+	return f, nil
+}
+
+// getBattery returns the battery level of a wireless sensor or thermostat in percent
+func (ua *UnitAsset) getBattery() (f forms.SignalA_v1a, err error) {
+	var battery float64
+	updated, err := ua.sensorValue("battery", true, &battery)
+	if err != nil {
+		return
+	}
+	f = getForm(battery, "Percent")
+	f.Timestamp = updated
+	return f, nil
+}
+
+// sensorSignal handles the GET requests of the sensor services, which are only supported by the sensors
+// that has the reading (or a battery)
+func (rsc *UnitAsset) sensorSignal(w http.ResponseWriter, r *http.Request, service string) {
+	switch r.Method {
+	case "GET":
+		if !rsc.supports(service) {
+			http.Error(w, "That device doesn't support that method.", http.StatusInternalServerError)
+			return
+		}
+		var f forms.SignalA_v1a
+		var err error
+		if service == "battery" {
+			f, err = rsc.getBattery()
+		} else {
+			f, err = rsc.getReading(service)
+		}
+		if err != nil {
+			http.Error(w, "Failed getting data, or data not present", http.StatusInternalServerError)
+			return
+		}
+		usecases.HTTPProcessGetRequest(w, r, &f)
+	default:
+		http.Error(w, "Method is not supported", http.StatusNotFound)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sdoque/mbaigo/forms"
+)
+
+func TestGetReading(t *testing.T) {
+	table := []struct {
+		model string
+		state string
+		want  float64
+		unit  string
+	}{
+		{"ZHATemperature", `{"temperature": 2133}`, 21.33, "Celsius"},
+		{"ZHAHumidity", `{"humidity": 4567}`, 45.67, "Percent"},
+		{"ZHAPressure", `{"pressure": 1013}`, 1013, "hPa"},
+		{"ZHALightLevel", `{"lux": 250, "lightlevel": 23980}`, 250, "Lux"},
+		{"ZHAPresence", `{"presence": true}`, 1, "Binary"},
+		{"ZHAOpenClose", `{"open": false}`, 0, "Binary"},
+	}
+	for _, test := range table {
+		ua := newTestAsset(t, "Sensor", test.model)
+		c := useTestCache(t)
+		var state map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(test.state), &state); err != nil {
+			t.Fatal(err)
+		}
+		state["lastupdated"] = json.RawMessage(`"2025-01-02T03:04:05.678"`)
+		c.merge("sensor", state, nil)
+		f, err := ua.getReading(deviceModels[test.model].Readings[0].Service)
+		if err != nil || f.Value != test.want || f.Unit != test.unit {
+			t.Errorf("expected %v %s for %s, got %v %s (%v)", test.want, test.unit, test.model, f.Value, f.Unit, err)
+		}
+		if !f.Timestamp.Equal(time.Date(2025, 1, 2, 3, 4, 5, 678e6, time.UTC)) {
+			t.Errorf("expected the lastupdated time for %s, got %v", test.model, f.Timestamp)
+		}
+	}
+
+	// Not a sensor
+	ua := newTestAsset(t, "Sensor", "Smart plug")
+	if _, err := ua.getReading("temperature"); !errors.Is(err, errNotSensor) {
+		t.Errorf("expected errNotSensor, got %v", err)
+	}
+	// Bad value
+	ua = newTestAsset(t, "Sensor", "ZHAPresence")
+	useTestCache(t).merge("sensor", map[string]json.RawMessage{"presence": json.RawMessage(`"yes"`)}, nil)
+	if _, err := ua.getReading("presence"); !errors.Is(err, errBadFormValue) {
+		t.Errorf("expected errBadFormValue, got %v", err)
+	}
+}
+
+func TestSensorFromGateway(t *testing.T) {
+	ua := newTestAsset(t, "Sensor", "ZHATemperature")
+	c := useTestCache(t)
+	http.DefaultClient.Transport = pathTransport{map[string]string{
+		"/api/1234/sensors/sensor": `{"state": {"temperature": 1950, "lastupdated": "2025-01-02T03:04:05"}, "config": {"battery": 87}}`,
+	}}
+	f, err := ua.getReading("temperature")
+	if err != nil || f.Value != 19.5 || !f.Timestamp.Equal(time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("expected 19.5 at lastupdated from the gateway, got %v at %v (%v)", f.Value, f.Timestamp, err)
+	}
+	if _, _, ok := c.find("sensor", "battery", true); !ok {
+		t.Errorf("expected the config from the gateway to be cached")
+	}
+	// The battery is read from the cached config now
+	http.DefaultClient.Transport = pathTransport{map[string]string{}}
+	if f, err = ua.getBattery(); err != nil || f.Value != 87 || f.Unit != "Percent" {
+		t.Errorf("expected 87 Percent, got %v %s (%v)", f.Value, f.Unit, err)
+	}
+
+	c.devices = map[string]*cacheEntry{}
+	if _, err = ua.getReading("temperature"); err == nil {
+		t.Errorf("expected an error when the sensor can't be found")
+	}
+	http.DefaultClient.Transport = pathTransport{map[string]string{
+		"/api/1234/sensors/sensor": `{"state": {"temperature": 1950}, "config": {}}`,
+	}}
+	c.devices = map[string]*cacheEntry{}
+	if _, err = ua.getBattery(); !errors.Is(err, errMissingAttribute) {
+		t.Errorf("expected errMissingAttribute, got %v", err)
+	}
+}
+
+func TestSensorServices(t *testing.T) {
+	ua := newTestAsset(t, "Sensor", "ZHAOpenClose")
+	c := useTestCache(t)
+	c.merge("sensor", map[string]json.RawMessage{"open": json.RawMessage("true")}, map[string]json.RawMessage{"battery": json.RawMessage("50")})
+	http.DefaultClient.Transport = pathTransport{map[string]string{}}
+	request := func(method, service string) *http.Response {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, "http://localhost:8870/ZigBeeHandler/Sensor/"+service, nil)
+		ua.Serving(w, r, service)
+		return w.Result()
+	}
+
+	for _, service := range []string{"open", "battery"} {
+		resp := request("GET", service)
+		var f forms.SignalA_v1a
+		if err := json.NewDecoder(resp.Body).Decode(&f); err != nil || resp.StatusCode != good_code {
+			t.Errorf("expected the %s reading, got %d (%v)", service, resp.StatusCode, err)
+		}
+	}
+	// Services for other sensor types
+	for _, service := range []string{"temperature", "humidity", "pressure", "lightlevel", "presence"} {
+		if resp := request("GET", service); resp.StatusCode != http.StatusInternalServerError {
+			t.Errorf("expected %s to be unsupported, got %d", service, resp.StatusCode)
+		}
+	}
+	if resp := request("PUT", "open"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404 for PUT, got %d", resp.StatusCode)
+	}
+	// Missing reading
+	c.devices = map[string]*cacheEntry{}
+	if resp := request("GET", "open"); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected status 500 without a reading, got %d", resp.StatusCode)
+	}
+	// Not a sensor
+	ua.Model = "Smart plug"
+	if resp := request("GET", "battery"); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected battery to be unsupported by plugs, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sdoque/mbaigo/forms"
+)
+
+// A new setpoint is sent to the thermostat right away, but battery powered thermostats only wakes up every
+// few minutes to pick it up. The setpoint reported by the thermostat is checked every period, and the setpoint
+// is sent again if the thermostat still reports something else after the grace time (it was offline, or
+// someone turned the knob on it).
+
+// How long the thermostat gets to pick up a new setpoint, before it's sent again
+const setpointGrace time.Duration = 5 * time.Minute
+
+var errSetpointRejected error = fmt.Errorf("setpoint rejected by the gateway")
+
+// setpointSync guards the setpoint of a unit asset and keeps track of when it was sent to the thermostat
+type setpointSync struct {
+	mutex sync.Mutex
+	sent  time.Time
+}
+
+// sending notes that the setpoint is being sent now
+func (s *setpointSync) sending() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sent = time.Now()
+}
+
+// sinceSent returns how long ago the setpoint was sent
+func (s *setpointSync) sinceSent() time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return time.Since(s.sent)
+}
+
+// The gateway answers a change with a list of the attributes it changed (and their new values) or errors
+type configAnswerJSON []struct {
+	Success map[string]json.RawMessage `json:"success"` // eg. {"/sensors/7/config/heatsetpoint": 2400}
+	Error   struct {
+		Address     string `json:"address"`
+		Description string `json:"description"`
+	} `json:"error"`
+}
+
+// checkConfigAnswer makes sure the gateway changed the config attribute to the value that was sent
+func checkConfigAnswer(data []byte, key string, value int) error {
+	var answers configAnswerJSON
+	if err := json.Unmarshal(data, &answers); err != nil {
+		return fmt.Errorf("%w: %s", errSetpointRejected, err)
+	}
+	for _, a := range answers {
+		if a.Error.Description != "" {
+			return fmt.Errorf("%w: %s", errSetpointRejected, a.Error.Description)
+		}
+		for address, raw := range a.Success {
+			if !strings.HasSuffix(address, "/config/"+key) {
+				continue
+			}
+			var got float64
+			if err := json.Unmarshal(raw, &got); err != nil || int(math.Round(got)) != value {
+				return fmt.Errorf("%w: changed to %s instead of %d", errSetpointRejected, raw, value)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: no answer for %s", errSetpointRejected, key)
+}
+
+// applySetPoint updates the setpoint and sends it to the thermostat. The setpoint is kept even if the
+// thermostat can't be reached, so it's sent again later.
+func (ua *UnitAsset) applySetPoint(f forms.SignalA_v1a) error {
+	ua.setSetPoint(f)
+	return ua.sendSetPoint()
+}
+
+// setpointSynced checks if the thermostat reports the same setpoint as the unit asset has
+func (ua *UnitAsset) setpointSynced() (bool, error) {
+	f, err := ua.getReading("heatsetpoint")
+	if err != nil {
+		return false, err
+	}
+	return math.Abs(f.Value-ua.getSetPoint().Value) < 0.01, nil
+}
+
+// reconcileSetPoint sends the setpoint again if the thermostat hasn't picked it up within the grace time
+func (ua *UnitAsset) reconcileSetPoint() error {
+	synced, err := ua.setpointSynced()
+	if err != nil || synced {
+		return err
+	}
+	if ua.setpoint.sinceSent() < setpointGrace {
+		return nil // Give it time to wake up
+	}
+	log.Printf("Thermostat %s doesn't use the setpoint %.2f, sending it again\n", ua.Name, ua.getSetPoint().Value)
+	return ua.sendSetPoint()
+}
+
+// setpointLoop checks the setpoint of the thermostat every period, until the system shuts down
+func (ua *UnitAsset) setpointLoop(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := ua.reconcileSetPoint(); err != nil {
+				log.Printf("Error checking the setpoint of %s: %s\n", ua.Name, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// ThermostatState is what a thermostat reports about itself, so it can be compared with the setpoint
+// that was sent to it
+type ThermostatState struct {
+	Temperature  float64   `json:"temperature"`  // Celsius, measured by the thermostat
+	HeatSetpoint float64   `json:"heatsetpoint"` // Celsius, the setpoint the thermostat is using
+	Valve        float64   `json:"valve"`        // Percent open
+	Mode         string    `json:"mode"`         // eg. "heat", "auto" or "off"
+	Battery      float64   `json:"battery"`      // Percent
+	WindowOpen   bool      `json:"windowopen"`   // If the thermostat has detected an open window
+	LastUpdated  time.Time `json:"lastupdated"`  // When the thermostat last reported
+	Setpoint     float64   `json:"setpoint"`     // Celsius, the setpoint sent to the thermostat
+	Synced       bool      `json:"synced"`       // If the thermostat uses the setpoint that was sent to it
+}
+
+// getThermostat collects what the thermostat reports. Not all thermostats reports everything, so the
+// attributes that are missing are left empty instead of failing.
+func (ua *UnitAsset) getThermostat() (s ThermostatState, err error) {
+	f, err := ua.getReading("temperature")
+	if err != nil {
+		return s, err // It has to report something
+	}
+	s.Temperature = f.Value
+	s.LastUpdated = f.Timestamp
+	s.Setpoint = ua.getSetPoint().Value
+	if f, err := ua.getReading("heatsetpoint"); err == nil {
+		s.HeatSetpoint = f.Value
+		s.Synced = math.Abs(s.HeatSetpoint-s.Setpoint) < 0.01
+	}
+	if f, err := ua.getReading("valve"); err == nil {
+		s.Valve = f.Value
+	}
+	if f, err := ua.getReading("windowopen"); err == nil {
+		s.WindowOpen = f.Value == 1
+	}
+	if f, err := ua.getBattery(); err == nil {
+		s.Battery = f.Value
+	}
+	var mode string
+	if _, err := ua.sensorValue("mode", true, &mode); err == nil {
+		s.Mode = mode
+	}
+	return s, nil
+}
+
+// Function used by the webhandler to get everything a thermostat reports
+func (rsc *UnitAsset) thermostat(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		if !rsc.supports("thermostat") {
+			http.Error(w, "That device doesn't support that method.", http.StatusInternalServerError)
+			return
+		}
+		s, err := rsc.getThermostat()
+		if err != nil {
+			http.Error(w, "Failed getting data, or data not present", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(s); err != nil {
+			log.Printf("Error encoding the thermostat state: %s\n", err)
+		}
+	default:
+		http.Error(w, "Method is not supported", http.StatusNotFound)
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const thermostatExample string = `{
+	"state": {"temperature": 2050, "valve": 51, "windowopen": "Closed", "lastupdated": "2025-01-02T03:04:05"},
+	"config": {"heatsetpoint": 2150, "mode": "heat", "battery": 90}
+}`
+
+func TestThermostatReadings(t *testing.T) {
+	ua := newTestAsset(t, "Sensor", "ZHAThermostat")
+	useTestCache(t)
+	http.DefaultClient.Transport = pathTransport{map[string]string{"/api/1234/sensors/sensor": thermostatExample}}
+
+	table := []struct {
+		service string
+		want    float64
+		unit    string
+	}{
+		{"temperature", 20.5, "Celsius"},
+		{"heatsetpoint", 21.5, "Celsius"},
+		{"valve", 20, "Percent"},
+		{"windowopen", 0, "Binary"},
+	}
+	for _, test := range table {
+		f, err := ua.getReading(test.service)
+		if err != nil || f.Value != test.want || f.Unit != test.unit {
+			t.Errorf("expected %v %s for %s, got %v %s (%v)", test.want, test.unit, test.service, f.Value, f.Unit, err)
+		}
+	}
+	if _, err := ua.getReading("humidity"); !errors.Is(err, errNotSensor) {
+		t.Errorf("expected errNotSensor, got %v", err)
+	}
+
+	// The window detection is a string or true/false, depending on the thermostat
+	table2 := []struct {
+		value string
+		want  float64
+		err   error
+	}{
+		{`"External Open"`, 1, nil},
+		{`true`, 1, nil},
+		{`false`, 0, nil},
+		{`"Ajar"`, 0, errBadFormValue},
+	}
+	for _, test := range table2 {
+		deviceCache.merge("sensor", map[string]json.RawMessage{"windowopen": json.RawMessage(test.value)}, nil)
+		f, err := ua.getReading("windowopen")
+		if !errors.Is(err, test.err) || (err == nil && f.Value != test.want) {
+			t.Errorf("expected %v (%v) for %s, got %v (%v)", test.want, test.err, test.value, f.Value, err)
+		}
+	}
+}
+
+func TestGetThermostat(t *testing.T) {
+	ua := newTestAsset(t, "Sensor", "ZHAThermostat")
+	c := useTestCache(t)
+	http.DefaultClient.Transport = pathTransport{map[string]string{"/api/1234/sensors/sensor": thermostatExample}}
+	s, err := ua.getThermostat()
+	want := ThermostatState{
+		Temperature:  20.5,
+		HeatSetpoint: 21.5,
+		Valve:        20,
+		Mode:         "heat",
+		Battery:      90,
+		LastUpdated:  time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+		Setpoint:     20,
+	}
+	if err != nil || s != want {
+		t.Errorf("expected %+v, got %+v (%v)", want, s, err)
+	}
+
+	// Thermostats that only reports the temperature
+	c.devices = map[string]*cacheEntry{}
+	http.DefaultClient.Transport = pathTransport{map[string]string{
+		"/api/1234/sensors/sensor": `{"state": {"temperature": 1800}, "config": {}}`,
+	}}
+	if s, err = ua.getThermostat(); err != nil || s.Temperature != 18 || s.Mode != "" {
+		t.Errorf("expected only the temperature, got %+v (%v)", s, err)
+	}
+	c.devices = map[string]*cacheEntry{}
+	http.DefaultClient.Transport = pathTransport{map[string]string{}}
+	if _, err = ua.getThermostat(); err == nil {
+		t.Errorf("expected an error when the thermostat can't be found")
+	}
+}
+
+func TestThermostatServices(t *testing.T) {
+	ua := newTestAsset(t, "Sensor", "ZHAThermostat")
+	useTestCache(t)
+	http.DefaultClient.Transport = pathTransport{map[string]string{"/api/1234/sensors/sensor": thermostatExample}}
+	request := func(method, service string) *http.Response {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, "http://localhost:8870/ZigBeeHandler/Sensor/"+service, nil)
+		ua.Serving(w, r, service)
+		return w.Result()
+	}
+
+	for _, service := range []string{"temperature", "heatsetpoint", "valve", "windowopen", "battery", "thermostat"} {
+		if resp := request("GET", service); resp.StatusCode != good_code {
+			t.Errorf("expected %s to be supported, got %d", service, resp.StatusCode)
+		}
+	}
+	var s ThermostatState
+	resp := request("GET", "thermostat")
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil || s.Mode != "heat" {
+		t.Errorf("expected the thermostat state, got %+v (%v)", s, err)
+	}
+	if resp = request("PUT", "thermostat"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404 for PUT, got %d", resp.StatusCode)
+	}
+	ua.Model = "ZHATemperature"
+	if resp = request("GET", "thermostat"); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected the thermostat service to be unsupported, got %d", resp.StatusCode)
+	}
+	ua.Model = "ZHAThermostat"
+	deviceCache.devices = map[string]*cacheEntry{}
+	http.DefaultClient.Transport = pathTransport{map[string]string{}}
+	if resp = request("GET", "thermostat"); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected status 500 without a thermostat, got %d", resp.StatusCode)
+	}
+}
+
+// thermostatGateway answers the setpoint changes like the gateway and counts them, and reports the
+// heatsetpoint set for the thermostat
+type thermostatGateway struct {
+	mutex        sync.Mutex
+	sent         []int
+	reject       bool
+	heatsetpoint int
+}
+
+func (g *thermostatGateway) RoundTrip(req *http.Request) (*http.Response, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	var body string
+	switch req.Method {
+	case http.MethodPut:
+		var change struct {
+			HeatSetpoint int `json:"heatsetpoint"`
+		}
+		b, _ := io.ReadAll(req.Body)
+		if err := json.Unmarshal(b, &change); err != nil {
+			return nil, err
+		}
+		g.sent = append(g.sent, change.HeatSetpoint)
+		body = fmt.Sprintf(`[{"success": {"/sensors/7/config/heatsetpoint": %d}}]`, change.HeatSetpoint)
+		if g.reject {
+			body = `[{"error": {"type": 7, "address": "/sensors/7/config/heatsetpoint", "description": "invalid value"}}]`
+		}
+	default:
+		body = fmt.Sprintf(`{"state": {"temperature": 2000}, "config": {"heatsetpoint": %d}}`, g.heatsetpoint)
+	}
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: 200,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func (g *thermostatGateway) take() []int {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	sent := g.sent
+	g.sent = nil
+	return sent
+}
+
+func TestCheckConfigAnswer(t *testing.T) {
+	table := []struct {
+		answer string
+		err    bool
+	}{
+		{`[{"success": {"/sensors/7/config/heatsetpoint": 2150}}]`, false},
+		{`[{"success": {"/sensors/7/config/mode": "heat"}}, {"success": {"/sensors/7/config/heatsetpoint": 2150}}]`, false},
+		{`[{"success": {"/sensors/7/config/heatsetpoint": 2000}}]`, true},
+		{`[{"error": {"type": 3, "address": "/sensors/7", "description": "resource not available"}}]`, true},
+		{`[]`, true},
+		{`{"test": "test ok"}`, true},
+	}
+	for _, test := range table {
+		err := checkConfigAnswer([]byte(test.answer), "heatsetpoint", 2150)
+		if (err != nil) != test.err || (err != nil && !errors.Is(err, errSetpointRejected)) {
+			t.Errorf("expected error %t for %s, got %v", test.err, test.answer, err)
+		}
+	}
+}
+
+func TestReconcileSetPoint(t *testing.T) {
+	ua := newTestAsset(t, "Sensor", "ZHAThermostat")
+	c := useTestCache(t)
+	g := &thermostatGateway{heatsetpoint: 1800}
+	http.DefaultClient.Transport = g
+
+	// A new setpoint is sent right away
+	if err := ua.applySetPoint(getForm(21.5, "Celsius")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if sent := g.take(); len(sent) != 1 || sent[0] != 2150 {
+		t.Errorf("expected 2150 to be sent, got %v", sent)
+	}
+	// The thermostat hasn't picked it up yet, but it gets some time
+	if err := ua.reconcileSetPoint(); err != nil || len(g.take()) != 0 {
+		t.Errorf("expected nothing to be sent within the grace time (%v)", err)
+	}
+	// Still the old setpoint after the grace time
+	ua.setpoint.sent = time.Now().Add(-setpointGrace)
+	if err := ua.reconcileSetPoint(); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if sent := g.take(); len(sent) != 1 || sent[0] != 2150 {
+		t.Errorf("expected the setpoint to be sent again, got %v", sent)
+	}
+	// The thermostat reports the setpoint
+	c.merge("sensor", nil, map[string]json.RawMessage{"heatsetpoint": json.RawMessage("2150")})
+	ua.setpoint.sent = time.Now().Add(-setpointGrace)
+	if err := ua.reconcileSetPoint(); err != nil || len(g.take()) != 0 {
+		t.Errorf("expected nothing to be sent when the setpoint is used (%v)", err)
+	}
+	if s, _ := ua.getThermostat(); !s.Synced {
+		t.Errorf("expected the thermostat to be synced, got %+v", s)
+	}
+
+	// The gateway rejects the setpoint, but it's kept for later
+	g.reject = true
+	if err := ua.applySetPoint(getForm(35, "Celsius")); !errors.Is(err, errSetpointRejected) {
+		t.Errorf("expected errSetpointRejected, got %v", err)
+	}
+	if ua.getSetPoint().Value != 35 {
+		t.Errorf("expected the setpoint to be kept, got %v", ua.getSetPoint().Value)
+	}
+	// No reported setpoint
+	c.devices = map[string]*cacheEntry{}
+	http.DefaultClient.Transport = pathTransport{map[string]string{}}
+	if err := ua.reconcileSetPoint(); err == nil {
+		t.Errorf("expected an error without a reported setpoint")
+	}
+}
+
+func TestSetpointLoop(t *testing.T) {
+	ua := newTestAsset(t, "Sensor", "ZHAThermostat")
+	useTestCache(t)
+	g := &thermostatGateway{heatsetpoint: 1800}
+	http.DefaultClient.Transport = g
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		ua.setpointLoop(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+	// The setpoint was never sent, so the thermostat gets it at the first check
+	deadline := time.Now().Add(time.Second)
+	for len(g.take()) < 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if time.Now().After(deadline) {
+		t.Errorf("expected the setpoint to be sent by the loop")
+	}
+}
+
+func TestSetptPut(t *testing.T) {
+	ua := newTestAsset(t, "Sensor", "ZHAThermostat")
+	g := &thermostatGateway{}
+	http.DefaultClient.Transport = g
+	put := func(value float64) int {
+		w := httptest.NewRecorder()
+		body := fmt.Sprintf(`{"value": %v, "unit": "Celsius", "version": "SignalA_v1.0"}`, value)
+		r := httptest.NewRequest("PUT", "http://localhost:8870/ZigBeeHandler/Sensor/setpoint", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		ua.setpt(w, r)
+		return w.Result().StatusCode
+	}
+
+	if status := put(22); status != good_code {
+		t.Errorf("expected status 200, got %d", status)
+	}
+	if sent := g.take(); len(sent) != 1 || sent[0] != 2200 {
+		t.Errorf("expected the setpoint to be sent to the thermostat, got %v", sent)
+	}
+	g.reject = true
+	if status := put(23); status != http.StatusInternalServerError {
+		t.Errorf("expected status 500 when the gateway rejects it, got %d", status)
+	}
+	// Plugs only keeps the setpoint for the feedback loop
+	ua.Model = "Smart plug"
+	g.take()
+	if status := put(19); status != good_code || len(g.take()) != 0 || ua.getSetPoint().Value != 19 {
+		t.Errorf("expected the plug to keep the setpoint, got %d", status)
+	}
+}
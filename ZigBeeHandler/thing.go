@@ -10,11 +10,13 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/gorilla/websocket"
 	"github.com/sdoque/mbaigo/components"
 	"github.com/sdoque/mbaigo/forms"
 	"github.com/sdoque/mbaigo/usecases"
@@ -45,7 +47,30 @@ type UnitAsset struct {
 	Period   time.Duration     `json:"period"`
 	Setpt    float64           `json:"setpoint"`
 	Slaves   map[string]string `json:"slaves"`
-	Apikey   string            `json:"APIkey"`
+	// Optional API key for the gateway, the first unit asset that sets it is used for all of them
+	Apikey string `json:"APIkey"`
+	// Optional gateway (host:port) and the order of the discovery strategies, the first unit asset that sets them is used
+	Gateway   string   `json:"gateway"`
+	Discovery []string `json:"discovery"`
+	// Creates unit assets for all supported devices on the gateway, that isn't in the configuration
+	Inventory bool `json:"inventory"`
+	// What a switch does for each buttonevent code, the first button toggles the slaves if it's empty
+	Actions map[string]ButtonAction `json:"actions"`
+	// Seconds for a light to fade to a new state, the gateway default is used if it's 0
+	Transition float64 `json:"transition"`
+	// Limits for the feedback loop of a smart plug: the width of the deadband around the setpoint (Celsius),
+	// the minimum on and off times (seconds) and the maximum number of switches per hour (0 = no limit)
+	Deadband    float64 `json:"deadband"`
+	MinOnTime   float64 `json:"minontime"`
+	MinOffTime  float64 `json:"minofftime"`
+	MaxSwitches int     `json:"maxswitches"`
+
+	capabilities lightCapabilities // What the light supports, detected at startup
+	setpoint     *setpointSync     // Keeps track of the setpoint sent to a thermostat
+	controller   *plugController   // The state of the feedback loop of a smart plug
+	group        *switchGroup      // The group on the gateway holding the slaves of a switch
+	transition   *lightTransition  // The transition time of a light, which can be changed while it's used
+	systemAsset  bool              // Serves the services for the whole system, see systemServices
 }
 
 // GetName returns the name of the Resource.
@@ -123,6 +148,195 @@ func initTemplate() components.UnitAsset {
 		Description: "provides the current state of the device (GET), or sets it (PUT) [0 = off, 1 = on]",
 	}
 
+	// The light services are only supported by lights that can be dimmed or change color
+	brightnessService := components.Service{
+		Definition:  "brightness",
+		SubPath:     "brightness",
+		Details:     map[string][]string{"Unit": {"Percent"}, "Forms": {"SignalA_v1a"}},
+		Description: "provides the brightness of the light (GET), or sets it (PUT) [0 = off]",
+	}
+
+	colorTempService := components.Service{
+		Definition:  "colortemp",
+		SubPath:     "colortemp",
+		Details:     map[string][]string{"Unit": {"Kelvin"}, "Forms": {"SignalA_v1a"}},
+		Description: "provides the color temperature of the light (GET), or sets it (PUT)",
+	}
+
+	hueService := components.Service{
+		Definition:  "hue",
+		SubPath:     "hue",
+		Details:     map[string][]string{"Unit": {"Degrees"}, "Forms": {"SignalA_v1a"}},
+		Description: "provides the color hue of the light (GET), or sets it (PUT) [0-360]",
+	}
+
+	saturationService := components.Service{
+		Definition:  "saturation",
+		SubPath:     "saturation",
+		Details:     map[string][]string{"Unit": {"Percent"}, "Forms": {"SignalA_v1a"}},
+		Description: "provides the color saturation of the light (GET), or sets it (PUT)",
+	}
+
+	xyService := components.Service{
+		Definition:  "xy",
+		SubPath:     "xy",
+		Details:     map[string][]string{"Forms": {"JSON"}},
+		Description: "provides the color of the light in the CIE xy color space (GET), or sets it (PUT) [{\"x\": 0.3, \"y\": 0.3}]",
+	}
+
+	transitionService := components.Service{
+		Definition:  "transition",
+		SubPath:     "transition",
+		Details:     map[string][]string{"Unit": {"Seconds"}, "Forms": {"SignalA_v1a"}},
+		Description: "provides the time the light takes to fade to a new state (GET), or sets it (PUT)",
+	}
+
+	// The sensor services are only supported by the wireless sensors of the same type, eg. ZHATemperature
+	temperatureService := components.Service{
+		Definition:  "temperature",
+		SubPath:     "temperature",
+		Details:     map[string][]string{"Unit": {"Celsius"}, "Forms": {"SignalA_v1a"}},
+		Description: "provides the temperature measured by the sensor (GET)",
+	}
+
+	humidityService := components.Service{
+		Definition:  "humidity",
+		SubPath:     "humidity",
+		Details:     map[string][]string{"Unit": {"Percent"}, "Forms": {"SignalA_v1a"}},
+		Description: "provides the relative humidity measured by the sensor (GET)",
+	}
+
+	pressureService := components.Service{
+		Definition:  "pressure",
+		SubPath:     "pressure",
+		Details:     map[string][]string{"Unit": {"hPa"}, "Forms": {"SignalA_v1a"}},
+		Description: "provides the air pressure measured by the sensor (GET)",
+	}
+
+	lightLevelService := components.Service{
+		Definition:  "lightlevel",
+		SubPath:     "lightlevel",
+		Details:     map[string][]string{"Unit": {"Lux"}, "Forms": {"SignalA_v1a"}},
+		Description: "provides the illuminance measured by the sensor (GET)",
+	}
+
+	presenceService := components.Service{
+		Definition:  "presence",
+		SubPath:     "presence",
+		Details:     map[string][]string{"Unit": {"Binary"}, "Forms": {"SignalA_v1a"}},
+		Description: "provides if the sensor detects presence (GET) [0 = no one, 1 = presence]",
+	}
+
+	openService := components.Service{
+		Definition:  "open",
+		SubPath:     "open",
+		Details:     map[string][]string{"Unit": {"Binary"}, "Forms": {"SignalA_v1a"}},
+		Description: "provides if the door or window is open (GET) [0 = closed, 1 = open]",
+	}
+
+	// The thermostat services shows what a ZHAThermostat reports, the temperature and battery services are supported too
+	heatSetpointService := components.Service{
+		Definition:  "heatsetpoint",
+		SubPath:     "heatsetpoint",
+		Details:     map[string][]string{"Unit": {"Celsius"}, "Forms": {"SignalA_v1a"}},
+		Description: "provides the setpoint reported by the thermostat (GET)",
+	}
+
+	valveService := components.Service{
+		Definition:  "valve",
+		SubPath:     "valve",
+		Details:     map[string][]string{"Unit": {"Percent"}, "Forms": {"SignalA_v1a"}},
+		Description: "provides how much the radiator valve is open (GET)",
+	}
+
+	windowOpenService := components.Service{
+		Definition:  "windowopen",
+		SubPath:     "windowopen",
+		Details:     map[string][]string{"Unit": {"Binary"}, "Forms": {"SignalA_v1a"}},
+		Description: "provides if the thermostat has detected an open window (GET) [0 = closed, 1 = open]",
+	}
+
+	thermostatService := components.Service{
+		Definition:  "thermostat",
+		SubPath:     "thermostat",
+		Details:     map[string][]string{"Forms": {"JSON"}},
+		Description: "provides everything the thermostat reports, including its mode (GET)",
+	}
+
+	// This service is supported by all the wireless sensors above, and thermostats
+	batteryService := components.Service{
+		Definition:  "battery",
+		SubPath:     "battery",
+		Details:     map[string][]string{"Unit": {"Percent"}, "Forms": {"SignalA_v1a"}},
+		Description: "provides the battery level of the sensor (GET)",
+	}
+
+	// This service will only be supported by Smart Power plugs with a feedback loop (period > 0)
+	controllerService := components.Service{
+		Definition:  "controller",
+		SubPath:     "controller",
+		Details:     map[string][]string{"Forms": {"JSON"}},
+		Description: "provides the state of the feedback loop controlling the plug, and why it's in that state (GET)",
+	}
+
+	// This service will only be supported by switches with slaves, which are put in a group on the gateway
+	sceneService := components.Service{
+		Definition:  "scene",
+		SubPath:     "scene",
+		Details:     map[string][]string{"Unit": {"Scene"}, "Forms": {"SignalA_v1a"}},
+		Description: "provides the id of the last scene recalled in the group of the switch (GET) or recalls a scene by its id (PUT)",
+	}
+
+	// This service will only be supported by switches with slaves, which are put in a group on the gateway
+	scenesService := components.Service{
+		Definition:  "scenes",
+		SubPath:     "scenes",
+		Details:     map[string][]string{"Forms": {"JSON"}},
+		Description: "provides the scenes in the group of the switch (GET) or stores the current state of the slaves in a scene (PUT)",
+	}
+
+	// This service is supported by all unit assets, and shows if the system is still waiting for an API key
+	gatewayService := components.Service{
+		Definition:  "gateway",
+		SubPath:     "gateway",
+		Details:     map[string][]string{"Forms": {"JSON"}},
+		Description: "provides the status of the connection to the gateway, eg. if it's waiting to be unlocked for an API key (GET)",
+	}
+
+	// The health services are supported by all unit assets
+	healthService := components.Service{
+		Definition:  "health",
+		SubPath:     "health",
+		Details:     map[string][]string{"Forms": {"JSON"}},
+		Description: "provides if the device is reachable, when it was last seen, its battery and if it's stale (GET)",
+	}
+	reachableService := components.Service{
+		Definition:  "reachable",
+		SubPath:     "reachable",
+		Details:     map[string][]string{"Unit": {"Binary"}, "Forms": {"SignalA_v1a"}},
+		Description: "provides if the gateway can reach the device (GET)",
+	}
+	lastSeenService := components.Service{
+		Definition:  "lastseen",
+		SubPath:     "lastseen",
+		Details:     map[string][]string{"Unit": {"Seconds"}, "Forms": {"SignalA_v1a"}},
+		Description: "provides how many seconds ago the gateway last heard from the device (GET)",
+	}
+	staleService := components.Service{
+		Definition:  "stale",
+		SubPath:     "stale",
+		Details:     map[string][]string{"Unit": {"Binary"}, "Forms": {"SignalA_v1a"}},
+		Description: "provides if the device hasn't been heard from for too long, and might have stopped working (GET)",
+	}
+
+	// This service is only supported by the first unit asset, and shows the health of all devices in the system
+	summaryService := components.Service{
+		Definition:  "summary",
+		SubPath:     "summary",
+		Details:     map[string][]string{"Forms": {"JSON"}},
+		Description: "provides the number of reachable, stale and low battery devices in the system, and which ones aren't healthy (GET)",
+	}
+
 	// var uat components.UnitAsset // this is an interface, which we then initialize
 	uat := &UnitAsset{
 		Name:     "SmartThermostat1",
@@ -133,14 +347,58 @@ func initTemplate() components.UnitAsset {
 		Setpt:    20,
 		// Only switches needs to manually add controlled power plug and light uniqueids, power plugs get their sensors added automatically
 		Slaves: map[string]string{},
-		Apikey: "1234",
+		// Leave the API key empty to get one from the gateway, which has to be unlocked in Phoscon
+		Apikey: "",
+		// Leave the gateway empty to discover it automatically
+		Gateway:   "",
+		Discovery: defaultDiscovery,
+		Inventory: true,
+		// Only switches uses actions
+		Actions: map[string]ButtonAction{},
+		// Only lights uses transitions
+		Transition: 0,
+		// Only smart plugs with a period uses the controller limits
+		Deadband:    0.5,
+		MinOnTime:   300,
+		MinOffTime:  300,
+		MaxSwitches: 6,
+		setpoint:    &setpointSync{},
+		controller:  &plugController{},
+		group:       &switchGroup{},
+		transition:  &lightTransition{},
 		ServicesMap: components.Services{
-			setPointService.SubPath:    &setPointService,
-			consumptionService.SubPath: &consumptionService,
-			currentService.SubPath:     &currentService,
-			powerService.SubPath:       &powerService,
-			voltageService.SubPath:     &voltageService,
-			stateService.SubPath:       &stateService,
+			setPointService.SubPath:     &setPointService,
+			consumptionService.SubPath:  &consumptionService,
+			currentService.SubPath:      &currentService,
+			powerService.SubPath:        &powerService,
+			voltageService.SubPath:      &voltageService,
+			stateService.SubPath:        &stateService,
+			gatewayService.SubPath:      &gatewayService,
+			brightnessService.SubPath:   &brightnessService,
+			colorTempService.SubPath:    &colorTempService,
+			hueService.SubPath:          &hueService,
+			saturationService.SubPath:   &saturationService,
+			xyService.SubPath:           &xyService,
+			transitionService.SubPath:   &transitionService,
+			temperatureService.SubPath:  &temperatureService,
+			humidityService.SubPath:     &humidityService,
+			pressureService.SubPath:     &pressureService,
+			lightLevelService.SubPath:   &lightLevelService,
+			presenceService.SubPath:     &presenceService,
+			openService.SubPath:         &openService,
+			batteryService.SubPath:      &batteryService,
+			heatSetpointService.SubPath: &heatSetpointService,
+			valveService.SubPath:        &valveService,
+			windowOpenService.SubPath:   &windowOpenService,
+			thermostatService.SubPath:   &thermostatService,
+			controllerService.SubPath:   &controllerService,
+			sceneService.SubPath:        &sceneService,
+			scenesService.SubPath:       &scenesService,
+			healthService.SubPath:       &healthService,
+			reachableService.SubPath:    &reachableService,
+			lastSeenService.SubPath:     &lastSeenService,
+			staleService.SubPath:        &staleService,
+			summaryService.SubPath:      &summaryService,
 		},
 	}
 	return uat
@@ -163,16 +421,32 @@ func newResource(uac UnitAsset, sys *components.System, servs []components.Servi
 	}
 	// instantiate the unit asset
 	ua := &UnitAsset{
-		Name:        uac.Name,
-		Owner:       sys,
-		Details:     uac.Details,
-		ServicesMap: components.CloneServices(servs),
+		Name:    uac.Name,
+		Owner:   sys,
+		Details: uac.Details,
+		ServicesMap: components.CloneServices(filterServices(servs, func(s string) bool {
+			return deviceModels[uac.Model].has(s) || (uac.systemAsset && slices.Contains(systemServices, s))
+		})),
 		Model:       uac.Model,
 		Uniqueid:    uac.Uniqueid,
 		Period:      uac.Period,
 		Setpt:       uac.Setpt,
 		Slaves:      uac.Slaves,
 		Apikey:      uac.Apikey,
+		Gateway:     uac.Gateway,
+		Discovery:   uac.Discovery,
+		Inventory:   uac.Inventory,
+		Actions:     uac.Actions,
+		Transition:  uac.Transition,
+		Deadband:    uac.Deadband,
+		MinOnTime:   uac.MinOnTime,
+		MinOffTime:  uac.MinOffTime,
+		MaxSwitches: uac.MaxSwitches,
+		setpoint:    &setpointSync{},
+		controller:  &plugController{},
+		group:       &switchGroup{},
+		transition:  &lightTransition{seconds: uac.Transition},
+		systemAsset: uac.systemAsset,
 		CervicesMap: components.Cervices{
 			t.Name: t,
 		},
@@ -190,6 +464,9 @@ func newResource(uac UnitAsset, sys *components.System, servs []components.Servi
 		}
 	}
 	ua.CervicesMap["temperature"].Details = components.MergeDetails(ua.Details, ref.Details)
+	for name, cer := range newActionCervices(ua.Actions, sProtocols) {
+		ua.CervicesMap[name] = cer
+	}
 	return ua, ua.startup
 }
 
@@ -209,6 +486,10 @@ func (ua *UnitAsset) startup() (err error) {
 			err = fmt.Errorf("ZHAThermostat sendsetpoint: %w", err)
 			return
 		}
+		// Keeps checking that the thermostat uses the setpoint, since it might miss it while it's asleep or offline
+		if ua.Period > 0 {
+			go ua.setpointLoop(ua.Owner.Ctx, ua.Period*time.Second)
+		}
 
 	case "Smart plug":
 		// Find all sensors belonging to the smart plug and put them in the slaves array with
@@ -219,6 +500,7 @@ func (ua *UnitAsset) startup() (err error) {
 			return
 		}
 		// Not all smart plugs should be handled by the feedbackloop, some should be handled by a switch
+		ua.dropUnsupported() // The controller service needs the feedbackloop
 		if ua.Period > 0 {
 			// start the unit assets feedbackloop, this fetches the temperature from ds18b20 and and toggles
 			// between on/off depending on temperature in the room and a set temperature in the unitasset
@@ -226,9 +508,34 @@ func (ua *UnitAsset) startup() (err error) {
 		}
 
 	case "ZHASwitch":
-		// Starts listening to the websocket to find buttonevents (button presses) and then
+		if err = validateActions(ua.Actions); err != nil {
+			err = fmt.Errorf("ZHASwitch actions: %w", err)
+			return
+		}
+		// The slaves are switched together through a group, or one by one if the group can't be set up
+		if len(ua.Slaves) > 0 {
+			if err := ua.syncGroup(); err != nil {
+				log.Printf("Error setting up the group of %s, its slaves are switched one by one: %s\n", ua.Name, err)
+			} else {
+				go ua.groupCleanup(ua.Owner.Ctx)
+			}
+		}
+		ua.dropUnsupported() // The scene services needs the group
+		// Starts listening to the websocket events to find buttonevents (button presses) and then
 		// turns its controlled devices (slaves) on/off
-		go ua.initWebsocketClient(ua.Owner.Ctx)
+		events.start(ua.Owner.Ctx)
+		go ua.listenForEvents(ua.Owner.Ctx, events.subscribe(ua.Uniqueid))
+
+	default:
+		if deviceModels[ua.Model].detectsLight() {
+			// Find out if the light can be dimmed or change color, and drop the services it doesn't support
+			err = ua.detectLight()
+			if err != nil {
+				err = fmt.Errorf("light detection: %w", err)
+				return
+			}
+			ua.dropUnsupported()
+		}
 	}
 	return
 }
@@ -261,17 +568,10 @@ func (ua *UnitAsset) processFeedbackLoop() {
 		log.Println("problem unpacking the temperature signal form")
 		return
 	}
-	// TODO: Check diff instead of a hard over/under value? meaning it'll only turn on/off if diff is over 0.5 degrees
-	if tup.Value < ua.Setpt {
-		err = ua.toggleState(true)
-		if err != nil {
-			log.Println("Error occurred while toggling state to true: ", err)
-		}
-	} else {
-		err = ua.toggleState(false)
-		if err != nil {
-			log.Println("Error occurred while toggling state to false: ", err)
-		}
+	// Turn the plug on or off, if the temperature is outside the deadband and the limits allows it
+	err = ua.controlPlug(tup.Value)
+	if err != nil {
+		log.Println("Error occurred while toggling state: ", err)
 	}
 }
 
@@ -327,7 +627,7 @@ type sensorJSON struct {
 
 func (ua *UnitAsset) getSensors() (err error) {
 	// Create and send a get request to get all sensors connected to deConz gateway
-	apiURL := "http://" + gateway + "/api/" + ua.Apikey + "/sensors"
+	apiURL := "http://" + gateway + "/api/" + apikey + "/sensors"
 	req, err := createGetRequest(apiURL)
 	if err != nil {
 		return err
@@ -361,6 +661,8 @@ func (ua *UnitAsset) getSensors() (err error) {
 
 // getSetPoint fills out a signal form with the current thermal setpoint
 func (ua *UnitAsset) getSetPoint() (f forms.SignalA_v1a) {
+	ua.setpoint.mutex.Lock()
+	defer ua.setpoint.mutex.Unlock()
 	f.NewForm()
 	f.Value = ua.Setpt
 	f.Unit = "Celsius"
@@ -370,6 +672,8 @@ func (ua *UnitAsset) getSetPoint() (f forms.SignalA_v1a) {
 
 // setSetPoint updates the thermal setpoint
 func (ua *UnitAsset) setSetPoint(f forms.SignalA_v1a) {
+	ua.setpoint.mutex.Lock()
+	defer ua.setpoint.mutex.Unlock()
 	ua.Setpt = f.Value
 }
 
@@ -379,14 +683,21 @@ func (ua *UnitAsset) sendSetPoint() (err error) {
 	// API call to set desired temp in smart thermostat, PUT call should be sent
 	// to  URL/api/apikey/sensors/sensor_id/config
 	// --- Send setpoint to specific unit ---
-	apiURL := "http://" + gateway + "/api/" + ua.Apikey + "/sensors/" + ua.Uniqueid + "/config"
-	// Create http friendly payload
-	s := fmt.Sprintf(`{"heatsetpoint":%f}`, ua.Setpt*100) // Create payload
+	apiURL := "http://" + gateway + "/api/" + apikey + "/sensors/" + ua.Uniqueid + "/config"
+	// Create http friendly payload, the gateway wants hundredths of a degree
+	heatsetpoint := int(math.Round(ua.getSetPoint().Value * 100))
+	s := fmt.Sprintf(`{"heatsetpoint":%d}`, heatsetpoint) // Create payload
 	req, err := createPutRequest(s, apiURL)
 	if err != nil {
 		return
 	}
-	return sendPutRequest(req)
+	ua.setpoint.sending()
+	data, err := sendRequest(req)
+	if err != nil {
+		return
+	}
+	// Make sure the gateway accepted the setpoint
+	return checkConfigAnswer(data, "heatsetpoint", heatsetpoint)
 }
 
 // Functions and structs to get and set current state of a smart plug/light
@@ -397,7 +708,16 @@ type plugJSON struct {
 }
 
 func (ua *UnitAsset) getState() (f forms.SignalA_v1a, err error) {
-	apiURL := "http://" + gateway + "/api/" + ua.Apikey + "/lights/" + ua.Uniqueid
+	// The cached state is used unless it's stale
+	if on, updated, ok := deviceCache.boolean(ua.Uniqueid, "on"); ok {
+		f = getForm(0, "Binary")
+		if on {
+			f.Value = 1
+		}
+		f.Timestamp = updated
+		return f, nil
+	}
+	apiURL := "http://" + gateway + "/api/" + apikey + "/lights/" + ua.Uniqueid
 	req, err := createGetRequest(apiURL)
 	if err != nil {
 		return f, err
@@ -431,14 +751,7 @@ func (ua *UnitAsset) setState(f forms.SignalA_v1a) (err error) {
 // Function to toggle the state of a specific device (power plug or light) on/off and return an error if it occurs
 func (ua *UnitAsset) toggleState(state bool) (err error) {
 	// API call to toggle light/smart plug on/off, PUT call should be sent to URL/api/apikey/lights/[light_id or plug_id]/state
-	apiURL := "http://" + gateway + "/api/" + ua.Apikey + "/lights/" + ua.Uniqueid + "/state"
-	// Create http friendly payload
-	s := fmt.Sprintf(`{"on":%t}`, state) // Create payload
-	req, err := createPutRequest(s, apiURL)
-	if err != nil {
-		return
-	}
-	return sendPutRequest(req)
+	return ua.setLightState(map[string]any{"on": state})
 }
 
 // Functions to create put or get request and return the *http.request and/or error if one occurs
@@ -452,6 +765,25 @@ func createPutRequest(data string, apiURL string) (req *http.Request, err error)
 	return req, nil
 }
 
+func createPostRequest(data string, apiURL string) (req *http.Request, err error) {
+	body := bytes.NewReader([]byte(data))
+	req, err = http.NewRequest(http.MethodPost, apiURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json") // Make sure it's JSON
+	return req, nil
+}
+
+func createDeleteRequest(apiURL string) (req *http.Request, err error) {
+	req, err = http.NewRequest(http.MethodDelete, apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json") // Make sure it's JSON
+	return req, nil
+}
+
 func createGetRequest(apiURL string) (req *http.Request, err error) {
 	req, err = http.NewRequest(http.MethodGet, apiURL, nil)
 	if err != nil {
@@ -480,6 +812,11 @@ func sendPutRequest(req *http.Request) (err error) {
 
 // A function to send get requests and return the data received in the response body as a []byte and/or error if it happens
 func sendGetRequest(req *http.Request) (data []byte, err error) {
+	return sendRequest(req)
+}
+
+// A function to send any request and return the response body, used when the answer from the gateway is needed
+func sendRequest(req *http.Request) (data []byte, err error) {
 	resp, err := http.DefaultClient.Do(req) // Perform the http request
 	if err != nil {
 		return nil, err
@@ -507,6 +844,7 @@ func getForm(value float64, unit string) (f forms.SignalA_v1a) {
 // ------------------------------------------------------------------------------------------------------------
 // IMPORTANT: lumi.plug.maeu01 HAS BEEN KNOWN TO GIVE BAD READINGS, BASICALLY STOP RESPONDING OR RESPOND WITH 0
 // 	      They also don't appear for a long time after re-pairing devices to deConz
+// 	      The stale flag of the health service shows when one of them has stopped responding
 // ------------------------------------------------------------------------------------------------------------
 
 // Struct and method to get and return a form containing current consumption (in Wh)
@@ -520,7 +858,11 @@ type consumptionJSON struct {
 }
 
 func (ua *UnitAsset) getConsumption() (f forms.SignalA_v1a, err error) {
-	apiURL := "http://" + gateway + "/api/" + ua.Apikey + "/sensors/" + ua.Slaves["ZHAConsumption"]
+	// The cached reading is used unless it's stale
+	if f, ok := cachedForm(ua.Slaves["ZHAConsumption"], "consumption", "Wh"); ok {
+		return f, nil
+	}
+	apiURL := "http://" + gateway + "/api/" + apikey + "/sensors/" + ua.Slaves["ZHAConsumption"]
 	// Create a get request
 	req, err := createGetRequest(apiURL)
 	if err != nil {
@@ -554,7 +896,11 @@ type powerJSON struct {
 }
 
 func (ua *UnitAsset) getPower() (f forms.SignalA_v1a, err error) {
-	apiURL := "http://" + gateway + "/api/" + ua.Apikey + "/sensors/" + ua.Slaves["ZHAPower"]
+	// The cached reading is used unless it's stale
+	if f, ok := cachedForm(ua.Slaves["ZHAPower"], "power", "W"); ok {
+		return f, nil
+	}
+	apiURL := "http://" + gateway + "/api/" + apikey + "/sensors/" + ua.Slaves["ZHAPower"]
 	// Create a get request
 	req, err := createGetRequest(apiURL)
 	if err != nil {
@@ -588,7 +934,11 @@ type currentJSON struct {
 }
 
 func (ua *UnitAsset) getCurrent() (f forms.SignalA_v1a, err error) {
-	apiURL := "http://" + gateway + "/api/" + ua.Apikey + "/sensors/" + ua.Slaves["ZHAPower"]
+	// The cached reading is used unless it's stale
+	if f, ok := cachedForm(ua.Slaves["ZHAPower"], "current", "mA"); ok {
+		return f, nil
+	}
+	apiURL := "http://" + gateway + "/api/" + apikey + "/sensors/" + ua.Slaves["ZHAPower"]
 	// Create a get request
 	req, err := createGetRequest(apiURL)
 	if err != nil {
@@ -622,7 +972,11 @@ type voltageJSON struct {
 }
 
 func (ua *UnitAsset) getVoltage() (f forms.SignalA_v1a, err error) {
-	apiURL := "http://" + gateway + "/api/" + ua.Apikey + "/sensors/" + ua.Slaves["ZHAPower"]
+	// The cached reading is used unless it's stale
+	if f, ok := cachedForm(ua.Slaves["ZHAPower"], "voltage", "V"); ok {
+		return f, nil
+	}
+	apiURL := "http://" + gateway + "/api/" + apikey + "/sensors/" + ua.Slaves["ZHAPower"]
 	// Create a get request
 	req, err := createGetRequest(apiURL)
 	if err != nil {
@@ -665,7 +1019,7 @@ type eventJSON struct {
 // If an error occurs it will return that error
 func (ua *UnitAsset) getWebsocketPort() (err error) {
 	// --- Get config ---
-	apiURL := fmt.Sprintf("http://%s/api/%s/config", gateway, ua.Apikey)
+	apiURL := fmt.Sprintf("http://%s/api/%s/config", gateway, apikey)
 	// Create a new request (Get)
 	req, err := http.NewRequest(http.MethodGet, apiURL, nil) // Put request is made
 	if err != nil {
@@ -698,61 +1052,17 @@ func (ua *UnitAsset) getWebsocketPort() (err error) {
 	return
 }
 
-// STRETCH GOAL: Below can also be done with groups, could look into making groups for each switch,
-// and then delete them on shutdown doing it with groups would make it so we don't
-// have to keep track of a global variable and i think if unlucky only change one
-// light or smart plug depending on reachability. Also first click currently always
-// turn lights on, and then start working as intended.
-//
-// This function loops through the "slaves" of a unit asset, and sets them to either
-// true (for on) and false (off), returning an error if it occurs.
-func (ua *UnitAsset) toggleSlaves(currentState bool) (err error) {
-	var req *http.Request
-	for i := range ua.Slaves {
-		// API call to toggle smart plug or lights on/off, PUT call should be sent
-		// to URL/api/apikey/[sensors or lights]/sensor_id/config
-		apiURL := fmt.Sprintf("http://%s/api/%s/lights/%v/state", gateway, ua.Apikey, ua.Slaves[i])
-		// Create http friendly payload
-		s := fmt.Sprintf(`{"on":%t}`, currentState)
-		req, err = createPutRequest(s, apiURL)
-		if err != nil {
-			return
-		}
-		if err = sendPutRequest(req); err != nil {
-			return
-		}
-	}
-	return
-}
-
-// Function starts listening to a websocket, every message received through websocket is read,
-// and checked if it's what we're looking for.
-// The uniqueid (UniqueID in systemconfig.json file) from the connected switch is used to filter out messages
-func (ua *UnitAsset) initWebsocketClient(ctx context.Context) {
-	dialer := websocket.Dialer{}
-	wsURL := fmt.Sprintf("ws://localhost:%s", websocketport)
-	conn, _, err := dialer.Dial(wsURL, nil)
-	if err != nil {
-		log.Fatal("Error occurred while dialing websocket:", err)
-		return
-	}
-	defer conn.Close()
+// Function handles the events from the gateway websocket for the switch, until the system shuts down.
+// The events are already filtered by the uniqueid (UniqueID in systemconfig.json file) of the switch.
+func (ua *UnitAsset) listenForEvents(ctx context.Context, ch <-chan gatewayEvent) {
 	currentState := false
-
 	for {
 		select {
 		case <-ctx.Done(): // Shutdown
 			return
-		default:
-			// Read the message
-			// TODO: this is a blocking call! Might need to handle this read better,
-			// otherwise this goroutine might never be shutdown (from the context).
-			_, b, err := conn.ReadMessage()
-			if err != nil {
-				log.Println("Error occurred while reading message:", err)
-				return
-			}
-			currentState, err = ua.handleWebSocketMsg(currentState, b)
+		case ev := <-ch:
+			var err error
+			currentState, err = ua.handleWebSocketMsg(currentState, ev.Raw)
 			if err != nil {
 				log.Printf("Error handling websocket message: %s", err)
 			}
@@ -770,23 +1080,18 @@ func (ua *UnitAsset) handleWebSocketMsg(currentState bool, body []byte) (newStat
 		return
 	}
 
-	if message.UniqueID == ua.Uniqueid {
-		// Depending on what buttonevent occurred, either turn the slaves on, or off
-		switch message.State.Buttonevent {
-		case 1002: // toggle the smart plugs/lights (lights)
-			newState = !currentState // Toggles the state between true/false
-			err = ua.toggleSlaves(newState)
-			if err != nil {
-				err = fmt.Errorf("toggle slaves to state %v: %w", newState, err)
-				return
-			}
-
-		case 2002:
-			// TODO: Find out how "long presses" works and if it can be used through websocket
-
-		default:
-			// Ignore any other events
-		}
+	if message.UniqueID != ua.Uniqueid {
+		return
+	}
+	// Do what the buttonevent is mapped to, other events are ignored
+	code := strconv.Itoa(message.State.Buttonevent)
+	action, found := ua.actions()[code]
+	if !found {
+		return
+	}
+	newState, err = ua.runAction(code, action, currentState)
+	if err != nil {
+		err = fmt.Errorf("buttonevent %s (%s): %w", code, action.Action, err)
 	}
 	return
 }
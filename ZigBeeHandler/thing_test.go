@@ -35,6 +35,21 @@ func newMockTransport(resp *http.Response, retErr bool, err error) mockTransport
 	return t
 }
 
+// newTestAsset creates a unit asset for a device on a local test gateway, the gateway and API key are
+// restored when the test ends
+func newTestAsset(t *testing.T, name, model string) *UnitAsset {
+	t.Helper()
+	oldGateway, oldAPIKey := gateway, apikey
+	t.Cleanup(func() { gateway, apikey = oldGateway, oldAPIKey })
+	gateway = "localhost:8080"
+	apikey = "1234"
+	ua := initTemplate().(*UnitAsset)
+	ua.Name = name
+	ua.Model = model
+	ua.Uniqueid = strings.ToLower(name)
+	return ua
+}
+
 const discoverExample string = `[{
 		"Id": "123",
 		"Internalipaddress": "localhost",
@@ -251,7 +266,7 @@ func TestToggleState(t *testing.T) {
 
 func TestSendSetPoint(t *testing.T) {
 	// Create mock response and unitasset for sendSetPoint() function
-	fakeBody := fmt.Sprint(`{"Value": 12.4, "Version": "SignalA_v1a}`)
+	fakeBody := fmt.Sprint(`[{"success": {"/sensors/7/config/heatsetpoint": 2000}}]`)
 	resp := &http.Response{
 		Status:     "200 OK",
 		StatusCode: 200,
@@ -881,7 +896,7 @@ func TestGetWebsocketPort(t *testing.T) {
 	}
 }
 
-func TestToggleSlaves(t *testing.T) {
+func TestSetTargets(t *testing.T) {
 	gateway = "localhost:8080"
 	websocketport = "443"
 	ua := initTemplate().(*UnitAsset)
@@ -899,7 +914,7 @@ func TestToggleSlaves(t *testing.T) {
 		Body:       io.NopCloser(strings.NewReader(body)),
 	}
 	newMockTransport(resp, false, nil)
-	err := ua.toggleSlaves(true)
+	err := ua.setTargets(nil, `{"on":true}`)
 	if err != nil {
 		t.Errorf("Expected no errors, got: %v", err)
 	}
@@ -907,7 +922,7 @@ func TestToggleSlaves(t *testing.T) {
 	// --- Bad test case: error during createPutRequest() w/ broken url ---
 	gateway = brokenURL
 	newMockTransport(resp, false, nil)
-	err = ua.toggleSlaves(true)
+	err = ua.setTargets(nil, `{"on":true}`)
 	if err == nil {
 		t.Error("Expected error during createPutRequest (broken url)")
 	}
@@ -915,7 +930,7 @@ func TestToggleSlaves(t *testing.T) {
 	// --- Bad test case: error during sendPutRequest() ---
 	gateway = "localhost:8080"
 	newMockTransport(resp, false, fmt.Errorf("Test error"))
-	ua.toggleSlaves(true)
+	err = ua.setTargets(nil, `{"on":true}`)
 	if err == nil {
 		t.Error("Expected error during sendPutRequest")
 	}
@@ -962,6 +977,10 @@ func TestHandleWebSocketMsg(t *testing.T) {
 
 func TestStartup(t *testing.T) {
 	ua := initTemplate().(*UnitAsset)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sys := components.NewSystem("ZigBeeHandler", ctx)
+	ua.Owner = &sys
 	ua.Model = "test"
 	websocketport = "startup"
 	body := `{"websocketport": "1010"}`
@@ -997,7 +1016,7 @@ func TestStartup(t *testing.T) {
 
 	// --- Good test case: ZHAThermostat switch case ---
 	ua.Model = "ZHAThermostat"
-	body = `{"test": "test ok"}`
+	body = `[{"success": {"/sensors/7/config/heatsetpoint": 2000}}]`
 	resp = &http.Response{
 		Status:     "200 OK",
 		StatusCode: 200,
@@ -45,15 +45,19 @@ func (t mockTransport) RoundTrip(req *http.Request) (resp *http.Response, err er
 ////////////////////////////////////////////////////////////////////////////////
 
 var mockStates = map[string]string{
-	"temperature": `{ "value": 0, "unit": "Celcius", "timestamp": "%s", "version": "SignalA_v1.0" }`,
-	"SEKPrice":    `{ "value": 0.10403, "unit": "SEK", "timestamp": "%s", "version": "SignalA_v1.0" }`,
-	"DesiredTemp": `{ "value": 25, "unit": "Celsius", "timestamp": "%s", "version": "SignalA_v1.0" }`,
-	"setpoint":    `{ "value": 20, "unit": "Celsius", "timestamp": "%s", "version": "SignalA_v1.0" }`,
-	"consumption": `{ "value": 32, "unit": "Wh", "timestamp": "%s", "version": "SignalA_v1.0" }`,
-	"state":       `{ "value": 1, "unit": "Binary", "timestamp": "%s", "version": "SignalA_v1.0" }`,
-	"power":       `{ "value": 330, "unit": "Wh", "timestamp": "%s", "version": "SignalA_v1.0" }`,
-	"current":     `{ "value": 9, "unit": "mA", "timestamp": "%s", "version": "SignalA_v1.0" }`,
-	"voltage":     `{ "value": 229, "unit": "V", "timestamp": "%s", "version": "SignalA_v1.0" }`,
+	"temperature":  `{ "value": 0, "unit": "Celcius", "timestamp": "%s", "version": "SignalA_v1.0" }`,
+	"SEKPrice":     `{ "value": 0.10403, "unit": "SEK", "timestamp": "%s", "version": "SignalA_v1.0" }`,
+	"DesiredTemp":  `{ "value": 25, "unit": "Celsius", "timestamp": "%s", "version": "SignalA_v1.0" }`,
+	"setpoint":     `{ "value": 20, "unit": "Celsius", "timestamp": "%s", "version": "SignalA_v1.0" }`,
+	"heatsetpoint": `{ "value": 20, "unit": "Celsius", "timestamp": "%s", "version": "SignalA_v1.0" }`,
+	"valve":        `{ "value": 35, "unit": "Percent", "timestamp": "%s", "version": "SignalA_v1.0" }`,
+	"consumption":  `{ "value": 32, "unit": "Wh", "timestamp": "%s", "version": "SignalA_v1.0" }`,
+	"state":        `{ "value": 1, "unit": "Binary", "timestamp": "%s", "version": "SignalA_v1.0" }`,
+	"power":        `{ "value": 330, "unit": "Wh", "timestamp": "%s", "version": "SignalA_v1.0" }`,
+	"current":      `{ "value": 9, "unit": "mA", "timestamp": "%s", "version": "SignalA_v1.0" }`,
+	"voltage":      `{ "value": 229, "unit": "V", "timestamp": "%s", "version": "SignalA_v1.0" }`,
+	"reachable":    `{ "value": 1, "unit": "Binary", "timestamp": "%s", "version": "SignalA_v1.0" }`,
+	"stale":        `{ "value": 0, "unit": "Binary", "timestamp": "%s", "version": "SignalA_v1.0" }`,
 }
 
 const (
@@ -102,11 +102,15 @@ func initTemplate() *unitAsset {
 			{"SEKPrice", map[string][]string{"Location": {"Kitchen"}}},
 			{"DesiredTemp", map[string][]string{"Location": {"Kitchen"}}},
 			{"setpoint", map[string][]string{"Location": {"Kitchen"}}},
+			{"heatsetpoint", map[string][]string{"Location": {"Kitchen"}}},
+			{"valve", map[string][]string{"Location": {"Kitchen"}}},
 			{"consumption", map[string][]string{"Location": {"Kitchen"}}},
 			{"state", map[string][]string{"Location": {"Kitchen"}}},
 			{"power", map[string][]string{"Location": {"Kitchen"}}},
 			{"current", map[string][]string{"Location": {"Kitchen"}}},
 			{"voltage", map[string][]string{"Location": {"Kitchen"}}},
+			{"reachable", map[string][]string{"Location": {"Kitchen"}}},
+			{"stale", map[string][]string{"Location": {"Kitchen"}}},
 		},
 	}
 }